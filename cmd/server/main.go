@@ -1,20 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 
-	"github.com/authzed/authzed-go/v1"
-	"github.com/authzed/grpcutil"
 	"github.com/labstack/echo/v4"
 	"github.com/nats-io/nats.go"
 	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
 	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
 	"github.com/sirkartik/cloud_drive_2.0/internal/config"
 	"github.com/sirkartik/cloud_drive_2.0/internal/hooks"
+	"github.com/sirkartik/cloud_drive_2.0/internal/mailer"
 	"github.com/sirkartik/cloud_drive_2.0/internal/storage"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 func main() {
@@ -41,35 +39,44 @@ func main() {
 		return
 	}
 
-	spicedbEndpoint := fmt.Sprintf("%s:%s", app.Cfg.SpiceDB.URL, app.Cfg.SpiceDB.Port)
-	authzedClient, err := authzed.NewClient(
-		spicedbEndpoint,
-		grpcutil.WithInsecureBearerToken(app.Cfg.SpiceDB.Password),
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
-
+	authorizationSvc, err := authorization.NewAuthorizer(app.DB, *app.Cfg)
 	if err != nil {
-		log.Println("Error in spiceDB connection...", err)
+		log.Println("Error setting up authorization...", err)
 		return
 	}
 
-	authenticationSvc := authentication.NewService(app.DB, *app.Cfg)
-	authorizationSvc := authorization.NewService(authzedClient)
+	authenticationSvc := authentication.NewService(app.DB, *app.Cfg, mailer.NewMailer(app.Cfg.SMTP))
 
-	storageSvc := storage.NewService(app.DB, minioStorageClient, *app.Cfg)
+	storageSvc, err := storage.NewService(app.DB, minioStorageClient, *app.Cfg, authorizationSvc)
+	if err != nil {
+		log.Println("Error setting up storage...", err)
+		return
+	}
+	go storageSvc.StartUploadReaper(context.Background())
+	go storageSvc.StartTrashSweeper(context.Background())
+	go storageSvc.StartVersionPruner(context.Background())
 	artifactsSvcHooks := hooks.NewArtifactsSvcHooks(storageSvc, nc)
+	contentIndexHooks := hooks.NewContentIndexHooks(storageSvc)
+	notificationHooks := hooks.NewNotificationHooks(nc)
 
 	storageHookLayer := storage.NewHookLayer(storageSvc)
 
 	// Register On-Video Hook
 	storageHookLayer.RegisterAfterPutHook(artifactsSvcHooks.OnVideo)
 
+	// Register Content Indexing Hook
+	storageHookLayer.RegisterAfterPutHook(contentIndexHooks.OnDocument)
+
+	// Register File Request Notification Hook
+	storageHookLayer.RegisterFileRequestHook(notificationHooks.OnFileRequestUpload)
+
 	e := echo.New()
 	port := app.Cfg.App.RESTPort
 
-	var jwtMiddlewareFunc echo.MiddlewareFunc = authentication.AttachRoutes(e, authenticationSvc)
-	storage.AttachRoutes(e, storageHookLayer, jwtMiddlewareFunc)
-	authorization.AttachRoutes(e, authorizationSvc)
+	jwtMiddlewareFunc, anonymousMiddlewareFunc := authentication.AttachRoutes(e, authenticationSvc)
+	internalMiddlewareFunc := authentication.InternalTokenMiddleware(app.Cfg.App.InternalToken)
+	storage.AttachRoutes(e, storageHookLayer, jwtMiddlewareFunc, anonymousMiddlewareFunc, internalMiddlewareFunc)
+	authorization.AttachRoutes(e, authorizationSvc, internalMiddlewareFunc)
 
 	fmt.Println("Starting server on port", port)
 	e.Start(fmt.Sprintf("%s:%d", app.Cfg.App.HostAddress, port))
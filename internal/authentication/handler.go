@@ -1,7 +1,10 @@
 package authentication
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
@@ -41,7 +44,9 @@ func (h *Handler) LoginHandler(c echo.Context) error {
 
 	user, err := h.svc.LoginService(c.Request().Context(), req.Email, req.Password)
 
-	if err != nil {
+	if errors.Is(err, ErrEmailNotVerified) {
+		return c.JSON(http.StatusForbidden, err.Error())
+	} else if err != nil {
 		return c.JSON(http.StatusUnauthorized, err.Error())
 	}
 
@@ -56,6 +61,39 @@ func (h *Handler) LoginHandler(c echo.Context) error {
 	})
 }
 
+func (h *Handler) VerifyEmailHandler(c echo.Context) error {
+	token := c.QueryParam("token")
+	if token == "" {
+		return c.JSON(http.StatusBadRequest, "missing token param")
+	}
+
+	if err := h.svc.VerifyEmail(c.Request().Context(), token); err != nil {
+		if errors.Is(err, ErrInvalidVerification) {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, "error verifying email")
+	}
+
+	return c.JSON(http.StatusOK, "email verified")
+}
+
+func (h *Handler) ResendVerificationHandler(c echo.Context) error {
+	var req ResendVerificationRequest
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+
+	err := h.svc.ResendVerification(c.Request().Context(), req.Email)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, "error sending verification email")
+	}
+
+	return c.JSON(http.StatusAccepted, "verification email sent if the account needs one")
+}
+
 func (h *Handler) TokenVerificationMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		tok := c.Request().Header.Get("token")
@@ -73,3 +111,40 @@ func (h *Handler) TokenVerificationMiddleware(next echo.HandlerFunc) echo.Handle
 		return next(c)
 	}
 }
+
+// OptionalTokenMiddleware is TokenVerificationMiddleware for public routes.
+// Requests without a token go through as the anonymous principal, a token
+// that is sent must still be valid.
+func (h *Handler) OptionalTokenMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tok := c.Request().Header.Get("token")
+		if tok == "" {
+			c.Set("user", Anonymous())
+			return next(c)
+		}
+		claims, err := h.svc.DecodeToken(&tok, h.svc.jwtSecret)
+
+		if err != nil {
+			fmt.Println("Token verification error: ", err.Error())
+			return echo.NewHTTPError(401, "Invalid or expired token")
+		}
+		c.Set("user", claims)
+
+		return next(c)
+	}
+}
+
+// InternalTokenMiddleware guards routes meant for other services of the
+// deployment, which send the shared token in the internal-token header.
+// Without a configured token every request is refused.
+func InternalTokenMiddleware(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			sent := c.Request().Header.Get("internal-token")
+			if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				return echo.NewHTTPError(http.StatusForbidden, "Invalid internal token")
+			}
+			return next(c)
+		}
+	}
+}
@@ -10,3 +10,7 @@ type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
+
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
@@ -11,4 +11,8 @@ type User struct {
 	Password     string    `gorm:"not null" json:"-"`
 	CreationDate time.Time `gorm:"column:creation_date;autoCreateTime" json:"creation_date"`
 	Role         string    `gorm:"default:user" json:"role"`
+	Verified     bool      `gorm:"not null;default:false" json:"verified"`
+
+	// When the last verification email went out, for rate limiting resends
+	VerificationSentAt *time.Time `json:"-"`
 }
@@ -4,10 +4,14 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
-func AttachRoutes(e *echo.Echo, svc *Service) echo.MiddlewareFunc {
+// AttachRoutes returns the middleware requiring a token, then the one letting
+// anonymous requests through.
+func AttachRoutes(e *echo.Echo, svc *Service) (echo.MiddlewareFunc, echo.MiddlewareFunc) {
 	handler := NewHandler(svc)
 	api := e.Group("/api/auth")
 	api.POST("/register", handler.RegisterHandler)
 	api.POST("/login", handler.LoginHandler)
-	return handler.TokenVerificationMiddleware
+	api.GET("/verify", handler.VerifyEmailHandler)
+	api.POST("/verify/resend", handler.ResendVerificationHandler)
+	return handler.TokenVerificationMiddleware, handler.OptionalTokenMiddleware
 }
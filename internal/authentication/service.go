@@ -3,20 +3,34 @@ package authentication
 import (
 	"context"
 	"errors"
+	"log"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/sirkartik/cloud_drive_2.0/internal/config"
+	"github.com/sirkartik/cloud_drive_2.0/internal/shared"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
-func NewService(DB *gorm.DB, cfg config.Config) *Service {
+func NewService(DB *gorm.DB, cfg config.Config, mailer shared.Mailer) *Service {
+	// Accounts from before verification existed count as verified
+	backfill := !DB.Migrator().HasColumn(&User{}, "verified")
 	DB.AutoMigrate(&User{})
+	if backfill {
+		DB.Model(&User{}).Where("1 = 1").Update("verified", true)
+	}
 	return &Service{
 		db:        DB,
 		jwtSecret: []byte(cfg.JWT.Secret),
 		jwtExpiry: time.Hour * time.Duration(cfg.JWT.ExpiryHour),
+
+		mailer:              mailer,
+		verificationSecret:  []byte("email-verification:" + cfg.JWT.Secret),
+		verificationExpiry:  cfg.SMTP.VerificationExpiry,
+		verificationURL:     cfg.SMTP.VerificationURL,
+		resendInterval:      cfg.SMTP.ResendInterval,
+		requireVerification: cfg.SMTP.RequireVerification,
 	}
 }
 
@@ -35,9 +49,26 @@ func (svc *Service) RegisterService(ctx context.Context, email string, username
 		Username: username,
 		Password: string(hashedPassword),
 	}
+	if svc.requireVerification {
+		sentAt := time.Now()
+		user.VerificationSentAt = &sentAt
+	}
 
 	result := svc.db.WithContext(ctx).Create(&user)
-	return result.Error
+	if result.Error != nil {
+		return result.Error
+	}
+	if !svc.requireVerification {
+		return nil
+	}
+
+	// The account stays, a lost email can be sent again
+	go func() {
+		if err := svc.sendVerification(&user); err != nil {
+			log.Println("Error sending verification email:", err)
+		}
+	}()
+	return nil
 }
 
 func (svc *Service) LoginService(ctx context.Context, email string, password string) (*User, error) {
@@ -55,6 +86,9 @@ func (svc *Service) LoginService(ctx context.Context, email string, password str
 	if passwordError != nil {
 		return nil, errors.New("InvalidPassword")
 	}
+	if svc.requireVerification && !user.Verified {
+		return nil, ErrEmailNotVerified
+	}
 	return &user, nil
 }
 
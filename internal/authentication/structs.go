@@ -1,9 +1,11 @@
 package authentication
 
 import (
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirkartik/cloud_drive_2.0/internal/shared"
 	"gorm.io/gorm"
 )
 
@@ -11,8 +13,29 @@ type Service struct {
 	db        *gorm.DB
 	jwtSecret []byte
 	jwtExpiry time.Duration
+
+	mailer shared.Mailer
+	// Verification tokens are signed with a key of their own, so they can't
+	// pass for login tokens or the other way round
+	verificationSecret  []byte
+	verificationExpiry  time.Duration
+	verificationURL     string
+	resendInterval      time.Duration
+	requireVerification bool
+}
+
+// VerificationClaims identify the account and address a verification link
+// was sent for.
+type VerificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
 }
 
+var (
+	ErrEmailNotVerified    = errors.New("EmailNotVerified")
+	ErrInvalidVerification = errors.New("InvalidVerificationToken")
+)
+
 type CustomClaims struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
@@ -20,3 +43,23 @@ type CustomClaims struct {
 	ID       uint64 `json:"id"`
 	jwt.RegisteredClaims
 }
+
+// AnonymousID is the user ID of the anonymous principal. No account has it,
+// so anonymous requests only get what is granted to everyone.
+const AnonymousID uint64 = 0
+
+// RoleAnonymous is the role of unauthenticated requests on public routes.
+const RoleAnonymous = "anonymous"
+
+// Anonymous returns the principal standing for an unauthenticated request.
+func Anonymous() *CustomClaims {
+	return &CustomClaims{
+		Username: RoleAnonymous,
+		Role:     RoleAnonymous,
+		ID:       AnonymousID,
+	}
+}
+
+func (claims *CustomClaims) IsAnonymous() bool {
+	return claims.ID == AnonymousID
+}
@@ -0,0 +1,126 @@
+package authentication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+func (svc *Service) generateVerificationToken(user *User) (string, error) {
+	now := time.Now()
+	claims := VerificationClaims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(user.ID, 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(svc.verificationExpiry)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(svc.verificationSecret)
+}
+
+// sendVerification emails the user a link to the verify endpoint.
+func (svc *Service) sendVerification(user *User) error {
+	token, err := svc.generateVerificationToken(user)
+	if err != nil {
+		return err
+	}
+
+	link := svc.verificationURL + "?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf(
+		"Hi %s,\n\nConfirm your email address by opening the link below. It expires in %d hours.\n\n%s\n",
+		user.Username,
+		int(svc.verificationExpiry.Hours()),
+		link,
+	)
+	return svc.mailer.Send(user.Email, "Confirm your email address", body)
+}
+
+// parseVerificationToken returns the account and address a verification
+// token was issued for.
+func (svc *Service) parseVerificationToken(token string) (uint64, string, error) {
+	var claims VerificationClaims
+	parsed, err := jwt.ParseWithClaims(
+		token,
+		&claims,
+		func(t *jwt.Token) (any, error) {
+			return svc.verificationSecret, nil
+		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !parsed.Valid {
+		return 0, "", ErrInvalidVerification
+	}
+	userID, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidVerification
+	}
+	return userID, claims.Email, nil
+}
+
+// VerifyEmail marks the account a verification token was issued for as
+// verified. Tokens for an address the account no longer has are refused.
+func (svc *Service) VerifyEmail(ctx context.Context, token string) error {
+	userID, email, err := svc.parseVerificationToken(token)
+	if err != nil {
+		return err
+	}
+
+	result := svc.db.WithContext(ctx).
+		Model(&User{}).
+		Where("id = ? AND email = ?", userID, email).
+		Update("verified", true)
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return ErrInvalidVerification
+	}
+	return nil
+}
+
+func (svc *Service) ResendVerification(ctx context.Context, email string) error {
+	if email == "" {
+		return errors.New("email cannot be empty")
+	}
+
+	var user User
+	err := svc.db.WithContext(ctx).Where("email = ?", email).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if user.Verified {
+		return nil
+	}
+	if user.VerificationSentAt != nil && time.Since(*user.VerificationSentAt) < svc.resendInterval {
+		return nil
+	}
+
+	// Claim the resend, so concurrent requests don't both send
+	result := svc.db.WithContext(ctx).
+		Model(&User{}).
+		Where("id = ? AND (verification_sent_at IS NULL OR verification_sent_at < ?)", user.ID, time.Now().Add(-svc.resendInterval)).
+		Update("verification_sent_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return nil
+	}
+
+	go func() {
+		if err := svc.sendVerification(&user); err != nil {
+			log.Println("Error sending verification email:", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,71 @@
+package authentication
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func verificationService(expiry time.Duration) *Service {
+	return &Service{
+		jwtSecret:          []byte("secret"),
+		jwtExpiry:          time.Hour,
+		verificationSecret: []byte("email-verification:secret"),
+		verificationExpiry: expiry,
+	}
+}
+
+func TestVerificationTokenRoundTrip(t *testing.T) {
+	svc := verificationService(time.Hour)
+	user := &User{ID: 42, Email: "alice@example.com"}
+
+	token, err := svc.generateVerificationToken(user)
+	if err != nil {
+		t.Fatalf("generateVerificationToken failed: %v", err)
+	}
+	userID, email, err := svc.parseVerificationToken(token)
+	if err != nil {
+		t.Fatalf("parseVerificationToken failed: %v", err)
+	}
+	if userID != user.ID || email != user.Email {
+		t.Errorf("parseVerificationToken = %d, %q, want %d, %q", userID, email, user.ID, user.Email)
+	}
+}
+
+func TestVerificationTokenRejects(t *testing.T) {
+	svc := verificationService(time.Hour)
+	user := &User{ID: 42, Email: "alice@example.com"}
+
+	expired, err := verificationService(-time.Minute).generateVerificationToken(user)
+	if err != nil {
+		t.Fatalf("generateVerificationToken failed: %v", err)
+	}
+	other := verificationService(time.Hour)
+	other.verificationSecret = []byte("email-verification:other")
+	foreign, err := other.generateVerificationToken(user)
+	if err != nil {
+		t.Fatalf("generateVerificationToken failed: %v", err)
+	}
+	login, err := svc.GenerateToken(user)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", expired},
+		{"other secret", foreign},
+		{"login token", login},
+		{"garbage", "not.a.token"},
+		{"empty", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := svc.parseVerificationToken(tt.token); !errors.Is(err, ErrInvalidVerification) {
+				t.Errorf("parseVerificationToken(%q) = %v, want ErrInvalidVerification", tt.token, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package authorization
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ZedTokens remembered for freshness checks, and subjects for invalidation,
+// forgotten wholesale beyond that
+const maxTrackedTokens = 10000
+
+type checkKey struct {
+	subjectType, subjectID   string
+	resourceType, resourceID string
+	permission               string
+}
+
+func (key checkKey) subject() string {
+	return key.subjectType + ":" + key.subjectID
+}
+
+type checkEntry struct {
+	allowed bool
+	expires time.Time
+	// Generation the check started in
+	generation uint64
+	// Generation the check was known to be fresh for, zero when it was made
+	// with minimized latency or against a token written elsewhere
+	freshFor uint64
+}
+
+// checkCache keeps permission check results for a short while. Every
+// relationship write made through the service starts a new generation. A
+// write whose subjects are all single users, like a grant or a workspace
+// membership, only changes those users' checks and only drops their entries.
+// Any other write, like a move or a group nested in another, can change
+// permissions on a whole subtree for anyone and drops everything cached
+// before it.
+//
+// ZedTokens are opaque, but the ones this service wrote are remembered along
+// with the generation they started. A check asking to be at least as fresh
+// as one of them is only served from entries checked against that token or a
+// later one. Unknown tokens, written elsewhere or before a restart, always
+// go to SpiceDB; stats count them, as until the tokens stored with nodes are
+// replaced by new writes, such checks can't be cached.
+type checkCache struct {
+	ttl     time.Duration
+	maxSize int
+
+	mu         sync.Mutex
+	entries    map[checkKey]checkEntry
+	generation uint64
+	// Generation of the last write that could affect any subject, and of
+	// the last one for each subject since
+	clearedAt     uint64
+	subjectWrites map[string]uint64
+	tokens        map[string]uint64
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	unknownTokens atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+func newCheckCache(ttl time.Duration, maxSize int) *checkCache {
+	return &checkCache{
+		ttl:           ttl,
+		maxSize:       maxSize,
+		entries:       make(map[checkKey]checkEntry),
+		generation:    1,
+		subjectWrites: make(map[string]uint64),
+		tokens:        make(map[string]uint64),
+	}
+}
+
+// affectedSubjects lists the subjects whose checks a write of relationships
+// can change, nil when it may change anyone's. Users end every path through
+// the schema, so a relationship to a single user only changes that user's
+// checks; everything else can lead on to other subjects.
+func affectedSubjects(writes ...[]Relationship) []string {
+	subjects := []string{}
+	for _, relationships := range writes {
+		for _, r := range relationships {
+			if r.SubjectType != SubjectUser || r.SubjectRelation != "" || r.SubjectID == AnyUser {
+				return nil
+			}
+			subjects = append(subjects, r.SubjectType+":"+r.SubjectID)
+		}
+	}
+	return subjects
+}
+
+// writtenSince reports whether a write that may change key's answer came
+// after generation. The caller holds mu.
+func (cache *checkCache) writtenSince(key checkKey, generation uint64) bool {
+	return cache.clearedAt > generation || cache.subjectWrites[key.subject()] > generation
+}
+
+func (cache *checkCache) enabled() bool {
+	return cache != nil && cache.ttl > 0
+}
+
+// freshness is the generation a check has to be fresh for, and whether the
+// cache can answer it at all.
+func (cache *checkCache) freshness(zedToken string) (uint64, bool) {
+	if zedToken == "" {
+		return 0, true
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	generation, ok := cache.tokens[zedToken]
+	return generation, ok
+}
+
+// get looks a check up. On a miss, the generation it returns goes to put
+// along with the answer from SpiceDB.
+func (cache *checkCache) get(key checkKey, zedToken string) (allowed bool, hit bool, generation uint64) {
+	if !cache.enabled() {
+		return false, false, 0
+	}
+	required, known := cache.freshness(zedToken)
+
+	cache.mu.Lock()
+	entry, ok := cache.entries[key]
+	generation = cache.generation
+	stale := ok && cache.writtenSince(key, entry.generation)
+	cache.mu.Unlock()
+
+	if !known {
+		cache.unknownTokens.Add(1)
+	}
+	if !known || !ok || stale || time.Now().After(entry.expires) || entry.freshFor < required {
+		cache.misses.Add(1)
+		return false, false, generation
+	}
+	cache.hits.Add(1)
+	return entry.allowed, true, generation
+}
+
+// put stores the answer to a check that started in generation. Answers that
+// a write may have overtaken in the meantime are dropped.
+func (cache *checkCache) put(key checkKey, zedToken string, allowed bool, generation uint64) {
+	if !cache.enabled() {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.writtenSince(key, generation) {
+		return
+	}
+	var freshFor uint64
+	if zedToken != "" {
+		tokenGeneration, ok := cache.tokens[zedToken]
+		if !ok {
+			return
+		}
+		freshFor = tokenGeneration
+	}
+	// Full caches start over rather than tracking what to evict
+	if len(cache.entries) >= cache.maxSize {
+		cache.entries = make(map[checkKey]checkEntry)
+	}
+	cache.entries[key] = checkEntry{
+		allowed:    allowed,
+		expires:    time.Now().Add(cache.ttl),
+		generation: generation,
+		freshFor:   freshFor,
+	}
+}
+
+// invalidate starts a new generation after a relationship write, which
+// zedToken is the result of. Only the checks of subjects are dropped, or
+// every check when subjects is nil.
+func (cache *checkCache) invalidate(zedToken string, subjects []string) {
+	if !cache.enabled() {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.generation++
+	if subjects == nil || len(cache.subjectWrites)+len(subjects) > maxTrackedTokens {
+		cache.clearedAt = cache.generation
+		cache.entries = make(map[checkKey]checkEntry)
+		cache.subjectWrites = make(map[string]uint64)
+	} else {
+		for _, subject := range subjects {
+			cache.subjectWrites[subject] = cache.generation
+		}
+	}
+	if len(cache.tokens) >= maxTrackedTokens {
+		cache.tokens = make(map[string]uint64)
+	}
+	if zedToken != "" {
+		cache.tokens[zedToken] = cache.generation
+	}
+	cache.invalidations.Add(1)
+}
+
+func (cache *checkCache) stats() CacheStats {
+	stats := CacheStats{Enabled: cache.enabled()}
+	if cache == nil {
+		return stats
+	}
+	stats.Hits = cache.hits.Load()
+	stats.Misses = cache.misses.Load()
+	stats.UnknownTokens = cache.unknownTokens.Load()
+	stats.Invalidations = cache.invalidations.Load()
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+
+	cache.mu.Lock()
+	stats.Entries = len(cache.entries)
+	cache.mu.Unlock()
+	return stats
+}
@@ -0,0 +1,103 @@
+package authorization
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAffectedSubjects(t *testing.T) {
+	tests := []struct {
+		name          string
+		relationships []Relationship
+		want          []string
+	}{
+		{"user grant", []Relationship{{ResourceNode, "n1", RelationViewer, SubjectUser, "1", ""}}, []string{"user:1"}},
+		{"workspace member", []Relationship{{ResourceWorkspace, "w1", RelationMember, SubjectUser, "2", ""}}, []string{"user:2"}},
+		{"every user", []Relationship{{ResourceNode, "n1", RelationViewer, SubjectUser, AnyUser, ""}}, nil},
+		{"group grant", []Relationship{{ResourceNode, "n1", RelationViewer, ResourceGroup, "g1", RelationMember}}, nil},
+		{"parent", []Relationship{{ResourceNode, "n1", RelationParent, ResourceNode, "n2", ""}}, nil},
+		{"mixed", []Relationship{
+			{ResourceNode, "n1", RelationViewer, SubjectUser, "1", ""},
+			{ResourceNode, "n1", RelationParent, ResourceNode, "n2", ""},
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := affectedSubjects(tt.relationships)
+			if (got == nil) != (tt.want == nil) || len(got) != len(tt.want) {
+				t.Fatalf("affectedSubjects = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("affectedSubjects = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckCacheInvalidation(t *testing.T) {
+	alice := checkKey{SubjectUser, "1", ResourceNode, "n1", PermissionRead}
+	bob := checkKey{SubjectUser, "2", ResourceNode, "n1", PermissionRead}
+
+	cache := newCheckCache(time.Minute, 100)
+	fill := func() {
+		for _, key := range []checkKey{alice, bob} {
+			_, _, generation := cache.get(key, "")
+			cache.put(key, "", true, generation)
+		}
+	}
+	cached := func(key checkKey) bool {
+		_, hit, _ := cache.get(key, "")
+		return hit
+	}
+
+	fill()
+	cache.invalidate("t1", []string{"user:1"})
+	if cached(alice) {
+		t.Error("alice's check survived a write to alice")
+	}
+	if !cached(bob) {
+		t.Error("bob's check was dropped by a write to alice")
+	}
+
+	fill()
+	cache.invalidate("t2", nil)
+	if cached(alice) || cached(bob) {
+		t.Error("checks survived a write that may affect anyone")
+	}
+
+	// An answer that started before a write to its subject is stale
+	_, _, generation := cache.get(alice, "")
+	cache.invalidate("t3", []string{"user:1"})
+	cache.put(alice, "", true, generation)
+	if cached(alice) {
+		t.Error("an answer overtaken by a write was cached")
+	}
+}
+
+func TestCheckCacheTokens(t *testing.T) {
+	key := checkKey{SubjectUser, "1", ResourceNode, "n1", PermissionRead}
+	cache := newCheckCache(time.Minute, 100)
+	cache.invalidate("t1", []string{"user:2"})
+
+	// Minimized latency answers aren't fresh enough for a known token
+	_, _, generation := cache.get(key, "")
+	cache.put(key, "", true, generation)
+	if _, hit, _ := cache.get(key, "t1"); hit {
+		t.Error("a minimized latency answer served a check at t1")
+	}
+
+	_, _, generation = cache.get(key, "t1")
+	cache.put(key, "t1", true, generation)
+	if _, hit, _ := cache.get(key, "t1"); !hit {
+		t.Error("an answer checked at t1 didn't serve a check at t1")
+	}
+
+	if _, hit, _ := cache.get(key, "unknown"); hit {
+		t.Error("a check against an unknown token was served from the cache")
+	}
+	if stats := cache.stats(); stats.UnknownTokens != 1 {
+		t.Errorf("stats counted %d unknown tokens, want 1", stats.UnknownTokens)
+	}
+}
@@ -9,7 +9,7 @@ import (
 	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
 )
 
-func NewHandler(svc *Service) *Handler {
+func NewHandler(svc Authorizer) *Handler {
 	return &Handler{
 		svc: svc,
 	}
@@ -56,3 +56,7 @@ func (h *Handler) CheckPermission(
 		}
 	}
 }
+
+func (h *Handler) CacheStats(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.svc.CacheStats())
+}
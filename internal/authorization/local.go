@@ -0,0 +1,407 @@
+package authorization
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Relationships inserted per statement
+const localBatchSize = 500
+
+// StoredRelationship is a relationship as LocalService keeps it.
+type StoredRelationship struct {
+	ResourceType    string `gorm:"primaryKey"`
+	ResourceID      string `gorm:"primaryKey"`
+	Relation        string `gorm:"primaryKey"`
+	SubjectType     string `gorm:"primaryKey;index:idx_stored_relationship_subject"`
+	SubjectID       string `gorm:"primaryKey;index:idx_stored_relationship_subject"`
+	SubjectRelation string `gorm:"primaryKey"`
+}
+
+func NewLocalService(DB *gorm.DB) (*LocalService, error) {
+	definitions, err := parseSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	if err := DB.AutoMigrate(&StoredRelationship{}); err != nil {
+		return nil, err
+	}
+	return &LocalService{
+		db:     DB,
+		schema: definitions,
+	}, nil
+}
+
+// revision stands in for a ZedToken. Postgres reads always see earlier
+// writes, so nothing ever waits on it.
+func revision() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
+func (svc *LocalService) WriteRelationship(
+	ctx context.Context,
+	resourceType, resourceID, relation, subjectType, subjectID, subjectRelation string,
+) (string, error) {
+	return svc.WriteRelationships(ctx, []Relationship{{
+		ResourceType:    resourceType,
+		ResourceID:      resourceID,
+		Relation:        relation,
+		SubjectType:     subjectType,
+		SubjectID:       subjectID,
+		SubjectRelation: subjectRelation,
+	}}, nil)
+}
+
+// WriteRelationships touches and deletes relationships in one transaction.
+// Relationships the schema doesn't allow are refused, as SpiceDB would.
+func (svc *LocalService) WriteRelationships(
+	ctx context.Context,
+	touch []Relationship,
+	remove []Relationship,
+) (string, error) {
+	rows := make([]StoredRelationship, 0, len(touch))
+	for _, r := range touch {
+		if !svc.schema.allows(r) {
+			return "", fmt.Errorf("relation %s#%s doesn't allow subject %s:%s", r.ResourceType, r.Relation, r.SubjectType, r.SubjectID)
+		}
+		rows = append(rows, StoredRelationship(r))
+	}
+
+	err := svc.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if len(rows) > 0 {
+			err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				CreateInBatches(&rows, localBatchSize).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, r := range remove {
+			err := tx.Where(
+				"resource_type = ? AND resource_id = ? AND relation = ? AND subject_type = ? AND subject_id = ? AND subject_relation = ?",
+				r.ResourceType, r.ResourceID, r.Relation, r.SubjectType, r.SubjectID, r.SubjectRelation,
+			).Delete(&StoredRelationship{}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return revision(), nil
+}
+
+func (svc *LocalService) DeleteResourceRelationships(
+	ctx context.Context,
+	resourceType string,
+	resourceIDs []string,
+) (string, error) {
+	if len(resourceIDs) > 0 {
+		err := svc.db.WithContext(ctx).
+			Where("resource_type = ? AND resource_id IN ?", resourceType, resourceIDs).
+			Delete(&StoredRelationship{}).Error
+		if err != nil {
+			return "", err
+		}
+	}
+	return revision(), nil
+}
+
+func (svc *LocalService) DeleteSubjectRelationships(
+	ctx context.Context,
+	resourceTypes []string,
+	subjectType, subjectID string,
+) (string, error) {
+	if len(resourceTypes) > 0 {
+		err := svc.db.WithContext(ctx).
+			Where("resource_type IN ? AND subject_type = ? AND subject_id = ?", resourceTypes, subjectType, subjectID).
+			Delete(&StoredRelationship{}).Error
+		if err != nil {
+			return "", err
+		}
+	}
+	return revision(), nil
+}
+
+func (svc *LocalService) ReadRelationships(
+	ctx context.Context,
+	resourceType, resourceID string,
+	zedToken string,
+) ([]Relationship, error) {
+	var rows []StoredRelationship
+	err := svc.db.WithContext(ctx).
+		Where("resource_type = ? AND resource_id = ?", resourceType, resourceID).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var relationships []Relationship
+	for _, row := range rows {
+		relationships = append(relationships, Relationship(row))
+	}
+	return relationships, nil
+}
+
+// LookupResources checks every resource of the type that has relationships
+// of its own, which is fine for the small deployments this is meant for.
+func (svc *LocalService) LookupResources(
+	ctx context.Context,
+	resourceType string,
+	permission string,
+	subjectType, subjectID string,
+) ([]string, error) {
+	var candidates []string
+	err := svc.db.WithContext(ctx).
+		Model(&StoredRelationship{}).
+		Where("resource_type = ?", resourceType).
+		Distinct().
+		Pluck("resource_id", &candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	eval := svc.newEvaluation(ctx, subjectType, subjectID)
+	var resourceIDs []string
+	for _, candidate := range candidates {
+		allowed, err := eval.check(resourceType, candidate, permission)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			resourceIDs = append(resourceIDs, candidate)
+		}
+	}
+	return resourceIDs, nil
+}
+
+// LookupResourcesPage checks candidates in ID order, the last one checked
+// being the cursor.
+func (svc *LocalService) LookupResourcesPage(
+	ctx context.Context,
+	resourceType string,
+	permission string,
+	subjectType, subjectID string,
+	cursor string,
+	limit int,
+) ([]LookupResult, string, error) {
+	eval := svc.newEvaluation(ctx, subjectType, subjectID)
+	var results []LookupResult
+	for len(results) < limit {
+		var candidates []string
+		err := svc.db.WithContext(ctx).
+			Model(&StoredRelationship{}).
+			Where("resource_type = ? AND resource_id > ?", resourceType, cursor).
+			Distinct().
+			Order("resource_id").
+			Limit(localBatchSize).
+			Pluck("resource_id", &candidates).Error
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, candidate := range candidates {
+			cursor = candidate
+			allowed, err := eval.check(resourceType, candidate, permission)
+			if err != nil {
+				return nil, "", err
+			}
+			if allowed {
+				results = append(results, LookupResult{ResourceID: candidate, Cursor: candidate})
+				if len(results) == limit {
+					return results, cursor, nil
+				}
+			}
+		}
+		if len(candidates) < localBatchSize {
+			return results, "", nil
+		}
+	}
+	return results, cursor, nil
+}
+
+func (svc *LocalService) CheckPermOnResource(
+	ctx context.Context,
+	subjectType, subjectID string,
+	resourceType, resourceID string,
+	permission string,
+	precise bool,
+	zedToken string,
+) (bool, error) {
+	return svc.newEvaluation(ctx, subjectType, subjectID).
+		check(resourceType, resourceID, permission)
+}
+
+// CheckBulkPermissions shares what it learns between checks for the same
+// subject, so siblings in a listing only walk their common ancestors once.
+func (svc *LocalService) CheckBulkPermissions(
+	ctx context.Context,
+	checks []PermissionCheck,
+	zedToken string,
+) ([]bool, error) {
+	results := make([]bool, len(checks))
+	evaluations := map[string]*evaluation{}
+	for i, check := range checks {
+		subject := check.SubjectType + ":" + check.SubjectID
+		eval, ok := evaluations[subject]
+		if !ok {
+			eval = svc.newEvaluation(ctx, check.SubjectType, check.SubjectID)
+			evaluations[subject] = eval
+		}
+		allowed, err := eval.check(check.ResourceType, check.ResourceID, check.Permission)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = allowed
+	}
+	return results, nil
+}
+
+// CacheStats reports a disabled cache, LocalService has none.
+func (svc *LocalService) CacheStats() CacheStats {
+	return CacheStats{}
+}
+
+// relationshipLoader returns the relationships of one relation of an object.
+type relationshipLoader func(objectType, objectID, relation string) ([]StoredRelationship, error)
+
+// evaluation answers checks for one subject, remembering every relation and
+// permission it resolved along the way.
+type evaluation struct {
+	load        relationshipLoader
+	schema      schemaDefinitions
+	subjectType string
+	subjectID   string
+
+	resolved map[string]bool
+	// Depth on the stack of each check in progress, and the shallowest one
+	// a cycle was cut at since the innermost check started
+	visiting  map[string]int
+	lowestCut int
+}
+
+func (svc *LocalService) newEvaluation(ctx context.Context, subjectType, subjectID string) *evaluation {
+	db := svc.db.WithContext(ctx)
+	return newEvaluation(svc.schema, subjectType, subjectID, func(objectType, objectID, relation string) ([]StoredRelationship, error) {
+		var rows []StoredRelationship
+		err := db.
+			Where("resource_type = ? AND resource_id = ? AND relation = ?", objectType, objectID, relation).
+			Find(&rows).Error
+		return rows, err
+	})
+}
+
+func newEvaluation(schema schemaDefinitions, subjectType, subjectID string, load relationshipLoader) *evaluation {
+	return &evaluation{
+		load:        load,
+		schema:      schema,
+		subjectType: subjectType,
+		subjectID:   subjectID,
+		resolved:    map[string]bool{},
+		visiting:    map[string]int{},
+		lowestCut:   math.MaxInt,
+	}
+}
+
+// check resolves a relation or permission of an object for the subject.
+// Cycles in the relationships, which the schema allows for parents and
+// nested groups, count as no access rather than looping. A denial that
+// relied on cutting a cycle at a check further up the stack isn't final,
+// that check may yet succeed, so it is only remembered when every cycle cut
+// below it led back to itself.
+func (eval *evaluation) check(objectType, objectID, name string) (bool, error) {
+	key := objectType + ":" + objectID + "#" + name
+	if allowed, ok := eval.resolved[key]; ok {
+		return allowed, nil
+	}
+	if depth, ok := eval.visiting[key]; ok {
+		eval.lowestCut = min(eval.lowestCut, depth)
+		return false, nil
+	}
+	depth := len(eval.visiting)
+	eval.visiting[key] = depth
+	defer delete(eval.visiting, key)
+
+	outerCut := eval.lowestCut
+	eval.lowestCut = math.MaxInt
+	defer func() {
+		eval.lowestCut = min(outerCut, eval.lowestCut)
+	}()
+
+	definition, ok := eval.schema[objectType]
+	if !ok {
+		return false, fmt.Errorf("unknown object type %q", objectType)
+	}
+
+	var allowed bool
+	var err error
+	if terms, ok := definition.permissions[name]; ok {
+		allowed, err = eval.union(objectType, objectID, terms)
+	} else if _, ok := definition.relations[name]; ok {
+		allowed, err = eval.relation(objectType, objectID, name)
+	} else {
+		return false, fmt.Errorf("%s has no relation or permission %q", objectType, name)
+	}
+	if err != nil {
+		return false, err
+	}
+	if allowed || eval.lowestCut >= depth {
+		eval.resolved[key] = allowed
+	}
+	return allowed, nil
+}
+
+func (eval *evaluation) union(objectType, objectID string, terms []schemaTerm) (bool, error) {
+	for _, term := range terms {
+		if term.arrow == "" {
+			allowed, err := eval.check(objectType, objectID, term.name)
+			if allowed || err != nil {
+				return allowed, err
+			}
+			continue
+		}
+
+		related, err := eval.related(objectType, objectID, term.name)
+		if err != nil {
+			return false, err
+		}
+		for _, row := range related {
+			allowed, err := eval.check(row.SubjectType, row.SubjectID, term.arrow)
+			if allowed || err != nil {
+				return allowed, err
+			}
+		}
+	}
+	return false, nil
+}
+
+func (eval *evaluation) relation(objectType, objectID, relation string) (bool, error) {
+	related, err := eval.related(objectType, objectID, relation)
+	if err != nil {
+		return false, err
+	}
+	for _, row := range related {
+		if row.SubjectRelation == "" {
+			if row.SubjectType == eval.subjectType && (row.SubjectID == eval.subjectID || row.SubjectID == AnyUser) {
+				return true, nil
+			}
+			continue
+		}
+		allowed, err := eval.check(row.SubjectType, row.SubjectID, row.SubjectRelation)
+		if allowed || err != nil {
+			return allowed, err
+		}
+	}
+	return false, nil
+}
+
+func (eval *evaluation) related(objectType, objectID, relation string) ([]StoredRelationship, error) {
+	return eval.load(objectType, objectID, relation)
+}
@@ -4,8 +4,10 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
-func AttachRoutes(e *echo.Echo, svc *Service) {
-	// handler := NewHandler(svc)
+func AttachRoutes(e *echo.Echo, svc Authorizer, internalMiddleware echo.MiddlewareFunc) {
+	handler := NewHandler(svc)
 	// api := e.Group("/api")
-	
+
+	internalApi := e.Group("/internal")
+	internalApi.GET("/authz/cache", handler.CacheStats, internalMiddleware)
 }
@@ -0,0 +1,133 @@
+package authorization
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var schemaIdentifier = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// schemaTerm is one operand of a permission: a relation or permission of
+// the same object, or with arrow set, that permission on every object the
+// relation points to.
+type schemaTerm struct {
+	name  string
+	arrow string
+}
+
+type schemaDefinition struct {
+	// Subject types each relation accepts, like user, user:* or group#member
+	relations   map[string][]string
+	permissions map[string][]schemaTerm
+}
+
+type schemaDefinitions map[string]*schemaDefinition
+
+// parseSchema reads the subset of the SpiceDB schema language schema.zed is
+// written in: definitions with relations, and permissions made of unions
+// and arrows. Anything else, like exclusions, intersections or parentheses,
+// is refused rather than evaluated wrongly.
+func parseSchema(source string) (schemaDefinitions, error) {
+	definitions := schemaDefinitions{}
+	var current *schemaDefinition
+
+	for number, line := range strings.Split(source, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "definition "):
+			if current != nil {
+				return nil, fmt.Errorf("schema line %d: nested definition", number+1)
+			}
+			name, rest, _ := strings.Cut(strings.TrimPrefix(line, "definition "), "{")
+			current = &schemaDefinition{
+				relations:   map[string][]string{},
+				permissions: map[string][]schemaTerm{},
+			}
+			definitions[strings.TrimSpace(name)] = current
+			if strings.TrimSpace(rest) == "}" {
+				current = nil
+			}
+		case line == "}":
+			current = nil
+		case current == nil:
+			return nil, fmt.Errorf("schema line %d: expected a definition", number+1)
+		case strings.HasPrefix(line, "relation "):
+			name, subjects, ok := strings.Cut(strings.TrimPrefix(line, "relation "), ":")
+			if !ok {
+				return nil, fmt.Errorf("schema line %d: relation without subject types", number+1)
+			}
+			var allowed []string
+			for _, subject := range strings.Split(subjects, "|") {
+				subject = strings.TrimSpace(subject)
+				if !validSubjectType(subject) {
+					return nil, fmt.Errorf("schema line %d: unsupported subject type %q", number+1, subject)
+				}
+				allowed = append(allowed, subject)
+			}
+			current.relations[strings.TrimSpace(name)] = allowed
+		case strings.HasPrefix(line, "permission "):
+			name, expression, ok := strings.Cut(strings.TrimPrefix(line, "permission "), "=")
+			if !ok {
+				return nil, fmt.Errorf("schema line %d: permission without expression", number+1)
+			}
+			var terms []schemaTerm
+			for _, operand := range strings.Split(expression, "+") {
+				relation, arrow, isArrow := strings.Cut(strings.TrimSpace(operand), "->")
+				term := schemaTerm{
+					name:  strings.TrimSpace(relation),
+					arrow: strings.TrimSpace(arrow),
+				}
+				if !schemaIdentifier.MatchString(term.name) || (isArrow && !schemaIdentifier.MatchString(term.arrow)) {
+					return nil, fmt.Errorf("schema line %d: unsupported expression %q", number+1, strings.TrimSpace(expression))
+				}
+				terms = append(terms, term)
+			}
+			current.permissions[strings.TrimSpace(name)] = terms
+		default:
+			return nil, fmt.Errorf("schema line %d: unsupported statement %q", number+1, line)
+		}
+	}
+	return definitions, nil
+}
+
+// validSubjectType accepts the subject types of a relation: a type, every
+// user of a type, or a relation of a type.
+func validSubjectType(subject string) bool {
+	if subjectType, ok := strings.CutSuffix(subject, ":*"); ok {
+		return schemaIdentifier.MatchString(subjectType)
+	}
+	subjectType, relation, ok := strings.Cut(subject, "#")
+	if ok && !schemaIdentifier.MatchString(relation) {
+		return false
+	}
+	return schemaIdentifier.MatchString(subjectType)
+}
+
+// allows reports whether a relation accepts a subject, the way SpiceDB
+// validates writes.
+func (definitions schemaDefinitions) allows(r Relationship) bool {
+	definition, ok := definitions[r.ResourceType]
+	if !ok {
+		return false
+	}
+	subject := r.SubjectType
+	if r.SubjectRelation != "" {
+		subject += "#" + r.SubjectRelation
+	} else if r.SubjectID == AnyUser {
+		subject += ":*"
+	}
+	for _, allowed := range definition.relations[r.Relation] {
+		if allowed == subject {
+			return true
+		}
+	}
+	return false
+}
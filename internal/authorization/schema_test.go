@@ -0,0 +1,95 @@
+package authorization
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSchema(t *testing.T) {
+	definitions, err := parseSchema(schema)
+	if err != nil {
+		t.Fatalf("parseSchema(schema.zed) failed: %v", err)
+	}
+
+	node, ok := definitions[ResourceNode]
+	if !ok {
+		t.Fatalf("definition %q missing", ResourceNode)
+	}
+	wantRead := []schemaTerm{
+		{name: "viewer"},
+		{name: "editor"},
+		{name: "admin"},
+		{name: "owner"},
+		{name: "workspace", arrow: "read"},
+		{name: "parent", arrow: "read"},
+	}
+	if got := node.permissions[PermissionRead]; !reflect.DeepEqual(got, wantRead) {
+		t.Errorf("node#read = %+v, want %+v", got, wantRead)
+	}
+	wantViewer := []string{"user", "user:*", "group#member"}
+	if got := node.relations[RelationViewer]; !reflect.DeepEqual(got, wantViewer) {
+		t.Errorf("node#viewer = %v, want %v", got, wantViewer)
+	}
+	if user, ok := definitions[SubjectUser]; !ok || len(user.relations) != 0 || len(user.permissions) != 0 {
+		t.Errorf("definition %q = %+v, want an empty definition", SubjectUser, user)
+	}
+}
+
+func TestParseSchemaRejectsUnsupported(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"exclusion", "definition doc {\n relation viewer : user\n relation banned : user\n permission read = viewer - banned\n}"},
+		{"intersection", "definition doc {\n relation viewer : user\n relation member : user\n permission read = viewer & member\n}"},
+		{"parentheses", "definition doc {\n relation viewer : user\n relation editor : user\n permission read = (viewer + editor)\n}"},
+		{"empty operand", "definition doc {\n relation viewer : user\n permission read = viewer +\n}"},
+		{"arrow without permission", "definition doc {\n relation parent : doc\n permission read = parent->\n}"},
+		{"caveated subject", "definition doc {\n relation viewer : user with ip_allowed\n}"},
+		{"relation without subjects", "definition doc {\n relation viewer\n}"},
+		{"nested definition", "definition doc {\n definition page {\n}"},
+		{"statement outside definition", "relation viewer : user"},
+		{"unknown statement", "definition doc {\n caveat ip_allowed(ip ipaddress) {\n}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseSchema(tt.source); err == nil {
+				t.Errorf("parseSchema(%q) succeeded, want an error", tt.source)
+			}
+		})
+	}
+}
+
+func TestSchemaAllows(t *testing.T) {
+	definitions, err := parseSchema(schema)
+	if err != nil {
+		t.Fatalf("parseSchema(schema.zed) failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		r    Relationship
+		want bool
+	}{
+		{"user viewer", Relationship{ResourceNode, "n1", RelationViewer, SubjectUser, "1", ""}, true},
+		{"every user viewer", Relationship{ResourceNode, "n1", RelationViewer, SubjectUser, AnyUser, ""}, true},
+		{"group members viewer", Relationship{ResourceNode, "n1", RelationViewer, ResourceGroup, "g1", RelationMember}, true},
+		{"parent node", Relationship{ResourceNode, "n1", RelationParent, ResourceNode, "n2", ""}, true},
+		{"workspace of root", Relationship{ResourceNode, "n1", RelationWorkspace, ResourceWorkspace, "w1", ""}, true},
+		{"nested group", Relationship{ResourceGroup, "g1", RelationMember, ResourceGroup, "g2", RelationMember}, true},
+		{"every user editor", Relationship{ResourceNode, "n1", RelationEditor, SubjectUser, AnyUser, ""}, false},
+		{"group itself viewer", Relationship{ResourceNode, "n1", RelationViewer, ResourceGroup, "g1", ""}, false},
+		{"user parent", Relationship{ResourceNode, "n1", RelationParent, SubjectUser, "1", ""}, false},
+		{"group members owner", Relationship{ResourceNode, "n1", RelationOwner, ResourceGroup, "g1", RelationMember}, false},
+		{"group members group owner", Relationship{ResourceGroup, "g1", RelationOwner, ResourceGroup, "g2", RelationMember}, false},
+		{"permission as relation", Relationship{ResourceNode, "n1", PermissionRead, SubjectUser, "1", ""}, false},
+		{"unknown resource type", Relationship{"folder", "f1", RelationViewer, SubjectUser, "1", ""}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := definitions.allows(tt.r); got != tt.want {
+				t.Errorf("allows(%+v) = %v, want %v", tt.r, got, tt.want)
+			}
+		})
+	}
+}
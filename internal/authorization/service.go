@@ -3,30 +3,104 @@ package authorization
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 
 	_ "embed"
 
 	v1 "github.com/authzed/authzed-go/proto/authzed/api/v1"
 	"github.com/authzed/authzed-go/v1"
+	"github.com/authzed/grpcutil"
+	"github.com/sirkartik/cloud_drive_2.0/internal/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"gorm.io/gorm"
 )
 
 //go:embed schema/schema.zed
 var schema string
 
+// Checks sent to SpiceDB per bulk request, its default limit
+const maxBulkChecks = 1000
+
 func NewService(
 	authzedClient *authzed.Client,
-) *Service {
+	Cfg config.Config,
+) (*Service, error) {
 	_, err := authzedClient.WriteSchema(context.TODO(), &v1.WriteSchemaRequest{
 		Schema: string(schema),
 	})
 	if err != nil {
-		log.Fatalf("Failed to apply schema to spiceDB")
+		return nil, fmt.Errorf("failed to apply schema to spicedb: %w", err)
 	}
 	return &Service{
 		authzed: authzedClient,
+		cache:   newCheckCache(Cfg.SpiceDB.CheckCacheTTL, Cfg.SpiceDB.CheckCacheSize),
+	}, nil
+}
+
+// NewAuthorizer sets up the backend the config asks for.
+func NewAuthorizer(DB *gorm.DB, Cfg config.Config) (Authorizer, error) {
+	switch Cfg.SpiceDB.Backend {
+	case BackendSpiceDB, "":
+		authzedClient, err := newAuthzedClient(Cfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewService(authzedClient, Cfg)
+	case BackendPostgres:
+		return NewLocalService(DB)
+	default:
+		return nil, fmt.Errorf("unknown authorization backend %q", Cfg.SpiceDB.Backend)
+	}
+}
+
+// OpenAuthorizer connects to the backend the config asks for as it is,
+// without applying the schema or migrating tables, for tools that must not
+// write.
+func OpenAuthorizer(DB *gorm.DB, Cfg config.Config) (Authorizer, error) {
+	switch Cfg.SpiceDB.Backend {
+	case BackendSpiceDB, "":
+		authzedClient, err := newAuthzedClient(Cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &Service{
+			authzed: authzedClient,
+			cache:   newCheckCache(Cfg.SpiceDB.CheckCacheTTL, Cfg.SpiceDB.CheckCacheSize),
+		}, nil
+	case BackendPostgres:
+		definitions, err := parseSchema(schema)
+		if err != nil {
+			return nil, err
+		}
+		return &LocalService{
+			db:     DB,
+			schema: definitions,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown authorization backend %q", Cfg.SpiceDB.Backend)
+	}
+}
+
+func newAuthzedClient(Cfg config.Config) (*authzed.Client, error) {
+	endpoint := fmt.Sprintf("%s:%s", Cfg.SpiceDB.URL, Cfg.SpiceDB.Port)
+	credentials := grpc.WithTransportCredentials(insecure.NewCredentials())
+	token := grpcutil.WithInsecureBearerToken(Cfg.SpiceDB.Password)
+	if Cfg.SpiceDB.Secure {
+		systemCerts, err := grpcutil.WithSystemCerts(grpcutil.VerifyCA)
+		if err != nil {
+			return nil, err
+		}
+		credentials = systemCerts
+		token = grpcutil.WithBearerToken(Cfg.SpiceDB.Password)
+	}
+	authzedClient, err := authzed.NewClient(endpoint, token, credentials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to spicedb: %w", err)
 	}
+	return authzedClient, nil
 }
 
 func newObject(resourceType, resourceId string) *v1.ObjectReference {
@@ -42,58 +116,310 @@ func newSubject(subjectType, subjectId string) *v1.SubjectReference {
 	}
 }
 
+// WriteRelationship touches a single relationship. subjectRelation is empty
+// unless the subject is a set, like the members of a group.
 func (svc *Service) WriteRelationship(
 	ctx context.Context,
 	resourceType, resourceID string,
 	relation string,
 	subjectType, subjectID string,
+	subjectRelation string,
+) (string, error) {
+	return svc.WriteRelationships(ctx, []Relationship{{
+		ResourceType:    resourceType,
+		ResourceID:      resourceID,
+		Relation:        relation,
+		SubjectType:     subjectType,
+		SubjectID:       subjectID,
+		SubjectRelation: subjectRelation,
+	}}, nil)
+}
+
+// SpiceDB rejects writes with more updates than this by default
+const maxUpdatesPerWrite = 1000
+
+func toRelationship(r Relationship) *v1.Relationship {
+	subject := newSubject(r.SubjectType, r.SubjectID)
+	subject.OptionalRelation = r.SubjectRelation
+	return &v1.Relationship{
+		Resource: newObject(r.ResourceType, r.ResourceID),
+		Relation: r.Relation,
+		Subject:  subject,
+	}
+}
+
+// WriteRelationships touches and deletes relationships, returning the ZedToken
+// of the last write. Each batch of maxUpdatesPerWrite is applied atomically,
+// larger sets are not.
+func (svc *Service) WriteRelationships(
+	ctx context.Context,
+	touch []Relationship,
+	remove []Relationship,
+) (string, error) {
+	updates := make([]*v1.RelationshipUpdate, 0, len(touch)+len(remove))
+	for _, r := range remove {
+		updates = append(updates, &v1.RelationshipUpdate{
+			Operation:    v1.RelationshipUpdate_OPERATION_DELETE,
+			Relationship: toRelationship(r),
+		})
+	}
+	for _, r := range touch {
+		updates = append(updates, &v1.RelationshipUpdate{
+			Operation:    v1.RelationshipUpdate_OPERATION_TOUCH,
+			Relationship: toRelationship(r),
+		})
+	}
+
+	subjects := affectedSubjects(touch, remove)
+	var token string
+	for len(updates) > 0 {
+		batch := updates[:min(len(updates), maxUpdatesPerWrite)]
+		updates = updates[len(batch):]
+
+		res, err := svc.authzed.WriteRelationships(
+			ctx,
+			&v1.WriteRelationshipsRequest{Updates: batch},
+		)
+		if err != nil {
+			// Earlier batches may have gone through
+			svc.cache.invalidate(token, subjects)
+			return "", err
+		}
+		token = res.WrittenAt.Token
+	}
+	svc.cache.invalidate(token, subjects)
+	return token, nil
+}
+
+// DeleteResourceRelationships removes every relationship of the given
+// resources, whatever the relation or subject.
+func (svc *Service) DeleteResourceRelationships(
+	ctx context.Context,
+	resourceType string,
+	resourceIDs []string,
 ) (string, error) {
-	relationship := &v1.Relationship{
-		Resource: newObject(resourceType, resourceID),
-		Relation: relation,
-		Subject:  newSubject(subjectType, subjectID),
-	}
-	res, err := svc.authzed.WriteRelationships(
-		ctx,
-		&v1.WriteRelationshipsRequest{
-			Updates: []*v1.RelationshipUpdate{
-				{
-					Operation:    v1.RelationshipUpdate_OPERATION_TOUCH,
-					Relationship: relationship,
+	var token string
+	for _, resourceID := range resourceIDs {
+		res, err := svc.authzed.DeleteRelationships(
+			ctx,
+			&v1.DeleteRelationshipsRequest{
+				RelationshipFilter: &v1.RelationshipFilter{
+					ResourceType:       resourceType,
+					OptionalResourceId: resourceID,
 				},
 			},
-		},
-	)
-	if err != nil {
-		return "", err
+		)
+		if err != nil {
+			svc.cache.invalidate(token, nil)
+			return "", err
+		}
+		token = res.DeletedAt.Token
 	}
-	return res.WrittenAt.Token, nil
+	svc.cache.invalidate(token, nil)
+	return token, nil
 }
 
-func (svc *Service) CheckPermOnResource(
+// DeleteSubjectRelationships removes every relationship on resources of the
+// given types where the object shows up as subject, plain or as a set.
+func (svc *Service) DeleteSubjectRelationships(
 	ctx context.Context,
+	resourceTypes []string,
 	subjectType, subjectID string,
+) (string, error) {
+	subjects := affectedSubjects([]Relationship{{SubjectType: subjectType, SubjectID: subjectID}})
+	var token string
+	for _, resourceType := range resourceTypes {
+		res, err := svc.authzed.DeleteRelationships(
+			ctx,
+			&v1.DeleteRelationshipsRequest{
+				RelationshipFilter: &v1.RelationshipFilter{
+					ResourceType: resourceType,
+					OptionalSubjectFilter: &v1.SubjectFilter{
+						SubjectType:       subjectType,
+						OptionalSubjectId: subjectID,
+					},
+				},
+			},
+		)
+		if err != nil {
+			svc.cache.invalidate(token, subjects)
+			return "", err
+		}
+		token = res.DeletedAt.Token
+	}
+	svc.cache.invalidate(token, subjects)
+	return token, nil
+}
+
+// ReadRelationships lists the relationships of a resource, at least as fresh
+// as zedToken when one is given.
+func (svc *Service) ReadRelationships(
+	ctx context.Context,
 	resourceType, resourceID string,
-	permission string,
-	precise bool,
 	zedToken string,
-) (bool, error) {
-	var consistency *v1.Consistency = nil
-	if precise {
+) ([]Relationship, error) {
+	consistency := &v1.Consistency{
+		Requirement: &v1.Consistency_MinimizeLatency{MinimizeLatency: true},
+	}
+	if zedToken != "" {
 		consistency = &v1.Consistency{
 			Requirement: &v1.Consistency_AtLeastAsFresh{
 				AtLeastAsFresh: &v1.ZedToken{Token: zedToken},
 			},
 		}
-	} else {
-		consistency = &v1.Consistency{
-			Requirement: &v1.Consistency_MinimizeLatency{
-				MinimizeLatency: true,
+	}
+	stream, err := svc.authzed.ReadRelationships(ctx, &v1.ReadRelationshipsRequest{
+		Consistency: consistency,
+		RelationshipFilter: &v1.RelationshipFilter{
+			ResourceType:       resourceType,
+			OptionalResourceId: resourceID,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var relationships []Relationship
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return relationships, nil
+		} else if err != nil {
+			return nil, err
+		}
+		relationships = append(relationships, Relationship{
+			ResourceType: res.Relationship.Resource.ObjectType,
+			ResourceID:   res.Relationship.Resource.ObjectId,
+			Relation:     res.Relationship.Relation,
+			SubjectType:  res.Relationship.Subject.Object.ObjectType,
+			SubjectID:    res.Relationship.Subject.Object.ObjectId,
+
+			SubjectRelation: res.Relationship.Subject.OptionalRelation,
+		})
+	}
+}
+
+// LookupResources lists the IDs of every resource of a type the subject
+// holds a permission on. Conditional results are left out.
+func (svc *Service) LookupResources(
+	ctx context.Context,
+	resourceType string,
+	permission string,
+	subjectType, subjectID string,
+) ([]string, error) {
+	stream, err := svc.authzed.LookupResources(ctx, &v1.LookupResourcesRequest{
+		Consistency: &v1.Consistency{
+			Requirement: &v1.Consistency_MinimizeLatency{MinimizeLatency: true},
+		},
+		ResourceObjectType: resourceType,
+		Permission:         permission,
+		Subject:            newSubject(subjectType, subjectID),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resourceIDs []string
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return resourceIDs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if res.Permissionship == v1.LookupPermissionship_LOOKUP_PERMISSIONSHIP_HAS_PERMISSION {
+			resourceIDs = append(resourceIDs, res.ResourceObjectId)
+		}
+	}
+}
+
+// LookupResourcesPage looks up to limit resources of a type the subject
+// holds a permission on, resuming after cursor. It also returns the cursor
+// after the whole page, empty once the lookup is done.
+func (svc *Service) LookupResourcesPage(
+	ctx context.Context,
+	resourceType string,
+	permission string,
+	subjectType, subjectID string,
+	cursor string,
+	limit int,
+) ([]LookupResult, string, error) {
+	request := &v1.LookupResourcesRequest{
+		Consistency: &v1.Consistency{
+			Requirement: &v1.Consistency_MinimizeLatency{MinimizeLatency: true},
+		},
+		ResourceObjectType: resourceType,
+		Permission:         permission,
+		Subject:            newSubject(subjectType, subjectID),
+		OptionalLimit:      uint32(limit),
+	}
+	if cursor != "" {
+		request.OptionalCursor = &v1.Cursor{Token: cursor}
+	}
+	stream, err := svc.authzed.LookupResources(ctx, request)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var results []LookupResult
+	received := 0
+	next := ""
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, "", err
+		}
+		received++
+		next = res.GetAfterResultCursor().GetToken()
+		if res.Permissionship == v1.LookupPermissionship_LOOKUP_PERMISSIONSHIP_HAS_PERMISSION {
+			results = append(results, LookupResult{ResourceID: res.ResourceObjectId, Cursor: next})
+		}
+	}
+	// A short page is the last one
+	if received < limit {
+		next = ""
+	}
+	return results, next, nil
+}
+
+func newConsistency(precise bool, zedToken string) *v1.Consistency {
+	if precise {
+		return &v1.Consistency{
+			Requirement: &v1.Consistency_AtLeastAsFresh{
+				AtLeastAsFresh: &v1.ZedToken{Token: zedToken},
 			},
 		}
 	}
+	return &v1.Consistency{
+		Requirement: &v1.Consistency_MinimizeLatency{
+			MinimizeLatency: true,
+		},
+	}
+}
+
+// CheckPermOnResource answers a single check, from the cache when it holds
+// an answer at least as fresh as zedToken.
+func (svc *Service) CheckPermOnResource(
+	ctx context.Context,
+	subjectType, subjectID string,
+	resourceType, resourceID string,
+	permission string,
+	precise bool,
+	zedToken string,
+) (bool, error) {
+	if !precise {
+		zedToken = ""
+	}
+	key := checkKey{subjectType, subjectID, resourceType, resourceID, permission}
+	allowed, hit, generation := svc.cache.get(key, zedToken)
+	if hit {
+		return allowed, nil
+	}
+
 	req := &v1.CheckPermissionRequest{
-		Consistency: consistency,
+		Consistency: newConsistency(precise, zedToken),
 		Resource:    newObject(resourceType, resourceID),
 		Subject:     newSubject(subjectType, subjectID),
 		Permission:  permission,
@@ -105,5 +431,71 @@ func (svc *Service) CheckPermOnResource(
 	}
 
 	hasPermission := res.Permissionship == v1.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION
+	svc.cache.put(key, zedToken, hasPermission, generation)
 	return hasPermission, nil
-}
\ No newline at end of file
+}
+
+// CheckBulkPermissions answers many checks at once, in the order given.
+// Cached answers are used where possible and the rest go to SpiceDB in as
+// few requests as it accepts. An empty zedToken minimizes latency.
+func (svc *Service) CheckBulkPermissions(
+	ctx context.Context,
+	checks []PermissionCheck,
+	zedToken string,
+) ([]bool, error) {
+	results := make([]bool, len(checks))
+	keys := make([]checkKey, len(checks))
+	var pending []int
+	var generation uint64
+	for i, check := range checks {
+		keys[i] = checkKey{check.SubjectType, check.SubjectID, check.ResourceType, check.ResourceID, check.Permission}
+		allowed, hit, checkGeneration := svc.cache.get(keys[i], zedToken)
+		if hit {
+			results[i] = allowed
+			continue
+		}
+		if len(pending) == 0 {
+			generation = checkGeneration
+		}
+		pending = append(pending, i)
+	}
+
+	for start := 0; start < len(pending); start += maxBulkChecks {
+		end := min(start+maxBulkChecks, len(pending))
+		batch := pending[start:end]
+
+		items := make([]*v1.CheckBulkPermissionsRequestItem, 0, len(batch))
+		for _, i := range batch {
+			items = append(items, &v1.CheckBulkPermissionsRequestItem{
+				Resource:   newObject(checks[i].ResourceType, checks[i].ResourceID),
+				Permission: checks[i].Permission,
+				Subject:    newSubject(checks[i].SubjectType, checks[i].SubjectID),
+			})
+		}
+		res, err := svc.authzed.CheckBulkPermissions(ctx, &v1.CheckBulkPermissionsRequest{
+			Consistency: newConsistency(zedToken != "", zedToken),
+			Items:       items,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("spicedb bulk check failed: %w", err)
+		}
+		if len(res.Pairs) != len(batch) {
+			return nil, fmt.Errorf("spicedb bulk check answered %d of %d checks", len(res.Pairs), len(batch))
+		}
+
+		for j, pair := range res.Pairs {
+			if pairErr := pair.GetError(); pairErr != nil {
+				return nil, fmt.Errorf("spicedb bulk check failed: %s", pairErr.GetMessage())
+			}
+			i := batch[j]
+			results[i] = pair.GetItem().GetPermissionship() == v1.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION
+			svc.cache.put(keys[i], zedToken, results[i], generation)
+		}
+	}
+	return results, nil
+}
+
+// CacheStats reports how well the check cache is doing.
+func (svc *Service) CacheStats() CacheStats {
+	return svc.cache.stats()
+}
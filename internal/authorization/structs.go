@@ -1,13 +1,112 @@
 package authorization
 
 import (
+	"context"
+
 	"github.com/authzed/authzed-go/v1"
+	"gorm.io/gorm"
 )
 
+// Authorizer stores relationships and answers permission checks against
+// schema.zed. Service does so through SpiceDB, LocalService in process.
+type Authorizer interface {
+	WriteRelationship(ctx context.Context, resourceType, resourceID, relation, subjectType, subjectID, subjectRelation string) (string, error)
+	WriteRelationships(ctx context.Context, touch []Relationship, remove []Relationship) (string, error)
+	DeleteResourceRelationships(ctx context.Context, resourceType string, resourceIDs []string) (string, error)
+	DeleteSubjectRelationships(ctx context.Context, resourceTypes []string, subjectType, subjectID string) (string, error)
+	ReadRelationships(ctx context.Context, resourceType, resourceID string, zedToken string) ([]Relationship, error)
+	LookupResources(ctx context.Context, resourceType string, permission string, subjectType, subjectID string) ([]string, error)
+	LookupResourcesPage(ctx context.Context, resourceType string, permission string, subjectType, subjectID string, cursor string, limit int) ([]LookupResult, string, error)
+	CheckPermOnResource(ctx context.Context, subjectType, subjectID string, resourceType, resourceID string, permission string, precise bool, zedToken string) (bool, error)
+	CheckBulkPermissions(ctx context.Context, checks []PermissionCheck, zedToken string) ([]bool, error)
+	CacheStats() CacheStats
+}
+
 type Service struct {
 	authzed *authzed.Client
+	cache   *checkCache
+}
+
+// LocalService evaluates schema.zed itself over relationships kept in
+// Postgres, for deployments without SpiceDB.
+type LocalService struct {
+	db     *gorm.DB
+	schema schemaDefinitions
 }
 
 type Handler struct {
-	svc *Service
+	svc Authorizer
+}
+
+// Authorizer backends selectable through config.SpiceDBConfig.Backend
+const (
+	BackendSpiceDB  = "spicedb"
+	BackendPostgres = "postgres"
+)
+
+// Object types, relations and permissions from schema.zed
+const (
+	ResourceNode      = "node"
+	ResourceWorkspace = "workspace"
+	ResourceGroup     = "group"
+	SubjectUser       = "user"
+
+	// Subject IDs of every user, as granted by node#viewer, and of the
+	// anonymous principal
+	AnyUser       = "*"
+	AnonymousUser = "anonymous"
+
+	RelationOwner  = "owner"
+	RelationParent = "parent"
+	RelationViewer = "viewer"
+	RelationEditor = "editor"
+	RelationAdmin  = "admin"
+	RelationMember = "member"
+
+	// Links a workspace's root directory to the workspace
+	RelationWorkspace = "workspace"
+
+	PermissionRead    = "read"
+	PermissionWrite   = "write"
+	PermissionExecute = "execute"
+	PermissionManage  = "manage"
+)
+
+type Relationship struct {
+	ResourceType string
+	ResourceID   string
+	Relation     string
+	SubjectType  string
+	SubjectID    string
+	// Set for subject sets like group#member, empty for plain subjects
+	SubjectRelation string
+}
+
+// LookupResult is a resource found by LookupResourcesPage, with the cursor
+// that resumes the lookup right after it.
+type LookupResult struct {
+	ResourceID string
+	Cursor     string
+}
+
+// PermissionCheck is a single question in a bulk check.
+type PermissionCheck struct {
+	SubjectType  string
+	SubjectID    string
+	ResourceType string
+	ResourceID   string
+	Permission   string
+}
+
+// CacheStats reports how well the permission check cache does.
+type CacheStats struct {
+	Enabled bool    `json:"enabled"`
+	Entries int     `json:"entries"`
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+	// Misses for checks against ZedTokens written before a restart or by
+	// another instance, which can't be cached
+	UnknownTokens uint64 `json:"unknown_tokens"`
+	Invalidations uint64 `json:"invalidations"`
 }
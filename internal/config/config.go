@@ -1,7 +1,11 @@
 package config
 
 import (
+	"errors"
+	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 func getEnvOrDefault(key, fallback string) string {
@@ -11,11 +15,68 @@ func getEnvOrDefault(key, fallback string) string {
 	return fallback
 }
 
+// Values that don't parse are logged and replaced by the fallback.
+
+// getDurationOrDefault only accepts positive durations, as the ones
+// configured are intervals and ages.
+func getDurationOrDefault(key string, fallback time.Duration) time.Duration {
+	val, err := time.ParseDuration(getEnvOrDefault(key, fallback.String()))
+	if err == nil && val <= 0 {
+		err = errors.New("duration must be positive")
+	}
+	if err != nil {
+		log.Printf("Invalid %s, using %s: %v", key, fallback, err)
+		return fallback
+	}
+	return val
+}
+
+// getNonNegativeDurationOrDefault also accepts zero, for settings it turns
+// off.
+func getNonNegativeDurationOrDefault(key string, fallback time.Duration) time.Duration {
+	val, err := time.ParseDuration(getEnvOrDefault(key, fallback.String()))
+	if err == nil && val < 0 {
+		err = errors.New("duration must not be negative")
+	}
+	if err != nil {
+		log.Printf("Invalid %s, using %s: %v", key, fallback, err)
+		return fallback
+	}
+	return val
+}
+
+func getInt64OrDefault(key string, fallback int64) int64 {
+	val, err := strconv.ParseInt(getEnvOrDefault(key, strconv.FormatInt(fallback, 10)), 10, 64)
+	if err != nil {
+		log.Printf("Invalid %s, using %d: %v", key, fallback, err)
+		return fallback
+	}
+	return val
+}
+
+func getIntOrDefault(key string, fallback int) int {
+	val, err := strconv.Atoi(getEnvOrDefault(key, strconv.Itoa(fallback)))
+	if err != nil {
+		log.Printf("Invalid %s, using %d: %v", key, fallback, err)
+		return fallback
+	}
+	return val
+}
+
 type SpiceDBConfig struct {
+	// "spicedb", or "postgres" to evaluate schema.zed in process over
+	// relationships kept in the application database
+	Backend string
+
 	URL      string
 	Port     string
 	Secure   bool
 	Password string
+
+	// How long permission check results are reused, zero disables the cache
+	CheckCacheTTL time.Duration
+	// Cached check results kept at most
+	CheckCacheSize int
 }
 
 type DatabaseConfig struct {
@@ -30,12 +91,26 @@ type DatabaseConfig struct {
 type ApplicationConfig struct {
 	RESTPort    uint16
 	HostAddress string
+
+	// Shared secret other services send to reach the internal admin
+	// routes, which stay closed while it is empty
+	InternalToken string
 }
 
 type EmailConfig struct {
 	Email    string
 	Password string
+	Host     string
 	Port     uint16
+
+	// Accounts have to confirm their email before they can log in
+	RequireVerification bool
+	// How long a verification link stays valid, and how often it can be
+	// sent again
+	VerificationExpiry time.Duration
+	ResendInterval     time.Duration
+	// Verify endpoint the emailed link points to
+	VerificationURL string
 }
 
 type JWTConfig struct {
@@ -54,6 +129,30 @@ type StorageConfig struct {
 	MinioConfig   MinioConfig
 	BucketName    string
 	HLSBucketName string
+
+	// How long a presigned direct upload stays valid before the reaper
+	// removes the pending node
+	DirectUploadExpiry   time.Duration
+	UploadReaperInterval time.Duration
+
+	// Directory copies with more nodes than this run as background jobs
+	CopyJobThreshold int
+
+	// Trashed nodes older than TrashRetention are permanently deleted
+	TrashRetention     time.Duration
+	TrashSweepInterval time.Duration
+
+	// Previous versions kept per file and how long they are kept for,
+	// zero disables the respective limit
+	MaxFileVersions      int
+	FileVersionRetention time.Duration
+	VersionPruneInterval time.Duration
+
+	// Quota for users without one of their own, zero means unlimited
+	DefaultQuotaBytes int64
+
+	// Quota for workspaces without one of their own, zero means unlimited
+	DefaultWorkspaceQuotaBytes int64
 }
 
 type NATSConfig struct {
@@ -75,6 +174,10 @@ func NewConfig() *Config {
 	if getEnvOrDefault("MINIO_USE_SSL", "false") == "true" {
 		useSSL = true
 	}
+	requireVerification := false
+	if getEnvOrDefault("EMAIL_VERIFICATION", "false") == "true" {
+		requireVerification = true
+	}
 
 	return &Config{
 		Database: DatabaseConfig{
@@ -88,11 +191,19 @@ func NewConfig() *Config {
 		App: ApplicationConfig{
 			RESTPort:    8080,
 			HostAddress: getEnvOrDefault("HOST_ADDRESS", "127.0.0.1"),
+
+			InternalToken: os.Getenv("INTERNAL_API_TOKEN"),
 		},
 		SMTP: EmailConfig{
 			Email:    os.Getenv("EMAIL_ADDRESS"),
 			Password: os.Getenv("EMAIL_PASSWORD"),
+			Host:     getEnvOrDefault("SMTP_HOST", "smtp.gmail.com"),
 			Port:     uint16(587),
+
+			RequireVerification: requireVerification,
+			VerificationExpiry:  24 * time.Hour,
+			ResendInterval:      time.Minute,
+			VerificationURL:     getEnvOrDefault("EMAIL_VERIFICATION_URL", "http://127.0.0.1:8080/api/auth/verify"),
 		},
 		JWT: JWTConfig{
 			Secret:     os.Getenv("JWT_SECRET"),
@@ -107,15 +218,31 @@ func NewConfig() *Config {
 			},
 			BucketName:    getEnvOrDefault("STORAGE_BUCKET_NAME", "cloud-drive"),
 			HLSBucketName: getEnvOrDefault("STORAGE_HLS_BUCKET_NAME", "cloud-drive-hls"),
+
+			DirectUploadExpiry:   getDurationOrDefault("DIRECT_UPLOAD_EXPIRY", 2*time.Hour),
+			UploadReaperInterval: getDurationOrDefault("UPLOAD_REAPER_INTERVAL", 15*time.Minute),
+			CopyJobThreshold:     getIntOrDefault("COPY_JOB_THRESHOLD", 200),
+			TrashRetention:       getDurationOrDefault("TRASH_RETENTION", 30*24*time.Hour),
+			TrashSweepInterval:   getDurationOrDefault("TRASH_SWEEP_INTERVAL", time.Hour),
+			MaxFileVersions:      getIntOrDefault("MAX_FILE_VERSIONS", 20),
+			FileVersionRetention: getNonNegativeDurationOrDefault("FILE_VERSION_RETENTION", 90*24*time.Hour),
+			VersionPruneInterval: getDurationOrDefault("VERSION_PRUNE_INTERVAL", 6*time.Hour),
+			DefaultQuotaBytes:    getInt64OrDefault("DEFAULT_QUOTA_BYTES", 15<<30), // 15 GiB
+
+			DefaultWorkspaceQuotaBytes: getInt64OrDefault("DEFAULT_WORKSPACE_QUOTA_BYTES", 100<<30), // 100 GiB
 		},
 		NATS: NATSConfig{
 			URL: getEnvOrDefault("NATS_URL", "nats://127.0.0.1:4222"),
 		},
 		SpiceDB: SpiceDBConfig{
-			URL:      "127.0.0.1",
-			Port:     "50051",
+			Backend:  getEnvOrDefault("AUTHZ_BACKEND", "spicedb"),
+			URL:      getEnvOrDefault("SPICE_DB_URL", "127.0.0.1"),
+			Port:     getEnvOrDefault("SPICE_DB_PORT", "50051"),
 			Secure:   false,
 			Password: getEnvOrDefault("SPICE_DB_PASSWORD", ""),
+
+			CheckCacheTTL:  getNonNegativeDurationOrDefault("CHECK_CACHE_TTL", 5*time.Second),
+			CheckCacheSize: getIntOrDefault("CHECK_CACHE_SIZE", 100000),
 		},
 	}
 }
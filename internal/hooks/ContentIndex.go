@@ -0,0 +1,65 @@
+package hooks
+
+import (
+	"context"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/storage"
+)
+
+// Extraction holds whole objects in memory, so only a few run at a time and
+// uploads past a full queue go unindexed rather than piling up
+const (
+	indexWorkers   = 4
+	indexQueueSize = 256
+)
+
+func NewContentIndexHooks(storageSvc *storage.Service) *ContentIndexHooks {
+	svc := &ContentIndexHooks{
+		storageSvc: storageSvc,
+		jobs:       make(chan indexJob, indexQueueSize),
+	}
+	for range indexWorkers {
+		go svc.work()
+	}
+	return svc
+}
+
+func (svc *ContentIndexHooks) work() {
+	for job := range svc.jobs {
+		log.Printf("Indexing content of %s...", job.fileName)
+		err := svc.storageSvc.IndexContent(context.Background(), job.key, job.mimeType, job.sizeBytes)
+		if err != nil {
+			log.Printf("Failed to index content of %s: %v", job.fileName, err)
+		}
+	}
+}
+
+func (svc *ContentIndexHooks) OnDocument(
+	ctx context.Context,
+	userID uint64,
+	parentID uuid.UUID,
+	fileName string,
+	mimeType string,
+	nodeID uuid.UUID,
+	key string,
+	sizeBytes uint64,
+) error {
+	if !storage.IsIndexable(mimeType) {
+		return nil
+	}
+
+	// The upload is done by now, extraction shouldn't hold up the response
+	select {
+	case svc.jobs <- indexJob{
+		fileName:  fileName,
+		mimeType:  mimeType,
+		key:       key,
+		sizeBytes: sizeBytes,
+	}:
+	default:
+		log.Printf("Content index queue is full, skipping %s", fileName)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sirkartik/cloud_drive_2.0/internal/storage"
+)
+
+func NewNotificationHooks(nc *nats.Conn) *NotificationHooks {
+	return &NotificationHooks{
+		nc: nc,
+	}
+}
+
+// OnFileRequestUpload tells the owner of a file request that a file arrived.
+// Notifications go out per user on "notifications.<user id>".
+func (svc *NotificationHooks) OnFileRequestUpload(
+	ctx context.Context,
+	request *storage.FileRequest,
+	upload *storage.FileRequestUpload,
+) error {
+	log.Printf("New file %s for file request %s", upload.Name, request.ID)
+
+	notification := &FileRequestNotification{
+		Type:         "file_request.upload",
+		RequestID:    request.ID.String(),
+		RequestTitle: request.Title,
+		NodeID:       upload.NodeID.String(),
+		Name:         upload.Name,
+		SizeBytes:    upload.SizeBytes,
+		UploaderName: upload.UploaderName,
+	}
+
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	return svc.nc.Publish(
+		fmt.Sprintf("notifications.%d", request.OwnerID),
+		payload,
+	)
+}
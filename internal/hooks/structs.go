@@ -14,3 +14,29 @@ type VideoJob struct {
 	NodeID string `json:"node_id"`
 	URL    string `json:"url"`
 }
+
+type ContentIndexHooks struct {
+	storageSvc *storage.Service
+	jobs       chan indexJob
+}
+
+type indexJob struct {
+	fileName  string
+	mimeType  string
+	key       string
+	sizeBytes uint64
+}
+
+type NotificationHooks struct {
+	nc *nats.Conn
+}
+
+type FileRequestNotification struct {
+	Type         string `json:"type"`
+	RequestID    string `json:"request_id"`
+	RequestTitle string `json:"request_title"`
+	NodeID       string `json:"node_id"`
+	Name         string `json:"name"`
+	SizeBytes    uint64 `json:"size_bytes"`
+	UploaderName string `json:"uploader_name,omitempty"`
+}
@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+
+	"github.com/sirkartik/cloud_drive_2.0/internal/config"
+)
+
+// NewMailer sends from the configured account. Without one every Send fails
+// with ErrNotConfigured.
+func NewMailer(cfg config.EmailConfig) *Mailer {
+	if cfg.Email == "" || cfg.Host == "" {
+		return &Mailer{}
+	}
+	return &Mailer{
+		address: fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		from:    cfg.Email,
+		auth:    smtp.PlainAuth("", cfg.Email, cfg.Password, cfg.Host),
+	}
+}
+
+// Send delivers a plain text email, upgrading to TLS when the server offers
+// it.
+func (m *Mailer) Send(to, subject, body string) error {
+	if m.address == "" {
+		return ErrNotConfigured
+	}
+	// Line breaks in headers would let callers add headers of their own
+	to = stripLineBreaks(to)
+	subject = stripLineBreaks(subject)
+
+	message := strings.Join([]string{
+		"From: " + m.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+	return smtp.SendMail(m.address, m.auth, m.from, []string{to}, []byte(message))
+}
+
+func stripLineBreaks(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
@@ -0,0 +1,14 @@
+package mailer
+
+import (
+	"errors"
+	"net/smtp"
+)
+
+type Mailer struct {
+	address string
+	from    string
+	auth    smtp.Auth
+}
+
+var ErrNotConfigured = errors.New("smtp is not configured")
@@ -12,6 +12,21 @@ type ObjectStorage interface {
 	GeneratePresignedGetURL(ctx context.Context, bucket, key string) (*url.URL, error)
 	Put(ctx context.Context, bucket, key string, data io.Reader, size int64) error
 	Get(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+	GetRange(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error)
 	Delete(ctx context.Context, bucket, key string) error
 	Copy(ctx context.Context, bucket, srcKey, destKey string) error
+	Stat(ctx context.Context, bucket, key string) (*ObjectInfo, error)
+	GeneratePresignedPutURL(ctx context.Context, bucket, key string, expiry time.Duration) (*url.URL, error)
+	GeneratePresignedPartURL(ctx context.Context, bucket, key, uploadID string, partNumber int, expiry time.Duration) (*url.URL, error)
+
+	// Multipart uploads, used for resumable uploads
+	NewMultipartUpload(ctx context.Context, bucket, key string) (string, error)
+	PutPart(ctx context.Context, bucket, key, uploadID string, partNumber int, data io.Reader, size int64) (string, error)
+	CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []CompletedPart) error
+	AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error
+}
+
+// Mailer sends plain text emails.
+type Mailer interface {
+	Send(to, subject, body string) error
 }
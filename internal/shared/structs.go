@@ -1 +1,14 @@
 package shared
+
+import "time"
+
+type CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+type ObjectInfo struct {
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+)
+
+func (h *Handler) GrantAccess(c echo.Context) error {
+	var req GrantAccess
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.NodeID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	grantee := strings.TrimSpace(req.User)
+	if grantee == "" {
+		return c.JSON(http.StatusBadRequest, "missing user param")
+	}
+	role := AccessRole(req.Role)
+	if !role.grantable() {
+		return c.JSON(http.StatusBadRequest, "invalid role param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	access, err := h.svc.GrantAccess(ctx, user.ID, id, grantee, role)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error sharing node")
+	}
+	return c.JSON(http.StatusCreated, access)
+}
+
+func (h *Handler) ListAccess(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	access, err := h.svc.ListAccess(ctx, user.ID, id)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error listing access")
+	}
+	return c.JSON(http.StatusOK, access)
+}
+
+func (h *Handler) UpdateAccess(c echo.Context) error {
+	var req UpdateAccess
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.NodeID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	role := AccessRole(req.Role)
+	if !role.grantable() {
+		return c.JSON(http.StatusBadRequest, "invalid role param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	access, err := h.svc.UpdateAccess(ctx, user.ID, id, req.UserID, role)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error changing role")
+	}
+	return c.JSON(http.StatusOK, access)
+}
+
+func (h *Handler) RevokeAccess(c echo.Context) error {
+	var req RevokeAccess
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.NodeID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	if err := h.svc.RevokeAccess(ctx, user.ID, id, req.UserID); err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error revoking access")
+	}
+	return c.JSON(http.StatusOK, "access revoked")
+}
+
+func (h *Handler) GrantGroupAccess(c echo.Context) error {
+	var req GrantGroupAccess
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.NodeID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	groupID, err := uuid.Parse(req.GroupID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid group_id param")
+	}
+	role := AccessRole(req.Role)
+	if !role.grantable() {
+		return c.JSON(http.StatusBadRequest, "invalid role param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	access, err := h.svc.GrantGroupAccess(ctx, user.ID, id, groupID, role)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error sharing node with group")
+	}
+	return c.JSON(http.StatusCreated, access)
+}
+
+func (h *Handler) RevokeGroupAccess(c echo.Context) error {
+	var req RevokeGroupAccess
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.NodeID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	groupID, err := uuid.Parse(req.GroupID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid group_id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	if err := h.svc.RevokeGroupAccess(ctx, user.ID, id, groupID); err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error revoking group access")
+	}
+	return c.JSON(http.StatusOK, "access revoked")
+}
+
+// SharedWithMe lists what other users shared with the caller, a page at a
+// time.
+func (h *Handler) SharedWithMe(c echo.Context) error {
+	var req ListSharedWithMe
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid query params")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	page, err := h.svc.ListSharedWithMe(ctx, user.ID, req.Cursor, req.Limit)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error listing nodes shared with you")
+	}
+	return c.JSON(http.StatusOK, page)
+}
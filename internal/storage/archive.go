@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
+	"gorm.io/gorm"
+)
+
+type ArchiveFormat string
+
+const (
+	ArchiveZip   ArchiveFormat = "zip"
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+)
+
+func ParseArchiveFormat(s string) (ArchiveFormat, error) {
+	switch ArchiveFormat(s) {
+	case "", ArchiveZip:
+		return ArchiveZip, nil
+	case ArchiveTarGz:
+		return ArchiveTarGz, nil
+	}
+	return "", fmt.Errorf("unknown archive format %q", s)
+}
+
+func (f ArchiveFormat) ContentType() string {
+	if f == ArchiveTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// canReadNode allows anyone with read access to the node.
+func (svc *Service) canReadNode(
+	ctx context.Context,
+	node *Node,
+	UserID uint64,
+) error {
+	if node.Status != NodeStatusActive {
+		return ErrNodeNotFound
+	}
+	return svc.checkPermission(ctx, node, UserID, authorization.PermissionRead)
+}
+
+// PrepareArchive resolves the nodes to put in an archive, every selected
+// node with its whole subtree. It runs before anything is written so errors
+// can still be reported to the client.
+func (svc *Service) PrepareArchive(
+	ctx context.Context,
+	NodeIDs []uuid.UUID,
+	UserID uint64,
+) (*Archive, error) {
+	if len(NodeIDs) == 0 {
+		return nil, errors.New("no nodes selected")
+	}
+
+	archive := Archive{Name: "download"}
+	rootNames := make(map[string]int)
+
+	for _, nodeID := range NodeIDs {
+		root, err := svc.GetNode(ctx, nodeID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrNodeNotFound
+			}
+			return nil, err
+		}
+		if err := svc.canReadNode(ctx, root, UserID); err != nil {
+			return nil, err
+		}
+
+		nodes, err := svc.getSubtree(ctx, root.ID, root.OwnerID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Two selected nodes may share a name when they come from
+		// different directories
+		rootName := root.Name
+		if n := rootNames[root.Name]; n > 0 {
+			ext := ""
+			if root.Type == NodeTypeFile {
+				ext = path.Ext(root.Name)
+			}
+			rootName = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(root.Name, ext), n, ext)
+		}
+		rootNames[root.Name]++
+
+		paths := make(map[uuid.UUID]string, len(nodes))
+		for _, node := range nodes {
+			if node.Status != NodeStatusActive {
+				continue
+			}
+
+			entryPath := rootName
+			if node.ID != root.ID {
+				parentPath, ok := paths[*node.ParentID]
+				if !ok {
+					continue
+				}
+				entryPath = parentPath + "/" + node.Name
+			}
+			paths[node.ID] = entryPath
+
+			archive.Entries = append(archive.Entries, ArchiveEntry{Path: entryPath, Node: node})
+		}
+	}
+
+	if len(NodeIDs) == 1 {
+		archive.Name = archive.Entries[0].Path
+	}
+	return &archive, nil
+}
+
+// WriteArchive streams the archive to w, reading one object at a time so
+// nothing is buffered beyond the compressor's window.
+func (svc *Service) WriteArchive(
+	ctx context.Context,
+	archive *Archive,
+	Format ArchiveFormat,
+	w io.Writer,
+) error {
+	if Format == ArchiveTarGz {
+		return svc.writeTarGz(ctx, archive, w)
+	}
+	return svc.writeZip(ctx, archive, w)
+}
+
+// openEntry returns the content of a file entry, or nil for directories.
+func (svc *Service) openEntry(
+	ctx context.Context,
+	entry ArchiveEntry,
+) (io.ReadCloser, error) {
+	if entry.Node.Type != NodeTypeFile || entry.Node.Key == nil {
+		return nil, nil
+	}
+	return svc.Client.Get(ctx, svc.Cfg.Storage.BucketName, *entry.Node.Key)
+}
+
+func (svc *Service) writeZip(
+	ctx context.Context,
+	archive *Archive,
+	w io.Writer,
+) error {
+	// archive/zip switches to ZIP64 records on its own once an entry or the
+	// archive outgrows the classic limits
+	zw := zip.NewWriter(w)
+
+	for _, entry := range archive.Entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header := &zip.FileHeader{
+			Name:     entry.Path,
+			Modified: entry.Node.CreatedAt,
+			Method:   zip.Deflate,
+		}
+		if entry.Node.Type == NodeTypeDirectory {
+			header.Name += "/"
+			header.Method = zip.Store
+		} else if entry.Node.MimeType != nil && alreadyCompressed(*entry.Node.MimeType) {
+			header.Method = zip.Store
+		}
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		stream, err := svc.openEntry(ctx, entry)
+		if err != nil {
+			return err
+		}
+		if stream == nil {
+			continue
+		}
+		_, err = io.Copy(fw, stream)
+		stream.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func (svc *Service) writeTarGz(
+	ctx context.Context,
+	archive *Archive,
+	w io.Writer,
+) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	for _, entry := range archive.Entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header := &tar.Header{
+			Name:    entry.Path,
+			ModTime: entry.Node.CreatedAt,
+			Mode:    0644,
+			Format:  tar.FormatPAX,
+		}
+		if entry.Node.Type == NodeTypeDirectory {
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+			header.Mode = 0755
+		} else {
+			header.Typeflag = tar.TypeReg
+			if entry.Node.SizeBytes != nil {
+				header.Size = int64(*entry.Node.SizeBytes)
+			}
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		stream, err := svc.openEntry(ctx, entry)
+		if err != nil {
+			return err
+		}
+		if stream == nil {
+			continue
+		}
+		_, err = io.Copy(tw, stream)
+		stream.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
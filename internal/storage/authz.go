@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
+	"gorm.io/gorm"
+)
+
+// Rows touched by a single zed_token update
+const relateBatchSize = 500
+
+func userSubject(UserID uint64) string {
+	if UserID == authentication.AnonymousID {
+		return authorization.AnonymousUser
+	}
+	return strconv.FormatUint(UserID, 10)
+}
+
+// everyoneViewer lets any user, the anonymous one included, view a node.
+func everyoneViewer(NodeID uuid.UUID) authorization.Relationship {
+	return authorization.Relationship{
+		ResourceType: authorization.ResourceNode,
+		ResourceID:   NodeID.String(),
+		Relation:     authorization.RelationViewer,
+		SubjectType:  authorization.SubjectUser,
+		SubjectID:    authorization.AnyUser,
+	}
+}
+
+// Tokens of the groups a user is in and of the user themselves, to be
+// appended to a list of token sources after userGroupsCTE. Its only argument
+// is the user ID.
+const subjectZedTokens = `
+	SELECT g.zed_token, g.zed_token_at FROM groups g JOIN user_groups u ON g.id = u.id
+
+	UNION ALL
+
+	SELECT zed_token, zed_token_at FROM user_zed_tokens WHERE user_id = ?`
+
+type zedTokenRow struct {
+	ZedToken   *string
+	ZedTokenAt *time.Time
+}
+
+// freshestZedToken picks the most recently written token of the sources,
+// which select zed_token and zed_token_at. It is empty when none has one.
+func freshestZedToken(tx *gorm.DB, sources string, args ...interface{}) (string, *time.Time, error) {
+	var row zedTokenRow
+	err := tx.Raw(`
+		SELECT zed_token, zed_token_at FROM (`+sources+`
+		) tokens
+		WHERE zed_token IS NOT NULL
+		ORDER BY zed_token_at DESC NULLS LAST
+		LIMIT 1;
+	`, args...).Scan(&row).Error
+	if err != nil || row.ZedToken == nil {
+		return "", nil, err
+	}
+	return *row.ZedToken, row.ZedTokenAt, nil
+}
+
+// nodeZedToken is the token a check of a user's access to a node has to be
+// at least as fresh as. Access can change on the node, on any ancestor, on
+// the workspace they are in or on the user's groups, so it is the freshest
+// token of all of them.
+func (svc *Service) nodeZedToken(
+	ctx context.Context,
+	NodeID uuid.UUID,
+	UserID uint64,
+) (string, *time.Time, error) {
+	return freshestZedToken(svc.DB.WithContext(ctx), `
+		WITH RECURSIVE ancestors AS (
+		SELECT id, parent_id, workspace_id, zed_token, zed_token_at FROM nodes WHERE id = ?
+
+		UNION ALL
+
+		SELECT n.id, n.parent_id, n.workspace_id, n.zed_token, n.zed_token_at FROM nodes n JOIN
+		ancestors a ON n.id = a.parent_id
+		), `+userGroupsCTE+`
+
+		SELECT zed_token, zed_token_at FROM ancestors
+
+		UNION ALL
+
+		SELECT w.zed_token, w.zed_token_at FROM workspaces w JOIN ancestors a ON w.id = a.workspace_id
+
+		UNION ALL
+		`+subjectZedTokens, NodeID, UserID, UserID)
+}
+
+// workspaceZedToken does the same for a user's access to a workspace.
+func (svc *Service) workspaceZedToken(
+	ctx context.Context,
+	WorkspaceID uuid.UUID,
+	UserID uint64,
+) (string, error) {
+	token, _, err := freshestZedToken(svc.DB.WithContext(ctx), `
+		WITH RECURSIVE `+userGroupsCTE+`
+
+		SELECT zed_token, zed_token_at FROM workspaces WHERE id = ?
+
+		UNION ALL
+		`+subjectZedTokens, UserID, WorkspaceID, UserID)
+	return token, err
+}
+
+// groupZedToken does the same for a user's access to a group.
+func (svc *Service) groupZedToken(
+	ctx context.Context,
+	GroupID uuid.UUID,
+	UserID uint64,
+) (string, error) {
+	token, _, err := freshestZedToken(svc.DB.WithContext(ctx), `
+		WITH RECURSIVE `+userGroupsCTE+`
+
+		SELECT zed_token, zed_token_at FROM groups WHERE id = ?
+
+		UNION ALL
+		`+subjectZedTokens, UserID, GroupID, UserID)
+	return token, err
+}
+
+// zedTokenUpdate stores a token along with the time it was written. The
+// database clock orders writes from every instance, and clock_timestamp
+// runs after the write to SpiceDB that gave the token.
+func zedTokenUpdate(token string) map[string]interface{} {
+	return map[string]interface{}{
+		"zed_token":    token,
+		"zed_token_at": gorm.Expr("clock_timestamp()"),
+	}
+}
+
+// storeUserZedToken remembers a write that took users out of a group.
+func storeUserZedToken(tx *gorm.DB, token string, UserIDs []uint64) error {
+	for _, userID := range UserIDs {
+		err := tx.Exec(`
+		INSERT INTO user_zed_tokens (user_id, zed_token, zed_token_at)
+		VALUES (?, ?, clock_timestamp())
+		ON CONFLICT (user_id) DO UPDATE
+		SET zed_token = EXCLUDED.zed_token, zed_token_at = EXCLUDED.zed_token_at;
+		`, userID, token).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPermission asks SpiceDB whether a user holds a permission on a node.
+// The check is at least as fresh as every relationship write that can have
+// changed the user's access, so nodes can be used right after they are
+// created, moved or shared, and not after access was taken back.
+func (svc *Service) checkPermission(
+	ctx context.Context,
+	node *Node,
+	UserID uint64,
+	Permission string,
+) error {
+	zedToken, _, err := svc.nodeZedToken(ctx, node.ID, UserID)
+	if err != nil {
+		return err
+	}
+	if zedToken == "" && node.ZedToken != nil {
+		zedToken = *node.ZedToken
+	}
+	allowed, err := svc.Authz.CheckPermOnResource(
+		ctx,
+		authorization.SubjectUser, userSubject(UserID),
+		authorization.ResourceNode, node.ID.String(),
+		Permission,
+		zedToken != "",
+		zedToken,
+	)
+	if err != nil {
+		return err
+	} else if !allowed {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+func parentRelationship(NodeID uuid.UUID, ParentID uuid.UUID) authorization.Relationship {
+	return authorization.Relationship{
+		ResourceType: authorization.ResourceNode,
+		ResourceID:   NodeID.String(),
+		Relation:     authorization.RelationParent,
+		SubjectType:  authorization.ResourceNode,
+		SubjectID:    ParentID.String(),
+	}
+}
+
+// storeZedToken remembers the token of the last relationship write on nodes.
+func storeZedToken(tx *gorm.DB, token string, ids []uuid.UUID) error {
+	for start := 0; start < len(ids); start += relateBatchSize {
+		batch := ids[start:min(len(ids), start+relateBatchSize)]
+		err := tx.Model(&Node{}).
+			Where("id IN ?", batch).
+			Updates(zedTokenUpdate(token)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relateNodes writes the owner and parent relationships of new nodes. It is
+// the last step of the transaction creating them, so a failed write rolls the
+// rows back.
+func (svc *Service) relateNodes(
+	ctx context.Context,
+	tx *gorm.DB,
+	nodes ...*Node,
+) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	relationships := make([]authorization.Relationship, 0, 2*len(nodes))
+	ids := make([]uuid.UUID, 0, len(nodes))
+	for _, node := range nodes {
+		relationships = append(relationships, authorization.Relationship{
+			ResourceType: authorization.ResourceNode,
+			ResourceID:   node.ID.String(),
+			Relation:     authorization.RelationOwner,
+			SubjectType:  authorization.SubjectUser,
+			SubjectID:    userSubject(node.OwnerID),
+		})
+		if node.ParentID != nil {
+			relationships = append(relationships, parentRelationship(node.ID, *node.ParentID))
+		}
+		ids = append(ids, node.ID)
+	}
+
+	token, err := svc.Authz.WriteRelationships(ctx, relationships, nil)
+	if err != nil {
+		return err
+	}
+	if err := storeZedToken(tx, token, ids); err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		node.ZedToken = &token
+	}
+	return nil
+}
+
+// reparentNode points a node's parent relationship at its new parent, nil
+// for the root. Like relateNodes it goes last in the transaction.
+func (svc *Service) reparentNode(
+	ctx context.Context,
+	tx *gorm.DB,
+	node *Node,
+	ParentID *uuid.UUID,
+) error {
+	var touch, remove []authorization.Relationship
+	// SpiceDB refuses deleting and touching one relationship in one write
+	if node.ParentID != nil && (ParentID == nil || *node.ParentID != *ParentID) {
+		remove = append(remove, parentRelationship(node.ID, *node.ParentID))
+	}
+	if ParentID != nil {
+		touch = append(touch, parentRelationship(node.ID, *ParentID))
+	}
+	if len(touch) == 0 && len(remove) == 0 {
+		return nil
+	}
+
+	token, err := svc.Authz.WriteRelationships(ctx, touch, remove)
+	if err != nil {
+		return err
+	}
+	if err := storeZedToken(tx, token, []uuid.UUID{node.ID}); err != nil {
+		return err
+	}
+	node.ParentID = ParentID
+	node.ZedToken = &token
+	return nil
+}
+
+// forgetNodes drops every relationship of nodes that were deleted. The rows
+// are already gone, so failures are only logged; nothing resolves the stale
+// relationships to a node anymore.
+func (svc *Service) forgetNodes(
+	ctx context.Context,
+	ids ...uuid.UUID,
+) {
+	resourceIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		resourceIDs = append(resourceIDs, id.String())
+	}
+	if len(resourceIDs) == 0 {
+		return
+	}
+	if _, err := svc.Authz.DeleteResourceRelationships(ctx, authorization.ResourceNode, resourceIDs); err != nil {
+		log.Printf("Failed to delete relationships of %d nodes: %v", len(resourceIDs), err)
+	}
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Every node and file version row referencing an object counts as one
+// reference on its blob. Objects written before deduplication have no blob
+// row yet; one is created on first use by counting the rows that reference
+// the key, so the count must always be taken before those rows change.
+
+// storeObject uploads data under a fresh key while hashing it.
+func (svc *Service) storeObject(
+	ctx context.Context,
+	data io.Reader,
+	Bytes uint64,
+) (string, string, error) {
+	key := uuid.NewString()
+	hash := sha256.New()
+
+	err := svc.Client.Put(ctx, svc.Cfg.Storage.BucketName, key, io.TeeReader(data, hash), int64(Bytes))
+	if err != nil {
+		return "", "", err
+	}
+	return key, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashObject reads back an object that was uploaded in pieces, returning its
+// sniffed MIME type and SHA-256.
+func (svc *Service) hashObject(
+	ctx context.Context,
+	Key string,
+) (string, string, error) {
+	stream, err := svc.Client.Get(ctx, svc.Cfg.Storage.BucketName, Key)
+	if err != nil {
+		return "", "", err
+	}
+	defer stream.Close()
+
+	mimeType, content, err := detectMimeType(stream)
+	if err != nil {
+		return "", "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", "", err
+	}
+	return mimeType, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// acquireBlob takes a reference on the blob with the given hash, registering
+// the freshly uploaded Key as that blob when the content is new. The returned
+// key is the one to reference; when it differs from Key the upload was a
+// duplicate and can be deleted once the transaction commits.
+func acquireBlob(tx *gorm.DB, Hash string, Key string, Bytes uint64) (string, error) {
+	var blobKey string
+	err := tx.Raw(`
+	INSERT INTO blobs (key, hash, size_bytes, ref_count, created_at)
+	VALUES (?, ?, ?, 1, now())
+	ON CONFLICT (hash) DO UPDATE SET ref_count = blobs.ref_count + 1
+	RETURNING key;
+	`, Key, Hash, Bytes).
+		Scan(&blobKey).Error
+	if err != nil {
+		return "", err
+	}
+	return blobKey, nil
+}
+
+func ensureBlob(tx *gorm.DB, Key string) error {
+	return tx.Exec(`
+	INSERT INTO blobs (key, ref_count, created_at)
+	VALUES (
+		?,
+		(SELECT count(*) FROM nodes WHERE key = ?) +
+		(SELECT count(*) FROM file_versions WHERE key = ?),
+		now()
+	)
+	ON CONFLICT (key) DO NOTHING;
+	`, Key, Key, Key).Error
+}
+
+// retainBlob adds a reference to an existing object, for rows that are about
+// to share it.
+func retainBlob(tx *gorm.DB, Key string, n int) error {
+	if err := ensureBlob(tx, Key); err != nil {
+		return err
+	}
+	return tx.Model(&Blob{}).
+		Where("key = ?", Key).
+		Update("ref_count", gorm.Expr("ref_count + ?", n)).Error
+}
+
+// releaseBlobs drops one reference per entry in keys, before the rows
+// holding them are changed or deleted. It returns the keys of objects that
+// are no longer referenced, to be deleted once the transaction commits.
+func releaseBlobs(tx *gorm.DB, keys []string) ([]string, error) {
+	counts := make(map[string]int, len(keys))
+	for _, key := range keys {
+		counts[key]++
+	}
+
+	var orphaned []string
+	for key, n := range counts {
+		if err := ensureBlob(tx, key); err != nil {
+			return nil, err
+		}
+
+		var remaining int64
+		err := tx.Raw(`
+		UPDATE blobs SET ref_count = ref_count - ?
+		WHERE key = ?
+		RETURNING ref_count;
+		`, n, key).
+			Scan(&remaining).Error
+		if err != nil {
+			return nil, err
+		}
+
+		if remaining <= 0 {
+			if err := tx.Delete(&Blob{}, "key = ?", key).Error; err != nil {
+				return nil, err
+			}
+			if err := tx.Delete(&DocumentText{}, "key = ?", key).Error; err != nil {
+				return nil, err
+			}
+			orphaned = append(orphaned, key)
+		}
+	}
+	return orphaned, nil
+}
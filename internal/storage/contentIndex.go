@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// IndexContent extracts the text of the object under Key and stores it for
+// content search. Content that is already indexed is not extracted again, so
+// copies and duplicate uploads cost nothing. The entry goes away together
+// with the blob once nothing references the object anymore.
+func (svc *Service) IndexContent(
+	ctx context.Context,
+	Key string,
+	MimeType string,
+	Bytes uint64,
+) error {
+	if !IsIndexable(MimeType) || Bytes > maxIndexedObjectBytes {
+		return nil
+	}
+
+	var count int64
+	err := svc.DB.WithContext(ctx).
+		Model(&DocumentText{}).
+		Where("key = ?", Key).
+		Count(&count).Error
+	if err != nil {
+		return err
+	} else if count > 0 {
+		return nil
+	}
+
+	stream, err := svc.Client.Get(ctx, svc.Cfg.Storage.BucketName, Key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(stream, maxIndexedObjectBytes))
+	stream.Close()
+	if err != nil {
+		return err
+	}
+
+	text, err := extractText(MimeType, data)
+	if err != nil {
+		return err
+	}
+
+	// The object may have been released while its text was extracted
+	return svc.DB.WithContext(ctx).Exec(`
+	INSERT INTO document_texts (key, content, indexed_at)
+	SELECT ?, ?, now()
+	WHERE EXISTS (SELECT 1 FROM blobs WHERE key = ?)
+		OR EXISTS (SELECT 1 FROM nodes WHERE key = ?)
+	ON CONFLICT (key) DO NOTHING;
+	`, Key, text, Key, Key).Error
+}
@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Nodes created per transaction, a background copy job writing its
+// progress after each
+const copyBatchSize = 500
+
+func (svc *Service) copyDirectory(
+	ctx context.Context,
+	root *Node,
+	Name string,
+	DestinationID *uuid.UUID,
+	OwnerID uint64,
+) (*CopyJob, error) {
+	nodes, err := svc.getSubtree(ctx, root.ID, root.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var size uint64
+	for _, node := range nodes {
+		if node.SizeBytes != nil && node.Status != NodeStatusPending {
+			size += *node.SizeBytes
+		}
+	}
+	if err := svc.checkQuota(ctx, OwnerID, size); err != nil {
+		return nil, err
+	}
+	if DestinationID != nil {
+		if err := svc.checkWorkspaceQuota(ctx, *DestinationID, size); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(nodes) <= svc.Cfg.Storage.CopyJobThreshold {
+		return nil, svc.copyTree(ctx, root.ID, Name, nodes, DestinationID, OwnerID, nil)
+	}
+
+	job := CopyJob{
+		ID:            uuid.New(),
+		OwnerID:       OwnerID,
+		TargetNodeID:  root.ID,
+		DestinationID: DestinationID,
+		Status:        JobStatusRunning,
+		TotalNodes:    len(nodes),
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	if err := svc.DB.WithContext(ctx).Create(&job).Error; err != nil {
+		return nil, err
+	}
+
+	go func() {
+		// The job outlives the request that started it
+		jobCtx := context.Background()
+		updates := map[string]interface{}{
+			"status":       JobStatusCompleted,
+			"copied_nodes": len(nodes),
+			"updated_at":   time.Now(),
+		}
+
+		if err := svc.copyTree(jobCtx, root.ID, Name, nodes, DestinationID, OwnerID, &job); err != nil {
+			log.Printf("Copy job %s failed: %v", job.ID, err)
+			updates = map[string]interface{}{
+				"status":     JobStatusFailed,
+				"error":      err.Error(),
+				"updated_at": time.Now(),
+			}
+		}
+
+		if err := svc.DB.WithContext(jobCtx).Model(&CopyJob{}).Where("id = ?", job.ID).Updates(updates).Error; err != nil {
+			log.Printf("Failed to update copy job %s: %v", job.ID, err)
+		}
+	}()
+
+	return &job, nil
+}
+
+// copyTree recreates nodes (ordered parents first) under DestinationID with
+// new IDs, the root being renamed to RootName. Files keep pointing at the
+// same objects, so only metadata and reference counts are written. Nodes are
+// committed in batches, and what was committed is purged again if a later
+// batch fails.
+func (svc *Service) copyTree(
+	ctx context.Context,
+	RootID uuid.UUID,
+	RootName string,
+	nodes []Node,
+	DestinationID *uuid.UUID,
+	OwnerID uint64,
+	job *CopyJob,
+) error {
+	newIDs := make(map[uuid.UUID]uuid.UUID, len(nodes))
+	for _, node := range nodes {
+		newIDs[node.ID] = uuid.New()
+	}
+
+	copies := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Status == NodeStatusPending {
+			continue
+		}
+
+		name := node.Name
+		parentID := DestinationID
+		if node.ID == RootID {
+			name = RootName
+		} else {
+			newParentID, ok := newIDs[*node.ParentID]
+			if !ok {
+				continue
+			}
+			parentID = &newParentID
+		}
+
+		copies = append(copies, Node{
+			ID:        newIDs[node.ID],
+			ParentID:  parentID,
+			OwnerID:   OwnerID,
+			Name:      name,
+			Type:      node.Type,
+			Key:       node.Key,
+			SizeBytes: node.SizeBytes,
+			MimeType:  node.MimeType,
+			CreatedAt: time.Now(),
+		})
+	}
+
+	for start := 0; start < len(copies); start += copyBatchSize {
+		batch := copies[start:min(len(copies), start+copyBatchSize)]
+		if err := svc.createCopies(ctx, batch, DestinationID, OwnerID); err != nil {
+			if start > 0 {
+				if purgeErr := svc.purgeSubtree(ctx, newIDs[RootID], OwnerID); purgeErr != nil {
+					log.Printf("Failed to remove partial copy %s: %v", newIDs[RootID], purgeErr)
+				}
+			}
+			return err
+		}
+
+		if job != nil {
+			svc.DB.WithContext(ctx).Model(&CopyJob{}).Where("id = ?", job.ID).
+				Updates(map[string]interface{}{
+					"copied_nodes": start + len(batch),
+					"updated_at":   time.Now(),
+				})
+		}
+	}
+	return nil
+}
+
+// createCopies commits a batch of copyTree's nodes along with the references
+// and usage they add.
+func (svc *Service) createCopies(
+	ctx context.Context,
+	copies []Node,
+	DestinationID *uuid.UUID,
+	OwnerID uint64,
+) error {
+	references := make(map[string]int)
+	var size int64
+	for _, node := range copies {
+		if node.Key != nil {
+			references[*node.Key]++
+		}
+		if node.SizeBytes != nil {
+			size += int64(*node.SizeBytes)
+		}
+	}
+
+	return svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for key, n := range references {
+			if err := retainBlob(tx, key, n); err != nil {
+				return err
+			}
+		}
+		if err := svc.chargeUsage(tx, OwnerID, size); err != nil {
+			return err
+		}
+		if err := svc.chargeWorkspaceUsage(tx, DestinationID, size); err != nil {
+			return err
+		}
+		if err := tx.Create(&copies).Error; err != nil {
+			return translateNameError(err)
+		}
+		created := make([]*Node, len(copies))
+		for i := range copies {
+			created[i] = &copies[i]
+		}
+		return svc.relateNodes(ctx, tx, created...)
+	})
+}
+
+func (svc *Service) GetCopyJob(
+	ctx context.Context,
+	JobID uuid.UUID,
+	UserID uint64,
+) (*CopyJob, error) {
+	var job CopyJob
+	err := svc.DB.WithContext(ctx).
+		Where("id = ? AND owner_id = ?", JobID, UserID).
+		First(&job).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	return &job, nil
+}
@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/shared"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// S3 allows at most 10000 parts in a single multipart upload
+const maxUploadParts = 10000
+
+func (svc *Service) InitiateUpload(
+	ctx context.Context,
+	UserID uint64,
+	ParentID uuid.UUID,
+	Name string,
+	Bytes uint64,
+	Checksum string,
+	Parts int,
+) (*UploadTicket, error) {
+	if Parts < 1 || Parts > maxUploadParts {
+		return nil, errors.New("invalid number of upload parts")
+	}
+	if err := validateNodeName(Name); err != nil {
+		return nil, err
+	}
+
+	var parentID *uuid.UUID
+
+	if ParentID != uuid.Nil {
+		parentID = &ParentID
+		if err := svc.canWriteIntoDirectory(ctx, ParentID, UserID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrParentNodeNotFound
+			}
+			return nil, err
+		}
+	}
+
+	if err := svc.checkQuota(ctx, UserID, Bytes); err != nil {
+		return nil, err
+	}
+	if err := svc.checkWorkspaceQuota(ctx, ParentID, Bytes); err != nil {
+		return nil, err
+	}
+
+	bucket := svc.Cfg.Storage.BucketName
+	expiry := svc.Cfg.Storage.DirectUploadExpiry
+	key := uuid.NewString()
+
+	ticket := UploadTicket{
+		NodeID:    uuid.New(),
+		ExpiresAt: time.Now().Add(expiry),
+	}
+
+	var multipartID string
+	if Parts == 1 {
+		url, err := svc.Client.GeneratePresignedPutURL(ctx, bucket, key, expiry)
+		if err != nil {
+			return nil, err
+		}
+		ticket.URL = url.String()
+	} else {
+		var err error
+		multipartID, err = svc.Client.NewMultipartUpload(ctx, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		for partNumber := 1; partNumber <= Parts; partNumber++ {
+			url, err := svc.Client.GeneratePresignedPartURL(ctx, bucket, key, multipartID, partNumber, expiry)
+			if err != nil {
+				_ = svc.Client.AbortMultipartUpload(ctx, bucket, key, multipartID)
+				return nil, err
+			}
+			ticket.PartURLs = append(ticket.PartURLs, url.String())
+		}
+	}
+
+	node := Node{
+		ID:        ticket.NodeID,
+		OwnerID:   UserID,
+		ParentID:  parentID,
+		Key:       &key,
+		CreatedAt: time.Now(),
+		SizeBytes: &Bytes,
+		Type:      NodeTypeFile,
+		Name:      Name,
+		Status:    NodeStatusPending,
+	}
+
+	directUpload := DirectUpload{
+		NodeID:      ticket.NodeID,
+		MultipartID: multipartID,
+		Checksum:    strings.ToLower(Checksum),
+		ExpiresAt:   ticket.ExpiresAt,
+	}
+
+	err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		name, _, err := resolveNameConflict(tx, parentID, UserID, Name, NodeTypeFile, node.ID, ConflictRename)
+		if err != nil {
+			return err
+		}
+		node.Name = name
+		ticket.Name = name
+
+		// Pending nodes count towards usage so bytes can't be uploaded past
+		// the quota through several tickets at once
+		if err := svc.chargeUsage(tx, UserID, int64(Bytes)); err != nil {
+			return err
+		}
+		if err := svc.chargeWorkspaceUsage(tx, parentID, int64(Bytes)); err != nil {
+			return err
+		}
+		if err := tx.Create(&node).Error; err != nil {
+			return translateNameError(err)
+		}
+		if err := tx.Create(&directUpload).Error; err != nil {
+			return err
+		}
+		return svc.relateNodes(ctx, tx, &node)
+	})
+
+	if err != nil {
+		if multipartID != "" {
+			_ = svc.Client.AbortMultipartUpload(ctx, bucket, key, multipartID)
+		}
+		return nil, err
+	}
+
+	return &ticket, nil
+}
+
+func (svc *Service) CompleteUpload(
+	ctx context.Context,
+	NodeID uuid.UUID,
+	UserID uint64,
+	Parts []shared.CompletedPart,
+) (*Node, error) {
+	var node Node
+	err := svc.DB.WithContext(ctx).
+		Where("id = ? AND owner_id = ? AND status = ?", NodeID, UserID, NodeStatusPending).
+		First(&node).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNodeNotFound
+		}
+		return nil, err
+	}
+
+	var directUpload DirectUpload
+	if err := svc.DB.WithContext(ctx).Where("node_id = ?", NodeID).First(&directUpload).Error; err != nil {
+		return nil, err
+	}
+	// The reaper may already be removing it
+	if time.Now().After(directUpload.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+
+	bucket := svc.Cfg.Storage.BucketName
+
+	if directUpload.MultipartID != "" {
+		err := svc.Client.CompleteMultipartUpload(ctx, bucket, *node.Key, directUpload.MultipartID, Parts)
+		if err != nil {
+			return nil, err
+		}
+		// The multipart upload is gone now, a retry only needs to verify
+		err = svc.DB.WithContext(ctx).
+			Model(&DirectUpload{}).
+			Where("node_id = ?", NodeID).
+			Update("multipart_id", "").Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := svc.Client.Stat(ctx, bucket, *node.Key)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(info.Size) != *node.SizeBytes {
+		return nil, ErrUploadIncomplete
+	}
+
+	mimeType, hash, err := svc.hashObject(ctx, *node.Key)
+	if err != nil {
+		return nil, err
+	}
+	if directUpload.Checksum != "" && hash != directUpload.Checksum {
+		return nil, ErrChecksumMismatch
+	}
+
+	uploadedKey := *node.Key
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		key, err := acquireBlob(tx, hash, uploadedKey, *node.SizeBytes)
+		if err != nil {
+			return err
+		}
+
+		result := tx.Model(&Node{}).
+			Where("id = ? AND status = ?", NodeID, NodeStatusPending).
+			Updates(map[string]interface{}{
+				"key":       key,
+				"status":    NodeStatusActive,
+				"mime_type": mimeType,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNodeNotFound
+		}
+		node.Key = &key
+		return tx.Delete(&DirectUpload{}, "node_id = ?", NodeID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The same content was already stored
+	if *node.Key != uploadedKey {
+		svc.deleteObjects(uploadedKey)
+	}
+
+	node.Status = NodeStatusActive
+	node.MimeType = &mimeType
+	return &node, nil
+}
+
+// ReapPendingUploads removes pending nodes whose presigned URLs have expired
+// without the upload ever being completed, along with any uploaded bytes.
+func (svc *Service) ReapPendingUploads(ctx context.Context) error {
+	var expired []DirectUpload
+	err := svc.DB.WithContext(ctx).
+		Where("expires_at < ?", time.Now()).
+		Find(&expired).Error
+	if err != nil {
+		return err
+	}
+
+	bucket := svc.Cfg.Storage.BucketName
+	reaped := 0
+	for _, directUpload := range expired {
+		// The node may have been completed or deleted since, only a node
+		// still pending once it's gone has its usage and bytes released
+		var node Node
+		pending := false
+		err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			result := tx.Clauses(clause.Returning{}).
+				Where("id = ? AND status = ?", directUpload.NodeID, NodeStatusPending).
+				Delete(&node)
+			if result.Error != nil {
+				return result.Error
+			}
+			if err := tx.Delete(&DirectUpload{}, "node_id = ?", directUpload.NodeID).Error; err != nil {
+				return err
+			}
+			if result.RowsAffected != 1 {
+				return nil
+			}
+			pending = true
+
+			if node.SizeBytes != nil {
+				if err := svc.chargeUsage(tx, node.OwnerID, -int64(*node.SizeBytes)); err != nil {
+					return err
+				}
+				if err := svc.chargeWorkspaceUsage(tx, node.ParentID, -int64(*node.SizeBytes)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !pending {
+			continue
+		}
+
+		if node.Key != nil {
+			if directUpload.MultipartID != "" {
+				_ = svc.Client.AbortMultipartUpload(ctx, bucket, *node.Key, directUpload.MultipartID)
+			}
+			_ = svc.Client.Delete(ctx, bucket, *node.Key)
+		}
+		svc.forgetNodes(ctx, directUpload.NodeID)
+		reaped++
+	}
+
+	if reaped > 0 {
+		log.Printf("Reaped %d expired pending uploads", reaped)
+	}
+	return nil
+}
+
+func (svc *Service) StartUploadReaper(ctx context.Context) {
+	ticker := time.NewTicker(svc.Cfg.Storage.UploadReaperInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := svc.ReapPendingUploads(ctx); err != nil {
+				log.Println("Error reaping pending uploads: ", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+)
+
+// notModified evaluates If-None-Match and, when absent, If-Modified-Since.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		return err == nil && !lastModified.After(since)
+	}
+	return false
+}
+
+// rangeApplies evaluates If-Range. A range request is only honoured when the
+// validator still matches, otherwise the full representation is sent.
+func rangeApplies(r *http.Request, etag string, lastModified time.Time) bool {
+	ifRange := r.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if ifRange[0] == '"' {
+		return ifRange == etag
+	}
+	if date, err := http.ParseTime(ifRange); err == nil {
+		return lastModified.Equal(date)
+	}
+	return false
+}
+
+// StreamDownload serves a file over GET (and HEAD) with support for byte
+// ranges and conditional requests, so browsers can seek in media and
+// download managers can resume.
+func (h *Handler) StreamDownload(c echo.Context) error {
+	ctx := c.Request().Context()
+	req := c.Request()
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	node, info, err := h.svc.StatData(ctx, id, user.ID)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error getting data from storage service")
+	}
+
+	etag := fmt.Sprintf(`"%s"`, info.ETag)
+	// Objects are shared between files with the same content, so the object's
+	// own date can be older than the file's. Replacing the content of a file
+	// resets its created_at.
+	lastModified := node.CreatedAt.UTC().Truncate(time.Second)
+	mimeType := "application/octet-stream"
+	if node.MimeType != nil && *node.MimeType != "" {
+		mimeType = *node.MimeType
+	}
+
+	header := c.Response().Header()
+	header.Set("ETag", etag)
+	header.Set(echo.HeaderLastModified, lastModified.Format(http.TimeFormat))
+	header.Set("Accept-Ranges", "bytes")
+
+	if notModified(req, etag, lastModified) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	header.Set(echo.HeaderContentType, mimeType)
+	header.Set(
+		echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="%s"`, node.Name),
+	)
+
+	offset, length := int64(0), info.Size
+	status := http.StatusOK
+
+	if rangeHeader := req.Header.Get("Range"); rangeHeader != "" && rangeApplies(req, etag, lastModified) {
+		start, n, err := parseByteRange(rangeHeader, info.Size)
+		if errors.Is(err, errRangeNotSatisfiable) {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+			return c.NoContent(http.StatusRequestedRangeNotSatisfiable)
+		}
+		// Malformed or multi-part ranges fall back to the full body
+		if err == nil {
+			offset, length = start, n
+			status = http.StatusPartialContent
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+n-1, info.Size))
+		}
+	}
+
+	header.Set(echo.HeaderContentLength, strconv.FormatInt(length, 10))
+	if req.Method == http.MethodHead {
+		c.Response().WriteHeader(status)
+		return nil
+	}
+
+	var stream io.ReadCloser
+	if status == http.StatusPartialContent {
+		stream, _, err = h.svc.GetDataRange(ctx, id, user.ID, offset, length)
+	} else {
+		stream, _, err = h.svc.GetData(ctx, id, user.ID)
+	}
+	if err != nil {
+		log.Println(err)
+		header.Del(echo.HeaderContentLength)
+		return c.JSON(errorStatus(err), "error getting data from storage service")
+	}
+	defer stream.Close()
+
+	c.Response().WriteHeader(status)
+	_, err = io.Copy(c.Response().Writer, stream)
+	return err
+}
+
+// DownloadArchive streams a directory, or a selection of nodes, as a single
+// archive. Once streaming starts errors can no longer be reported, the
+// connection is simply cut short.
+func (h *Handler) DownloadArchive(c echo.Context) error {
+	var req DownloadArchive
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	format, err := ParseArchiveFormat(req.Format)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	nodeIds := make([]uuid.UUID, 0, len(req.NodeIDs))
+	for _, rawId := range req.NodeIDs {
+		id, err := uuid.Parse(rawId)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "invalid id param")
+		}
+		nodeIds = append(nodeIds, id)
+	}
+	if len(nodeIds) == 0 {
+		return c.JSON(http.StatusBadRequest, "missing ids param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	archive, err := h.svc.PrepareArchive(ctx, nodeIds, user.ID)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error preparing archive")
+	}
+
+	c.Response().Header().Set(
+		echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="%s.%s"`, archive.Name, format),
+	)
+	c.Response().Header().Set(echo.HeaderContentType, format.ContentType())
+	c.Response().WriteHeader(http.StatusOK)
+
+	if err := h.svc.WriteArchive(ctx, archive, format, c.Response().Writer); err != nil {
+		log.Println("Error streaming archive: ", err)
+	}
+	return nil
+}
@@ -5,10 +5,42 @@ import (
 )
 
 var (
-	ErrNodeNotFound   = errors.New("node not found")
+	ErrNodeNotFound         = errors.New("node not found")
 	ErrParentNodeNotFound   = errors.New("parent node not found")
-	ErrNodeIsDirectory = errors.New("node is a directory")
-	ErrNodeIsFile = errors.New("node is a file")
-	ErrUnauthorized   = errors.New("unauthorized")
-	ErrNoObjectData   = errors.New("node has no object data")
+	ErrNodeIsDirectory      = errors.New("node is a directory")
+	ErrNodeIsFile           = errors.New("node is a file")
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrNoObjectData         = errors.New("node has no object data")
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+	ErrUploadIncomplete     = errors.New("uploaded object does not match declared size")
+	ErrChecksumMismatch     = errors.New("uploaded object checksum mismatch")
+	ErrUploadExpired        = errors.New("upload ticket has expired")
+	ErrJobNotFound          = errors.New("job not found")
+	ErrNameConflict         = errors.New("a node with this name already exists")
+	ErrInvalidName          = errors.New("invalid node name")
+	ErrTrashItemNotFound    = errors.New("trash item not found")
+	ErrVersionNotFound      = errors.New("file version not found")
+	ErrQuotaExceeded        = errors.New("storage quota exceeded")
+	ErrUnsupportedArchive   = errors.New("unsupported or corrupt archive")
+	ErrArchiveTooLarge      = errors.New("archive exceeds extraction limits")
+	ErrShareLinkNotFound    = errors.New("share link not found")
+	ErrShareLinkExpired     = errors.New("share link has expired")
+	ErrShareDownloadLimit   = errors.New("share link download limit reached")
+	ErrSharePasswordInvalid = errors.New("share link password is missing or wrong")
+	ErrFileRequestNotFound  = errors.New("file request not found")
+	ErrFileRequestExpired   = errors.New("file request has expired")
+	ErrFileTooLarge         = errors.New("file exceeds the size limit")
+	ErrFileTypeNotAllowed   = errors.New("file type is not accepted")
+	ErrUserNotFound         = errors.New("user not found")
+	ErrAccessNotFound       = errors.New("user has no role on this node")
+	ErrOwnerAccess          = errors.New("the owner's access can't be changed")
+	ErrInvalidCursor        = errors.New("invalid cursor")
+	ErrWorkspaceNotFound    = errors.New("workspace not found")
+	ErrWorkspaceRoot        = errors.New("the root of a workspace is managed through the workspace")
+	ErrWorkspaceOwner       = errors.New("the workspace owner can't be changed or removed")
+	ErrWorkspaceQuota       = errors.New("workspace storage quota exceeded")
+	ErrGroupNotFound        = errors.New("group not found")
+	ErrGroupOwner           = errors.New("the group owner can't be changed or removed")
+	ErrGroupCycle           = errors.New("a group can't contain itself")
 )
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Limits on what a single uploaded archive may expand to
+const (
+	maxExtractEntries   = 10000
+	maxExtractBytes     = 20 << 30 // 20 GiB uncompressed
+	maxCompressionRatio = 200      // Per entry, uncompressed over compressed size
+)
+
+const (
+	extractCreated = "created"
+	extractSkipped = "skipped"
+	extractFailed  = "failed"
+)
+
+// archiveKind picks the archive reader from the uploaded file name.
+func archiveKind(filename string) (string, error) {
+	lower := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip", nil
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz", nil
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar", nil
+	}
+	return "", ErrUnsupportedArchive
+}
+
+// sanitizeEntryPath turns an archive entry name into a clean relative path,
+// rejecting anything that would land outside the target directory.
+func sanitizeEntryPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(name, "/") {
+		return "", ErrInvalidName
+	}
+
+	cleaned := path.Clean(name)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidName
+	}
+	for _, part := range strings.Split(cleaned, "/") {
+		if err := validateNodeName(part); err != nil {
+			return "", err
+		}
+	}
+	return cleaned, nil
+}
+
+// extractor creates the nodes for an archive through the storage service
+// the handler was given, so after-put hooks run for every extracted file.
+type extractor struct {
+	ctx      context.Context
+	svc      StorageService
+	userID   uint64
+	strategy ConflictStrategy
+	dirs     map[string]uuid.UUID // Extracted directory paths, "." being the target
+	summary  ExtractSummary
+	entries  int
+	bytes    uint64
+}
+
+func newExtractor(
+	ctx context.Context,
+	svc StorageService,
+	UserID uint64,
+	ParentID uuid.UUID,
+	Strategy ConflictStrategy,
+) *extractor {
+	return &extractor{
+		ctx:      ctx,
+		svc:      svc,
+		userID:   UserID,
+		strategy: Strategy,
+		dirs:     map[string]uuid.UUID{".": ParentID},
+	}
+}
+
+func (e *extractor) record(entryPath, status string, node *Node, err error) {
+	result := ExtractResult{Path: entryPath, Status: status}
+	if node != nil {
+		result.NodeID = &node.ID
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	switch status {
+	case extractCreated:
+		e.summary.Created++
+	case extractSkipped:
+		e.summary.Skipped++
+	case extractFailed:
+		e.summary.Failed++
+	}
+	e.summary.Results = append(e.summary.Results, result)
+}
+
+// admit enforces the archive wide limits before an entry is extracted.
+func (e *extractor) admit(size uint64) error {
+	e.entries++
+	e.bytes += size
+	if e.entries > maxExtractEntries || e.bytes > maxExtractBytes {
+		return ErrArchiveTooLarge
+	}
+	return nil
+}
+
+// directory returns the node for an extracted directory, creating it and its
+// parents as needed. Directories that already exist are merged into.
+func (e *extractor) directory(dirPath string) (uuid.UUID, error) {
+	if id, ok := e.dirs[dirPath]; ok {
+		return id, nil
+	}
+
+	parentID, err := e.directory(path.Dir(dirPath))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	name := path.Base(dirPath)
+
+	node, err := e.svc.CreateDirectoryNode(e.ctx, name, parentID, e.userID, ConflictFail)
+	if errors.Is(err, ErrNameConflict) {
+		siblings, err := e.svc.ListNodes(e.ctx, parentID, e.userID)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		for _, sibling := range siblings {
+			if sibling.Name == name && sibling.Type == NodeTypeDirectory {
+				e.dirs[dirPath] = sibling.ID
+				return sibling.ID, nil
+			}
+		}
+		return uuid.Nil, ErrNameConflict
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	e.dirs[dirPath] = node.ID
+	e.record(dirPath, extractCreated, node, nil)
+	return node.ID, nil
+}
+
+func (e *extractor) file(entryPath string, size uint64, content io.Reader) {
+	parentID, err := e.directory(path.Dir(entryPath))
+	if err != nil {
+		e.record(entryPath, extractFailed, nil, err)
+		return
+	}
+
+	mimeType, stream, err := e.svc.DetectMimeType(e.ctx, io.NopCloser(content))
+	if err != nil {
+		e.record(entryPath, extractFailed, nil, err)
+		return
+	}
+
+	node, err := e.svc.Put(e.ctx, e.userID, parentID, path.Base(entryPath), size, stream, mimeType, e.strategy)
+	if err != nil {
+		e.record(entryPath, extractFailed, nil, err)
+		return
+	}
+	e.record(entryPath, extractCreated, node, nil)
+}
+
+func (e *extractor) extractZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return ErrUnsupportedArchive
+	}
+
+	// The central directory is known up front, so an archive that would
+	// blow past the limits is rejected before anything is created
+	var total uint64
+	for _, f := range zr.File {
+		total += f.UncompressedSize64
+	}
+	if len(zr.File) > maxExtractEntries || total > maxExtractBytes {
+		return ErrArchiveTooLarge
+	}
+
+	for _, f := range zr.File {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+
+		entryPath, err := sanitizeEntryPath(f.Name)
+		if err != nil {
+			e.record(f.Name, extractSkipped, nil, err)
+			continue
+		}
+		if err := e.admit(f.UncompressedSize64); err != nil {
+			return err
+		}
+
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if _, err := e.directory(entryPath); err != nil {
+				e.record(entryPath, extractFailed, nil, err)
+			}
+		case !mode.IsRegular():
+			e.record(entryPath, extractSkipped, nil, errors.New("not a regular file"))
+		case f.CompressedSize64 > 0 && f.UncompressedSize64/f.CompressedSize64 > maxCompressionRatio:
+			e.record(entryPath, extractSkipped, nil, errors.New("suspicious compression ratio"))
+		default:
+			// archive/zip refuses to read past the declared size
+			content, err := f.Open()
+			if err != nil {
+				e.record(entryPath, extractFailed, nil, err)
+				continue
+			}
+			e.file(entryPath, f.UncompressedSize64, content)
+			content.Close()
+		}
+	}
+	return nil
+}
+
+func (e *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+
+	for {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return ErrUnsupportedArchive
+		}
+
+		entryPath, err := sanitizeEntryPath(header.Name)
+		if err != nil {
+			e.record(header.Name, extractSkipped, nil, err)
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := e.admit(0); err != nil {
+				return err
+			}
+			if _, err := e.directory(entryPath); err != nil {
+				e.record(entryPath, extractFailed, nil, err)
+			}
+		case tar.TypeReg:
+			if err := e.admit(uint64(header.Size)); err != nil {
+				return err
+			}
+			// The tar reader stops at the size from the header
+			e.file(entryPath, uint64(header.Size), tr)
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			e.record(entryPath, extractSkipped, nil, errors.New("not a regular file"))
+		}
+	}
+}
+
+// ExtractArchive unpacks an uploaded archive under ParentID. Entries are
+// extracted one at a time and their outcome recorded; the returned error is
+// only set when the archive as a whole had to be abandoned, in which case
+// the summary covers what was extracted before that.
+func ExtractArchive(
+	ctx context.Context,
+	svc StorageService,
+	UserID uint64,
+	ParentID uuid.UUID,
+	Filename string,
+	data io.ReaderAt,
+	Size int64,
+	Strategy ConflictStrategy,
+) (*ExtractSummary, error) {
+	kind, err := archiveKind(Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	e := newExtractor(ctx, svc, UserID, ParentID, Strategy)
+	stream := io.NewSectionReader(data, 0, Size)
+
+	switch kind {
+	case "zip":
+		err = e.extractZip(data, Size)
+	case "tar.gz":
+		gr, gzErr := gzip.NewReader(stream)
+		if gzErr != nil {
+			return nil, ErrUnsupportedArchive
+		}
+		defer gr.Close()
+		err = e.extractTar(gr)
+	default:
+		err = e.extractTar(stream)
+	}
+
+	return &e.summary, err
+}
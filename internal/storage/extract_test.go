@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSanitizeEntryPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+		want  string
+	}{
+		{"plain", "docs/readme.txt", "docs/readme.txt"},
+		{"backslashes", `docs\readme.txt`, "docs/readme.txt"},
+		{"directory entry", "docs/", "docs"},
+		{"dot segments", "./a/../b.txt", "b.txt"},
+		{"doubled slashes", "a//b", "a/b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeEntryPath(tt.entry)
+			if err != nil {
+				t.Fatalf("sanitizeEntryPath(%q) failed: %v", tt.entry, err)
+			}
+			if got != tt.want {
+				t.Errorf("sanitizeEntryPath(%q) = %q, want %q", tt.entry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeEntryPathRejects(t *testing.T) {
+	for _, entry := range []string{
+		"/etc/passwd",
+		`\windows\system.ini`,
+		"../evil",
+		"a/../../evil",
+		`a\..\..\evil`,
+		"..",
+		".",
+		"",
+		"a/\x00b",
+		"a/ /b",
+	} {
+		if got, err := sanitizeEntryPath(entry); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("sanitizeEntryPath(%q) = %q, %v, want %v", entry, got, err, ErrInvalidName)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+)
+
+func (h *Handler) CreateFileRequest(c echo.Context) error {
+	var req CreateFileRequest
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.DirectoryID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return c.JSON(http.StatusBadRequest, "expires_at must be in the future")
+	}
+	for _, allowedType := range req.AllowedTypes {
+		if strings.Contains(allowedType, ",") || !strings.Contains(allowedType, "/") {
+			return c.JSON(http.StatusBadRequest, "invalid allowed_types param")
+		}
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	request, err := h.svc.CreateFileRequest(ctx, user.ID, id, FileRequestOptions{
+		Title:        req.Title,
+		Message:      req.Message,
+		MaxFileBytes: req.MaxFileBytes,
+		AllowedTypes: req.AllowedTypes,
+		ExpiresAt:    req.ExpiresAt,
+	})
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error creating file request")
+	}
+	return c.JSON(http.StatusCreated, request)
+}
+
+func (h *Handler) ListFileRequests(c echo.Context) error {
+	ctx := c.Request().Context()
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	requests, err := h.svc.ListFileRequests(ctx, user.ID)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error listing file requests")
+	}
+	return c.JSON(http.StatusOK, requests)
+}
+
+func (h *Handler) ListFileRequestUploads(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	uploads, err := h.svc.ListFileRequestUploads(ctx, id, user.ID)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error listing file request uploads")
+	}
+	return c.JSON(http.StatusOK, uploads)
+}
+
+func (h *Handler) CloseFileRequest(c echo.Context) error {
+	var req CloseFileRequest
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "missing id param in request body")
+	}
+	id, err := uuid.Parse(req.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	if err := h.svc.CloseFileRequest(ctx, id, user.ID); err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error closing file request")
+	}
+	return c.JSON(http.StatusOK, "file request closed")
+}
+
+// fileRequestError tells an uploader why a file request can't be used,
+// without giving away anything internal.
+func fileRequestError(c echo.Context, err error) error {
+	log.Println(err)
+	status := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		return c.JSON(status, "error using file request")
+	}
+	return c.JSON(status, err.Error())
+}
+
+// OpenFileRequest shows an anonymous uploader what is being asked for.
+// Nothing about the target directory is revealed.
+func (h *Handler) OpenFileRequest(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	request, err := h.svc.OpenFileRequest(ctx, c.Param("token"))
+	if err != nil {
+		return fileRequestError(c, err)
+	}
+
+	allowedTypes := []string{}
+	if request.AllowedTypes != "" {
+		allowedTypes = strings.Split(request.AllowedTypes, ",")
+	}
+	return c.JSON(http.StatusOK, PublicFileRequest{
+		Title:        request.Title,
+		Message:      request.Message,
+		MaxFileBytes: request.MaxFileBytes,
+		AllowedTypes: allowedTypes,
+		ExpiresAt:    request.ExpiresAt,
+	})
+}
+
+// UploadFileRequest drops a file into the target directory of a file
+// request. It goes through the regular Put so after-put hooks run, and the
+// owner is notified once it is recorded.
+func (h *Handler) UploadFileRequest(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	request, err := h.svc.OpenFileRequest(ctx, c.Param("token"))
+	if err != nil {
+		return fileRequestError(c, err)
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		log.Println(err.Error())
+		return c.JSON(http.StatusBadRequest, "missing file")
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, "cannot open file")
+	}
+	defer file.Close()
+
+	mimeType, newStream, err := h.svc.DetectMimeType(ctx, file)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid file stream")
+	}
+	if err := checkFileRequestUpload(request, uint64(fileHeader.Size), mimeType); err != nil {
+		return fileRequestError(c, err)
+	}
+
+	// Uploaders can't see what is already there, so nothing is overwritten
+	node, err := h.svc.Put(ctx, request.OwnerID, request.DirectoryID, fileHeader.Filename, uint64(fileHeader.Size), newStream, mimeType, ConflictRename)
+	if err != nil {
+		return fileRequestError(c, err)
+	}
+	if _, err := h.svc.RecordFileRequestUpload(ctx, request, node, c.FormValue("uploader_name")); err != nil {
+		log.Println(err)
+	}
+	// The stored name may have been changed to avoid a conflict, which
+	// would tell the uploader what else is in the directory
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"name":       fileHeader.Filename,
+		"size_bytes": node.SizeBytes,
+	})
+}
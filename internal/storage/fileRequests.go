@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
+	"gorm.io/gorm"
+)
+
+const maxUploaderNameLength = 100
+
+// deleteFileRequests removes the matching file requests along with the
+// record of what arrived through them. The uploaded files stay.
+func deleteFileRequests(tx *gorm.DB, query string, args ...interface{}) error {
+	ids := tx.Model(&FileRequest{}).Select("id").Where(query, args...)
+	if err := tx.Where("request_id IN (?)", ids).Delete(&FileRequestUpload{}).Error; err != nil {
+		return err
+	}
+	return tx.Where(query, args...).Delete(&FileRequest{}).Error
+}
+
+// typeAllowed matches a MIME type against a comma separated list of types,
+// entries ending in "/" matching every subtype. An empty list allows all.
+func typeAllowed(allowed string, mimeType string) bool {
+	if strings.TrimSpace(allowed) == "" {
+		return true
+	}
+	mimeType = baseMimeType(mimeType)
+	for _, entry := range strings.Split(allowed, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == mimeType || strings.HasSuffix(entry, "/") && strings.HasPrefix(mimeType, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func (svc *Service) CreateFileRequest(
+	ctx context.Context,
+	UserID uint64,
+	DirectoryID uuid.UUID,
+	Options FileRequestOptions,
+) (*FileRequest, error) {
+	dir, err := svc.GetNode(ctx, DirectoryID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNodeNotFound
+		}
+		return nil, err
+	}
+	if dir.Status != NodeStatusActive {
+		return nil, ErrNodeNotFound
+	} else if dir.Type != NodeTypeDirectory {
+		return nil, ErrNodeIsFile
+	}
+	if err := svc.checkPermission(ctx, dir, UserID, authorization.PermissionExecute); err != nil {
+		return nil, err
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		return nil, err
+	}
+	request := FileRequest{
+		ID:           uuid.New(),
+		Token:        token,
+		OwnerID:      UserID,
+		DirectoryID:  dir.ID,
+		Title:        Options.Title,
+		Message:      Options.Message,
+		MaxFileBytes: Options.MaxFileBytes,
+		AllowedTypes: strings.Join(Options.AllowedTypes, ","),
+		ExpiresAt:    Options.ExpiresAt,
+		CreatedAt:    time.Now(),
+	}
+	if err := svc.DB.WithContext(ctx).Create(&request).Error; err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (svc *Service) ListFileRequests(
+	ctx context.Context,
+	UserID uint64,
+) ([]FileRequest, error) {
+	requests := []FileRequest{}
+	err := svc.DB.WithContext(ctx).
+		Where("owner_id = ?", UserID).
+		Order("created_at DESC").
+		Find(&requests).Error
+	if err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+func (svc *Service) ListFileRequestUploads(
+	ctx context.Context,
+	RequestID uuid.UUID,
+	UserID uint64,
+) ([]FileRequestUpload, error) {
+	db := svc.DB.WithContext(ctx)
+
+	var count int64
+	err := db.Model(&FileRequest{}).
+		Where("id = ? AND owner_id = ?", RequestID, UserID).
+		Count(&count).Error
+	if err != nil {
+		return nil, err
+	} else if count == 0 {
+		return nil, ErrFileRequestNotFound
+	}
+
+	uploads := []FileRequestUpload{}
+	err = db.Where("request_id = ?", RequestID).
+		Order("created_at DESC").
+		Find(&uploads).Error
+	if err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+// CloseFileRequest deletes a file request, the link stops working right away.
+func (svc *Service) CloseFileRequest(
+	ctx context.Context,
+	RequestID uuid.UUID,
+	UserID uint64,
+) error {
+	return svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&FileRequest{}).
+			Where("id = ? AND owner_id = ?", RequestID, UserID).
+			Count(&count).Error
+		if err != nil {
+			return err
+		} else if count == 0 {
+			return ErrFileRequestNotFound
+		}
+		return deleteFileRequests(tx, "id = ?", RequestID)
+	})
+}
+
+// OpenFileRequest resolves a token for an anonymous uploader. The target
+// directory has to still be live.
+func (svc *Service) OpenFileRequest(
+	ctx context.Context,
+	Token string,
+) (*FileRequest, error) {
+	var request FileRequest
+	err := svc.DB.WithContext(ctx).
+		Where("token = ?", Token).
+		First(&request).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrFileRequestNotFound
+		}
+		return nil, err
+	}
+	if request.ExpiresAt != nil && time.Now().After(*request.ExpiresAt) {
+		return nil, ErrFileRequestExpired
+	}
+
+	dir, err := svc.GetNode(ctx, request.DirectoryID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrFileRequestNotFound
+		}
+		return nil, err
+	}
+	if dir.Status != NodeStatusActive {
+		return nil, ErrFileRequestNotFound
+	}
+	return &request, nil
+}
+
+// checkFileRequestUpload applies the request's limits to a file before it
+// is stored.
+func checkFileRequestUpload(Request *FileRequest, Bytes uint64, MimeType string) error {
+	if Request.MaxFileBytes != nil && Bytes > *Request.MaxFileBytes {
+		return ErrFileTooLarge
+	}
+	if !typeAllowed(Request.AllowedTypes, MimeType) {
+		return ErrFileTypeNotAllowed
+	}
+	return nil
+}
+
+// RecordFileRequestUpload remembers who dropped a file, once it is stored.
+func (svc *Service) RecordFileRequestUpload(
+	ctx context.Context,
+	Request *FileRequest,
+	node *Node,
+	UploaderName string,
+) (*FileRequestUpload, error) {
+	UploaderName = strings.TrimSpace(UploaderName)
+	if len(UploaderName) > maxUploaderNameLength {
+		UploaderName = UploaderName[:maxUploaderNameLength]
+	}
+
+	upload := FileRequestUpload{
+		ID:           uuid.New(),
+		RequestID:    Request.ID,
+		NodeID:       node.ID,
+		Name:         node.Name,
+		UploaderName: strings.ToValidUTF8(UploaderName, ""),
+		CreatedAt:    time.Now(),
+	}
+	if node.SizeBytes != nil {
+		upload.SizeBytes = *node.SizeBytes
+	}
+	if node.MimeType != nil {
+		upload.MimeType = *node.MimeType
+	}
+
+	err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&upload).Error; err != nil {
+			return err
+		}
+		return tx.Model(&FileRequest{}).
+			Where("id = ?", Request.ID).
+			Update("upload_count", gorm.Expr("upload_count + 1")).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
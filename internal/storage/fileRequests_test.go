@@ -0,0 +1,30 @@
+package storage
+
+import "testing"
+
+func TestTypeAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowed  string
+		mimeType string
+		want     bool
+	}{
+		{"empty list", "", "application/zip", true},
+		{"blank list", "  ", "application/zip", true},
+		{"exact", "application/pdf", "application/pdf", true},
+		{"exact with parameters", "text/plain", "text/plain; charset=utf-8", true},
+		{"one of several", "image/png, application/pdf", "application/pdf", true},
+		{"every subtype", "image/", "image/jpeg", true},
+		{"subtype prefix needs the slash", "image", "image/jpeg", false},
+		{"other type", "image/", "video/mp4", false},
+		{"exact mismatch", "application/pdf", "application/pdfx", false},
+		{"not a prefix match", "text/plain", "text/plain-extended", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := typeAllowed(tt.allowed, tt.mimeType); got != tt.want {
+				t.Errorf("typeAllowed(%q, %q) = %v, want %v", tt.allowed, tt.mimeType, got, tt.want)
+			}
+		})
+	}
+}
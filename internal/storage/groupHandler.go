@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+)
+
+func (h *Handler) CreateGroup(c echo.Context) error {
+	var req CreateGroup
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return c.JSON(http.StatusBadRequest, "missing name param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	group, err := h.svc.CreateGroup(ctx, user.ID, name)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error creating group")
+	}
+	return c.JSON(http.StatusCreated, group)
+}
+
+func (h *Handler) ListGroups(c echo.Context) error {
+	ctx := c.Request().Context()
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	groups, err := h.svc.ListGroups(ctx, user.ID)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error listing groups")
+	}
+	return c.JSON(http.StatusOK, groups)
+}
+
+func (h *Handler) DeleteGroup(c echo.Context) error {
+	var req DeleteGroup
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "missing id param in request body")
+	}
+	id, err := uuid.Parse(req.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	if err := h.svc.DeleteGroup(ctx, user.ID, id); err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error deleting group")
+	}
+	return c.JSON(http.StatusOK, "group deleted")
+}
+
+func (h *Handler) AddGroupMember(c echo.Context) error {
+	var req AddGroupMember
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	if req.GroupID != "" {
+		memberGroupID, err := uuid.Parse(req.GroupID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "invalid group_id param")
+		}
+		member, err := h.svc.AddNestedGroup(ctx, user.ID, id, memberGroupID)
+		if err != nil {
+			log.Println(err)
+			return c.JSON(errorStatus(err), "error adding group member")
+		}
+		return c.JSON(http.StatusCreated, member)
+	}
+
+	member := strings.TrimSpace(req.User)
+	if member == "" {
+		return c.JSON(http.StatusBadRequest, "missing user param")
+	}
+	role := GroupRole(req.Role)
+	if req.Role == "" {
+		role = GroupRoleMember
+	} else if !role.assignable() {
+		return c.JSON(http.StatusBadRequest, "invalid role param")
+	}
+
+	added, err := h.svc.AddGroupMember(ctx, user.ID, id, member, role)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error adding group member")
+	}
+	return c.JSON(http.StatusCreated, added)
+}
+
+func (h *Handler) RemoveGroupMember(c echo.Context) error {
+	var req RemoveGroupMember
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	if req.GroupID != "" {
+		memberGroupID, err := uuid.Parse(req.GroupID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "invalid group_id param")
+		}
+		err = h.svc.RemoveNestedGroup(ctx, user.ID, id, memberGroupID)
+	} else {
+		err = h.svc.RemoveGroupMember(ctx, user.ID, id, req.UserID)
+	}
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error removing group member")
+	}
+	return c.JSON(http.StatusOK, "group member removed")
+}
+
+func (h *Handler) ListGroupMembers(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	members, err := h.svc.ListGroupMembers(ctx, user.ID, id)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error listing group members")
+	}
+	return c.JSON(http.StatusOK, members)
+}
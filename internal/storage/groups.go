@@ -0,0 +1,528 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// userGroupsCTE resolves the groups a user is in, directly or through nested
+// groups. Its only argument is the user ID.
+const userGroupsCTE = `user_groups AS (
+	SELECT group_id AS id FROM group_memberships WHERE user_id = ?
+
+	UNION
+
+	SELECT n.group_id FROM nested_groups n JOIN user_groups g ON n.member_group_id = g.id
+)`
+
+// Resources that can be shared with the members of a group
+var groupGrantTypes = []string{
+	authorization.ResourceNode,
+	authorization.ResourceWorkspace,
+	authorization.ResourceGroup,
+}
+
+func (role GroupRole) assignable() bool {
+	return role == GroupRoleAdmin || role == GroupRoleMember
+}
+
+// groupMembers is the subject set standing for everyone in a group.
+func groupMembers(ResourceType, ResourceID, Relation string, GroupID uuid.UUID) authorization.Relationship {
+	return authorization.Relationship{
+		ResourceType:    ResourceType,
+		ResourceID:      ResourceID,
+		Relation:        Relation,
+		SubjectType:     authorization.ResourceGroup,
+		SubjectID:       GroupID.String(),
+		SubjectRelation: authorization.RelationMember,
+	}
+}
+
+func groupUserRelationship(GroupID uuid.UUID, UserID uint64, Relation string) authorization.Relationship {
+	return authorization.Relationship{
+		ResourceType: authorization.ResourceGroup,
+		ResourceID:   GroupID.String(),
+		Relation:     Relation,
+		SubjectType:  authorization.SubjectUser,
+		SubjectID:    userSubject(UserID),
+	}
+}
+
+func (svc *Service) checkGroupPermission(
+	ctx context.Context,
+	group *Group,
+	UserID uint64,
+	Permission string,
+) error {
+	zedToken, err := svc.groupZedToken(ctx, group.ID, UserID)
+	if err != nil {
+		return err
+	}
+	allowed, err := svc.Authz.CheckPermOnResource(
+		ctx,
+		authorization.SubjectUser, userSubject(UserID),
+		authorization.ResourceGroup, group.ID.String(),
+		Permission,
+		zedToken != "",
+		zedToken,
+	)
+	if err != nil {
+		return err
+	} else if !allowed {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// getGroup loads a group the user holds Permission on.
+func (svc *Service) getGroup(
+	ctx context.Context,
+	GroupID uuid.UUID,
+	UserID uint64,
+	Permission string,
+) (*Group, error) {
+	var group Group
+	if err := svc.DB.WithContext(ctx).Where("id = ?", GroupID).First(&group).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrGroupNotFound
+		}
+		return nil, err
+	}
+	if err := svc.checkGroupPermission(ctx, &group, UserID, Permission); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// storeGroupToken remembers the token of the last write on a group's
+// members, so checks right after see them.
+func storeGroupToken(tx *gorm.DB, GroupID uuid.UUID, token string) error {
+	return tx.Model(&Group{}).Where("id = ?", GroupID).Updates(zedTokenUpdate(token)).Error
+}
+
+func (svc *Service) CreateGroup(
+	ctx context.Context,
+	UserID uint64,
+	Name string,
+) (*Group, error) {
+	if err := validateNodeName(Name); err != nil {
+		return nil, err
+	}
+
+	group := Group{
+		ID:        uuid.New(),
+		Name:      Name,
+		OwnerID:   UserID,
+		CreatedAt: time.Now(),
+	}
+	err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+		err := tx.Create(&GroupMembership{
+			GroupID:   group.ID,
+			UserID:    UserID,
+			Role:      GroupRoleOwner,
+			AddedBy:   UserID,
+			CreatedAt: group.CreatedAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		// The owner is a member too, so what is shared with the group reaches them
+		token, err := svc.Authz.WriteRelationships(ctx, []authorization.Relationship{
+			groupUserRelationship(group.ID, UserID, authorization.RelationOwner),
+			groupUserRelationship(group.ID, UserID, authorization.RelationMember),
+		}, nil)
+		if err != nil {
+			return err
+		}
+		group.ZedToken = &token
+		return storeGroupToken(tx, group.ID, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// ListGroups returns the groups the user is a direct member of.
+func (svc *Service) ListGroups(
+	ctx context.Context,
+	UserID uint64,
+) ([]GroupWithRole, error) {
+	groups := []GroupWithRole{}
+	err := svc.DB.WithContext(ctx).
+		Table("groups").
+		Select("groups.*, group_memberships.role").
+		Joins("JOIN group_memberships ON group_memberships.group_id = groups.id").
+		Where("group_memberships.user_id = ?", UserID).
+		Order("groups.name").
+		Scan(&groups).Error
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// DeleteGroup deletes a group, taking back everything shared with it. Only
+// the owner can do that.
+func (svc *Service) DeleteGroup(
+	ctx context.Context,
+	UserID uint64,
+	GroupID uuid.UUID,
+) error {
+	group, err := svc.getGroup(ctx, GroupID, UserID, authorization.PermissionRead)
+	if err != nil {
+		return err
+	}
+	if group.OwnerID != UserID {
+		return ErrUnauthorized
+	}
+
+	// Former members no longer reach the group, the write taking their
+	// access away goes on them instead
+	var memberIDs []uint64
+	var memberGroupIDs []uuid.UUID
+	err = svc.DB.WithContext(ctx).
+		Model(&GroupMembership{}).
+		Where("group_id = ?", group.ID).
+		Pluck("user_id", &memberIDs).Error
+	if err != nil {
+		return err
+	}
+	err = svc.DB.WithContext(ctx).
+		Model(&NestedGroup{}).
+		Where("group_id = ?", group.ID).
+		Pluck("member_group_id", &memberGroupIDs).Error
+	if err != nil {
+		return err
+	}
+
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{
+			&GroupMembership{},
+			&NodeGroupPermission{},
+			&WorkspaceGroupMembership{},
+		} {
+			if err := tx.Where("group_id = ?", group.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		err := tx.Where("group_id = ? OR member_group_id = ?", group.ID, group.ID).
+			Delete(&NestedGroup{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&Group{}, "id = ?", group.ID).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// The rows are gone, stale relationships only grant through a group nothing refers to
+	if _, err := svc.Authz.DeleteResourceRelationships(ctx, authorization.ResourceGroup, []string{group.ID.String()}); err != nil {
+		log.Printf("Failed to delete relationships of group %s: %v", group.ID, err)
+	}
+	token, err := svc.Authz.DeleteSubjectRelationships(ctx, groupGrantTypes, authorization.ResourceGroup, group.ID.String())
+	if err != nil {
+		log.Printf("Failed to delete grants to group %s: %v", group.ID, err)
+		return nil
+	}
+	db := svc.DB.WithContext(ctx)
+	if err := storeUserZedToken(db, token, memberIDs); err != nil {
+		log.Printf("Failed to store token for members of group %s: %v", group.ID, err)
+	}
+	for _, memberGroupID := range memberGroupIDs {
+		if err := storeGroupToken(db, memberGroupID, token); err != nil {
+			log.Printf("Failed to store token for members of group %s: %v", group.ID, err)
+		}
+	}
+	return nil
+}
+
+// AddGroupMember adds the user going by Member as username or email to a
+// group. Adding an existing member again changes their role.
+func (svc *Service) AddGroupMember(
+	ctx context.Context,
+	UserID uint64,
+	GroupID uuid.UUID,
+	Member string,
+	Role GroupRole,
+) (*GroupMember, error) {
+	if !Role.assignable() {
+		return nil, ErrGroupOwner
+	}
+	group, err := svc.getGroup(ctx, GroupID, UserID, authorization.PermissionManage)
+	if err != nil {
+		return nil, err
+	}
+
+	var user authentication.User
+	err = svc.DB.WithContext(ctx).
+		Where("username = ? OR email = ?", Member, Member).
+		First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if user.ID == group.OwnerID {
+		return nil, ErrGroupOwner
+	}
+
+	touch := []authorization.Relationship{
+		groupUserRelationship(group.ID, user.ID, authorization.RelationMember),
+	}
+	var remove []authorization.Relationship
+	if Role == GroupRoleAdmin {
+		touch = append(touch, groupUserRelationship(group.ID, user.ID, authorization.RelationAdmin))
+	} else {
+		remove = append(remove, groupUserRelationship(group.ID, user.ID, authorization.RelationAdmin))
+	}
+
+	membership := GroupMembership{
+		GroupID:   group.ID,
+		UserID:    user.ID,
+		Role:      Role,
+		AddedBy:   UserID,
+		CreatedAt: time.Now(),
+	}
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "group_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"role", "added_by"}),
+		}).Create(&membership).Error
+		if err != nil {
+			return err
+		}
+
+		token, err := svc.Authz.WriteRelationships(ctx, touch, remove)
+		if err != nil {
+			return err
+		}
+		return storeGroupToken(tx, group.ID, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &GroupMember{
+		UserID:   user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Role:     Role,
+		AddedAt:  membership.CreatedAt,
+	}, nil
+}
+
+// AddNestedGroup makes every member of MemberGroupID a member of GroupID.
+// Users can only nest groups they belong to.
+func (svc *Service) AddNestedGroup(
+	ctx context.Context,
+	UserID uint64,
+	GroupID uuid.UUID,
+	MemberGroupID uuid.UUID,
+) (*GroupMember, error) {
+	group, err := svc.getGroup(ctx, GroupID, UserID, authorization.PermissionManage)
+	if err != nil {
+		return nil, err
+	}
+	member, err := svc.getGroup(ctx, MemberGroupID, UserID, authorization.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+
+	// SpiceDB gives up on cycles, so a group can't end up inside itself
+	if member.ID == group.ID {
+		return nil, ErrGroupCycle
+	}
+	var cyclic bool
+	err = svc.DB.WithContext(ctx).
+		Raw(`
+		WITH RECURSIVE inside AS (
+		SELECT member_group_id AS id FROM nested_groups WHERE group_id = ?
+
+		UNION
+
+		SELECT n.member_group_id FROM nested_groups n JOIN
+		inside i ON n.group_id = i.id
+		)
+
+		SELECT EXISTS (SELECT 1 FROM inside WHERE id = ?);
+	`, member.ID, group.ID).
+		Scan(&cyclic).Error
+	if err != nil {
+		return nil, err
+	} else if cyclic {
+		return nil, ErrGroupCycle
+	}
+
+	nested := NestedGroup{
+		GroupID:       group.ID,
+		MemberGroupID: member.ID,
+		AddedBy:       UserID,
+		CreatedAt:     time.Now(),
+	}
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&nested).Error; err != nil {
+			return err
+		}
+		token, err := svc.Authz.WriteRelationship(
+			ctx,
+			authorization.ResourceGroup, group.ID.String(),
+			authorization.RelationMember,
+			authorization.ResourceGroup, member.ID.String(),
+			authorization.RelationMember,
+		)
+		if err != nil {
+			return err
+		}
+		return storeGroupToken(tx, group.ID, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &GroupMember{
+		GroupID:   &member.ID,
+		GroupName: member.Name,
+		Role:      GroupRoleMember,
+		AddedAt:   nested.CreatedAt,
+	}, nil
+}
+
+// RemoveGroupMember takes a user out of a group. Members can always leave,
+// removing someone else needs admin rights.
+func (svc *Service) RemoveGroupMember(
+	ctx context.Context,
+	UserID uint64,
+	GroupID uuid.UUID,
+	MemberID uint64,
+) error {
+	permission := authorization.PermissionManage
+	if MemberID == UserID {
+		permission = authorization.PermissionRead
+	}
+	group, err := svc.getGroup(ctx, GroupID, UserID, permission)
+	if err != nil {
+		return err
+	}
+	if MemberID == group.OwnerID {
+		return ErrGroupOwner
+	}
+
+	return svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("group_id = ? AND user_id = ?", group.ID, MemberID).
+			Delete(&GroupMembership{})
+		if result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return ErrAccessNotFound
+		}
+
+		token, err := svc.Authz.WriteRelationships(ctx, nil, []authorization.Relationship{
+			groupUserRelationship(group.ID, MemberID, authorization.RelationAdmin),
+			groupUserRelationship(group.ID, MemberID, authorization.RelationMember),
+		})
+		if err != nil {
+			return err
+		}
+		if err := storeUserZedToken(tx, token, []uint64{MemberID}); err != nil {
+			return err
+		}
+		return storeGroupToken(tx, group.ID, token)
+	})
+}
+
+func (svc *Service) RemoveNestedGroup(
+	ctx context.Context,
+	UserID uint64,
+	GroupID uuid.UUID,
+	MemberGroupID uuid.UUID,
+) error {
+	group, err := svc.getGroup(ctx, GroupID, UserID, authorization.PermissionManage)
+	if err != nil {
+		return err
+	}
+
+	return svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("group_id = ? AND member_group_id = ?", group.ID, MemberGroupID).
+			Delete(&NestedGroup{})
+		if result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return ErrAccessNotFound
+		}
+
+		token, err := svc.Authz.WriteRelationships(ctx, nil, []authorization.Relationship{
+			groupMembers(authorization.ResourceGroup, group.ID.String(), authorization.RelationMember, MemberGroupID),
+		})
+		if err != nil {
+			return err
+		}
+		// Members of the nested group no longer reach this one, their
+		// checks find the write through the group they are still in
+		if err := storeGroupToken(tx, MemberGroupID, token); err != nil {
+			return err
+		}
+		return storeGroupToken(tx, group.ID, token)
+	})
+}
+
+// ListGroupMembers returns the users of a group followed by the groups
+// nested in it.
+func (svc *Service) ListGroupMembers(
+	ctx context.Context,
+	UserID uint64,
+	GroupID uuid.UUID,
+) ([]GroupMember, error) {
+	group, err := svc.getGroup(ctx, GroupID, UserID, authorization.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+
+	db := svc.DB.WithContext(ctx)
+	members := []GroupMember{}
+	err = db.Table("group_memberships").
+		Select("users.id AS user_id, users.username, users.email, group_memberships.role, group_memberships.created_at AS added_at").
+		Joins("JOIN users ON users.id = group_memberships.user_id").
+		Where("group_memberships.group_id = ?", group.ID).
+		Order("group_memberships.created_at").
+		Scan(&members).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var nested []struct {
+		ID        uuid.UUID
+		Name      string
+		CreatedAt time.Time
+	}
+	err = db.Table("nested_groups").
+		Select("groups.id, groups.name, nested_groups.created_at").
+		Joins("JOIN groups ON groups.id = nested_groups.member_group_id").
+		Where("nested_groups.group_id = ?", group.ID).
+		Order("nested_groups.created_at").
+		Scan(&nested).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range nested {
+		members = append(members, GroupMember{
+			GroupID:   &nested[i].ID,
+			GroupName: nested[i].Name,
+			Role:      GroupRoleMember,
+			AddedAt:   nested[i].CreatedAt,
+		})
+	}
+	return members, nil
+}
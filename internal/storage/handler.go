@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,6 +10,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+	"github.com/sirkartik/cloud_drive_2.0/internal/shared"
+	"gorm.io/gorm"
 )
 
 func NewHandler(svc StorageService) *Handler {
@@ -17,6 +20,60 @@ func NewHandler(svc StorageService) *Handler {
 	}
 }
 
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound),
+		errors.Is(err, ErrNodeNotFound),
+		errors.Is(err, ErrParentNodeNotFound),
+		errors.Is(err, ErrUploadNotFound),
+		errors.Is(err, ErrJobNotFound),
+		errors.Is(err, ErrTrashItemNotFound),
+		errors.Is(err, ErrVersionNotFound),
+		errors.Is(err, ErrShareLinkNotFound),
+		errors.Is(err, ErrFileRequestNotFound),
+		errors.Is(err, ErrUserNotFound),
+		errors.Is(err, ErrAccessNotFound),
+		errors.Is(err, ErrWorkspaceNotFound),
+		errors.Is(err, ErrGroupNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusForbidden
+	case errors.Is(err, ErrSharePasswordInvalid):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrShareLinkExpired),
+		errors.Is(err, ErrShareDownloadLimit),
+		errors.Is(err, ErrFileRequestExpired),
+		errors.Is(err, ErrUploadExpired):
+		return http.StatusGone
+	case errors.Is(err, ErrNodeIsDirectory),
+		errors.Is(err, ErrNodeIsFile),
+		errors.Is(err, ErrInvalidName),
+		errors.Is(err, ErrUnsupportedArchive),
+		errors.Is(err, ErrOwnerAccess),
+		errors.Is(err, ErrInvalidCursor),
+		errors.Is(err, ErrWorkspaceRoot),
+		errors.Is(err, ErrWorkspaceOwner),
+		errors.Is(err, ErrGroupOwner),
+		errors.Is(err, ErrGroupCycle):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrArchiveTooLarge),
+		errors.Is(err, ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrFileTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrUploadOffsetMismatch),
+		errors.Is(err, ErrUploadIncomplete),
+		errors.Is(err, ErrChecksumMismatch),
+		errors.Is(err, ErrNameConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrQuotaExceeded),
+		errors.Is(err, ErrWorkspaceQuota):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (h *Handler) Download(c echo.Context) error {
 	var req DLoad
 	ctx := c.Request().Context()
@@ -70,19 +127,117 @@ func (h *Handler) Upload(c echo.Context) error {
 	if err != nil {
 		parentId = uuid.Nil
 	}
+	strategy, err := ParseConflictStrategy(c.Request().Header.Get("conflict"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
 	mimeType, newStream, err := h.svc.DetectMimeType(ctx, file)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, "invalid file stream")
 	}
-	_, err = h.svc.Put(ctx, user.ID, parentId, filename, uint64(size), newStream, mimeType)
+	_, err = h.svc.Put(ctx, user.ID, parentId, filename, uint64(size), newStream, mimeType, strategy)
 
 	if err != nil {
 		log.Println(err)
-		return c.JSON(http.StatusInternalServerError, "error writing file to the storage")
+		return c.JSON(errorStatus(err), "error writing file to the storage")
 	}
 	return c.NoContent(http.StatusCreated)
 }
 
+// UploadArchive extracts an uploaded ZIP or tar(.gz) into the parent
+// directory and reports the outcome of every entry.
+func (h *Handler) UploadArchive(c echo.Context) error {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		log.Println(err.Error())
+		return c.JSON(http.StatusBadRequest, "missing file")
+	}
+	ctx := c.Request().Context()
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, "cannot open file")
+	}
+	defer file.Close()
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	parentId, err := uuid.Parse(c.Request().Header.Get("parent_id"))
+	if err != nil {
+		parentId = uuid.Nil
+	}
+	strategy, err := ParseConflictStrategy(c.Request().Header.Get("conflict"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	summary, err := ExtractArchive(ctx, h.svc, user.ID, parentId, fileHeader.Filename, file, fileHeader.Size, strategy)
+	if err != nil {
+		log.Println(err)
+		if summary == nil {
+			return c.JSON(errorStatus(err), "error extracting archive")
+		}
+		return c.JSON(errorStatus(err), map[string]interface{}{
+			"error":   err.Error(),
+			"summary": summary,
+		})
+	}
+	return c.JSON(http.StatusCreated, summary)
+}
+
+func (h *Handler) InitiateUpload(c echo.Context) error {
+	var req InitiateUpload
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	if req.Name == "" {
+		return c.JSON(http.StatusBadRequest, "missing file name")
+	}
+	if req.Parts == 0 {
+		req.Parts = 1
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+	parentId, _ := uuid.Parse(req.ParentID)
+
+	ticket, err := h.svc.InitiateUpload(ctx, user.ID, parentId, req.Name, req.Size, req.Checksum, req.Parts)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error initiating upload")
+	}
+	return c.JSON(http.StatusCreated, ticket)
+}
+
+func (h *Handler) CompleteUpload(c echo.Context) error {
+	var req CompleteUpload
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	nodeId, err := uuid.Parse(req.NodeID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	parts := make([]shared.CompletedPart, 0, len(req.Parts))
+	for _, part := range req.Parts {
+		parts = append(parts, shared.CompletedPart{
+			PartNumber: part.PartNumber,
+			ETag:       part.ETag,
+		})
+	}
+
+	node, err := h.svc.CompleteUpload(ctx, nodeId, user.ID, parts)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error completing upload")
+	}
+	return c.JSON(http.StatusCreated, node)
+}
+
 func (h *Handler) List(c echo.Context) error {
 	var req ListNodes
 	ctx := c.Request().Context()
@@ -114,11 +269,15 @@ func (h *Handler) CreateDirectoryNode(c echo.Context) error {
 	}
 	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
 	parentId, _ := uuid.Parse(req.ParentID)
+	strategy, err := ParseConflictStrategy(req.Conflict)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
 
-	err := h.svc.CreateDirectoryNode(ctx, req.Name, parentId, user.ID)
+	_, err = h.svc.CreateDirectoryNode(ctx, req.Name, parentId, user.ID, strategy)
 	if err != nil {
 		log.Println(err.Error())
-		return c.JSON(http.StatusInternalServerError, "error creating directory node")
+		return c.JSON(errorStatus(err), "error creating directory node")
 	}
 	return c.JSON(http.StatusCreated, "directory created")
 }
@@ -135,15 +294,39 @@ func (h *Handler) Copy(
 	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
 	targetNodeId, _ := uuid.Parse(req.TargetNodeID)
 	destParentId, _ := uuid.Parse(req.DestParentID)
+	strategy, err := ParseConflictStrategy(req.Conflict)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
 
-	err := h.svc.Copy(ctx, targetNodeId, destParentId, user.ID)
+	job, err := h.svc.Copy(ctx, targetNodeId, destParentId, user.ID, strategy)
 	if err != nil {
 		log.Println(err.Error())
-		return c.JSON(http.StatusInternalServerError, "error performing copy operation")
+		return c.JSON(errorStatus(err), "error performing copy operation")
+	}
+	if job != nil {
+		return c.JSON(http.StatusAccepted, job)
 	}
 	return c.JSON(http.StatusAccepted, "copy successful")
 }
 
+func (h *Handler) CopyStatus(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	jobId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	job, err := h.svc.GetCopyJob(ctx, jobId, user.ID)
+	if err != nil {
+		log.Println(err.Error())
+		return c.JSON(errorStatus(err), "error fetching copy job")
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
 func (h *Handler) Move(
 	c echo.Context,
 ) error {
@@ -156,15 +339,46 @@ func (h *Handler) Move(
 	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
 	targetNodeId, _ := uuid.Parse(req.TargetNodeID)
 	destParentId, _ := uuid.Parse(req.DestParentID)
+	strategy, err := ParseConflictStrategy(req.Conflict)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
 
-	err := h.svc.Move(ctx, targetNodeId, destParentId, user.ID)
+	err = h.svc.Move(ctx, targetNodeId, destParentId, user.ID, strategy)
 	if err != nil {
 		log.Println(err.Error())
-		return c.JSON(http.StatusInternalServerError, "error performing move operation")
+		return c.JSON(errorStatus(err), "error performing move operation")
 	}
 	return c.JSON(http.StatusAccepted, "move successful")
 }
 
+func (h *Handler) Rename(
+	c echo.Context,
+) error {
+	var req Rename
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+	nodeId, err := uuid.Parse(req.NodeID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	strategy, err := ParseConflictStrategy(req.Conflict)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	node, err := h.svc.Rename(ctx, nodeId, req.Name, user.ID, strategy)
+	if err != nil {
+		log.Println(err.Error())
+		return c.JSON(errorStatus(err), "error renaming node")
+	}
+	return c.JSON(http.StatusAccepted, node)
+}
+
 func (h *Handler) Delete(
 	c echo.Context,
 ) error {
@@ -181,10 +395,44 @@ func (h *Handler) Delete(
 
 	if err != nil {
 		log.Println(err.Error())
-		return c.JSON(http.StatusInternalServerError, "error deleting selected node")
+		return c.JSON(errorStatus(err), "error deleting selected node")
 	}
 
-	return c.JSON(http.StatusAccepted, "deletion successful")
+	return c.JSON(http.StatusAccepted, "moved to trash")
+}
+
+func (h *Handler) Usage(
+	c echo.Context,
+) error {
+	ctx := c.Request().Context()
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	usage, err := h.svc.GetUsage(ctx, user.ID)
+	if err != nil {
+		log.Println(err.Error())
+		return c.JSON(errorStatus(err), "error fetching storage usage")
+	}
+	return c.JSON(http.StatusOK, usage)
+}
+
+func (h *Handler) SetQuota(
+	c echo.Context,
+) error {
+	var req SetQuota
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	if req.QuotaBytes != nil && *req.QuotaBytes < 0 {
+		return c.JSON(http.StatusBadRequest, "quota can't be negative")
+	}
+
+	if err := h.svc.SetQuota(ctx, req.UserID, req.QuotaBytes); err != nil {
+		log.Println(err.Error())
+		return c.JSON(errorStatus(err), "error setting quota")
+	}
+	return c.JSON(http.StatusAccepted, "quota updated")
 }
 
 func (h *Handler) GeneratePostUploadPolicy(
@@ -1,9 +1,16 @@
 package storage
 
+import "time"
+
 type DLoad struct {
 	NodeID string `json:"id"`
 }
 
+type DownloadArchive struct {
+	NodeIDs []string `json:"ids"`
+	Format  string   `json:"format"` // zip (default) or tar.gz
+}
+
 type ListNodes struct {
 	ParentID string `json:"parent_id"`
 }
@@ -11,14 +18,205 @@ type ListNodes struct {
 type Mkdir struct {
 	Name     string `json:"name"`
 	ParentID string `json:"parent_id"`
+	Conflict string `json:"conflict"`
 }
 
 type Move struct {
 	TargetNodeID string `json:"target_id"`
 	DestParentID string `json:"dest_parent_id"`
+	Conflict     string `json:"conflict"`
+}
+
+type Rename struct {
+	NodeID   string `json:"id"`
+	Name     string `json:"name"`
+	Conflict string `json:"conflict"`
 }
 
 type Delete struct {
 	NodeID   string `json:"id"`
 	ParentID string `json:"parent_id"`
 }
+
+type RestoreVersion struct {
+	NodeID    string `json:"id"`
+	VersionID string `json:"version_id"`
+}
+
+type RestoreTrash struct {
+	ID       string `json:"id"`
+	ParentID string `json:"parent_id"`
+	Conflict string `json:"conflict"`
+}
+
+type PurgeTrash struct {
+	ID string `json:"id"`
+}
+
+type InitiateUpload struct {
+	Name     string `json:"name"`
+	ParentID string `json:"parent_id"`
+	Size     uint64 `json:"size"`
+	Checksum string `json:"checksum"`
+	Parts    int    `json:"parts"`
+}
+
+type UploadedPart struct {
+	PartNumber int    `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+type CompleteUpload struct {
+	NodeID string         `json:"id"`
+	Parts  []UploadedPart `json:"parts"`
+}
+
+type SetQuota struct {
+	UserID     uint64 `json:"user_id"`
+	QuotaBytes *int64 `json:"quota_bytes"` // null restores the default quota
+}
+
+type Search struct {
+	Query         string  `query:"q"`
+	Content       string  `query:"content"`
+	Type          string  `query:"type"`
+	MimeType      string  `query:"mime"`
+	MinSize       *uint64 `query:"min_size"`
+	MaxSize       *uint64 `query:"max_size"`
+	CreatedAfter  string  `query:"created_after"`  // RFC 3339
+	CreatedBefore string  `query:"created_before"` // RFC 3339
+	OwnerID       *uint64 `query:"owner"`
+	FolderID      string  `query:"folder"`
+	Limit         int     `query:"limit"`
+	Offset        int     `query:"offset"`
+}
+
+type CreateShareLink struct {
+	NodeID       string     `json:"id"`
+	Access       string     `json:"access"` // read (default) or upload
+	Password     string     `json:"password"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	MaxDownloads *int64     `json:"max_downloads"`
+}
+
+type RevokeShareLink struct {
+	ID string `json:"id"`
+}
+
+type CreateFileRequest struct {
+	DirectoryID  string     `json:"id"`
+	Title        string     `json:"title"`
+	Message      string     `json:"message"`
+	MaxFileBytes *uint64    `json:"max_file_bytes"`
+	AllowedTypes []string   `json:"allowed_types"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+}
+
+type CloseFileRequest struct {
+	ID string `json:"id"`
+}
+
+type GrantAccess struct {
+	NodeID string `json:"id"`
+	User   string `json:"user"` // Username or email
+	Role   string `json:"role"`
+}
+
+type UpdateAccess struct {
+	NodeID string `json:"id"`
+	UserID uint64 `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+type RevokeAccess struct {
+	NodeID string `json:"id"`
+	UserID uint64 `json:"user_id"`
+}
+
+type ListSharedWithMe struct {
+	Cursor string `query:"cursor"`
+	Limit  int    `query:"limit"`
+}
+
+type CreateWorkspace struct {
+	Name string `json:"name"`
+}
+
+type RenameWorkspace struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type DeleteWorkspace struct {
+	ID string `json:"id"`
+}
+
+type AddWorkspaceMember struct {
+	ID   string `json:"id"`
+	User string `json:"user"` // Username or email
+	Role string `json:"role"`
+}
+
+type RemoveWorkspaceMember struct {
+	ID     string `json:"id"`
+	UserID uint64 `json:"user_id"`
+}
+
+type SetWorkspaceQuota struct {
+	ID         string `json:"id"`
+	QuotaBytes *int64 `json:"quota_bytes"`
+}
+
+type GrantGroupAccess struct {
+	NodeID  string `json:"id"`
+	GroupID string `json:"group_id"`
+	Role    string `json:"role"`
+}
+
+type RevokeGroupAccess struct {
+	NodeID  string `json:"id"`
+	GroupID string `json:"group_id"`
+}
+
+type AddWorkspaceGroup struct {
+	ID      string `json:"id"`
+	GroupID string `json:"group_id"`
+	Role    string `json:"role"`
+}
+
+type RemoveWorkspaceGroup struct {
+	ID      string `json:"id"`
+	GroupID string `json:"group_id"`
+}
+
+type CreateGroup struct {
+	Name string `json:"name"`
+}
+
+type DeleteGroup struct {
+	ID string `json:"id"`
+}
+
+// AddGroupMember adds either a user or, with GroupID, a nested group
+type AddGroupMember struct {
+	ID      string `json:"id"`
+	User    string `json:"user"` // Username or email
+	GroupID string `json:"group_id"`
+	Role    string `json:"role"`
+}
+
+type RemoveGroupMember struct {
+	ID      string `json:"id"`
+	UserID  uint64 `json:"user_id"`
+	GroupID string `json:"group_id"`
+}
+
+type SetVisibility struct {
+	NodeID     string `json:"id"`
+	Visibility string `json:"visibility"` // private, link or public
+}
+
+type ListPublicNodes struct {
+	Offset int `query:"offset"`
+	Limit  int `query:"limit"`
+}
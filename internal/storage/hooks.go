@@ -7,12 +7,14 @@ import (
 	"net/url"
 
 	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/shared"
 )
 
 func NewHookLayer(storageSvc StorageService) *HookLayer {
 	return &HookLayer{
-		storageSvc:    storageSvc,
-		putHooksAfter: []PutHook{},
+		storageSvc:       storageSvc,
+		putHooksAfter:    []PutHook{},
+		fileRequestHooks: []FileRequestHook{},
 	}
 }
 
@@ -20,6 +22,10 @@ func (h *HookLayer) RegisterAfterPutHook(hook PutHook) {
 	h.putHooksAfter = append(h.putHooksAfter, hook)
 }
 
+func (h *HookLayer) RegisterFileRequestHook(hook FileRequestHook) {
+	h.fileRequestHooks = append(h.fileRequestHooks, hook)
+}
+
 func (h *HookLayer) Put(
 	ctx context.Context,
 	UserID uint64,
@@ -28,25 +34,33 @@ func (h *HookLayer) Put(
 	Bytes uint64,
 	data io.ReadCloser,
 	mimeType string,
+	Strategy ConflictStrategy,
 ) (*Node, error) {
-	node, err := h.storageSvc.Put(ctx, UserID, ParentID, Name, Bytes, data, mimeType)
+	node, err := h.storageSvc.Put(ctx, UserID, ParentID, Name, Bytes, data, mimeType, Strategy)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, hook := range h.putHooksAfter {
-		var parentId uuid.UUID
-		if node.ParentID != nil {
-			parentId = *node.ParentID
-		}
+	h.runAfterPutHooks(ctx, UserID, node)
+	return node, nil
+}
 
-		err := hook(ctx, UserID, parentId, Name, mimeType, node.ID, *node.Key, Bytes)
+func (h *HookLayer) runAfterPutHooks(ctx context.Context, UserID uint64, node *Node) {
+	if node.Key == nil || node.MimeType == nil || node.SizeBytes == nil {
+		return
+	}
+
+	var parentId uuid.UUID
+	if node.ParentID != nil {
+		parentId = *node.ParentID
+	}
+
+	for _, hook := range h.putHooksAfter {
+		err := hook(ctx, UserID, parentId, node.Name, *node.MimeType, node.ID, *node.Key, *node.SizeBytes)
 		if err != nil {
 			log.Println("AfterPut hook error : ", err)
 		}
 	}
-
-	return node, nil
 }
 
 func (h *HookLayer) GetNode(ctx context.Context, ID uuid.UUID) (*Node, error) {
@@ -61,6 +75,14 @@ func (h *HookLayer) GetData(ctx context.Context, NodeID uuid.UUID, UserID uint64
 	return h.storageSvc.GetData(ctx, NodeID, UserID)
 }
 
+func (h *HookLayer) StatData(ctx context.Context, NodeID uuid.UUID, UserID uint64) (*Node, *shared.ObjectInfo, error) {
+	return h.storageSvc.StatData(ctx, NodeID, UserID)
+}
+
+func (h *HookLayer) GetDataRange(ctx context.Context, NodeID uuid.UUID, UserID uint64, Offset int64, Length int64) (io.ReadCloser, *Node, error) {
+	return h.storageSvc.GetDataRange(ctx, NodeID, UserID, Offset, Length)
+}
+
 func (h *HookLayer) GeneratePresignedGetURL(ctx context.Context, key string) (*url.URL, error) {
 	return h.storageSvc.GeneratePresignedGetURL(ctx, key)
 }
@@ -81,20 +103,308 @@ func (h *HookLayer) PutHLS(ctx context.Context, HLSDirPath, ParentKey string) er
 	return h.storageSvc.PutHLS(ctx, HLSDirPath, ParentKey)
 }
 
-func (h *HookLayer) CreateDirectoryNode(ctx context.Context, Name string, ParentNodeID uuid.UUID, OwnerID uint64) error {
-	return h.storageSvc.CreateDirectoryNode(ctx, Name, ParentNodeID, OwnerID)
+func (h *HookLayer) CreateDirectoryNode(ctx context.Context, Name string, ParentNodeID uuid.UUID, OwnerID uint64, Strategy ConflictStrategy) (*Node, error) {
+	return h.storageSvc.CreateDirectoryNode(ctx, Name, ParentNodeID, OwnerID, Strategy)
+}
+
+func (h *HookLayer) Copy(ctx context.Context, TargetNodeID uuid.UUID, DestinationID uuid.UUID, OwnerID uint64, Strategy ConflictStrategy) (*CopyJob, error) {
+	return h.storageSvc.Copy(ctx, TargetNodeID, DestinationID, OwnerID, Strategy)
+}
+
+func (h *HookLayer) GetCopyJob(ctx context.Context, JobID uuid.UUID, UserID uint64) (*CopyJob, error) {
+	return h.storageSvc.GetCopyJob(ctx, JobID, UserID)
+}
+
+func (h *HookLayer) Move(ctx context.Context, TargetNodeID uuid.UUID, DestinationParentID uuid.UUID, OwnerID uint64, Strategy ConflictStrategy) error {
+	return h.storageSvc.Move(ctx, TargetNodeID, DestinationParentID, OwnerID, Strategy)
+}
+
+func (h *HookLayer) Rename(ctx context.Context, NodeID uuid.UUID, Name string, UserID uint64, Strategy ConflictStrategy) (*Node, error) {
+	return h.storageSvc.Rename(ctx, NodeID, Name, UserID, Strategy)
+}
+
+func (h *HookLayer) ListVersions(ctx context.Context, NodeID uuid.UUID, UserID uint64) ([]FileVersion, error) {
+	return h.storageSvc.ListVersions(ctx, NodeID, UserID)
+}
+
+func (h *HookLayer) GetVersionData(ctx context.Context, NodeID uuid.UUID, VersionID uuid.UUID, UserID uint64) (io.ReadCloser, *FileVersion, *Node, error) {
+	return h.storageSvc.GetVersionData(ctx, NodeID, VersionID, UserID)
+}
+
+func (h *HookLayer) PutVersion(ctx context.Context, NodeID uuid.UUID, UserID uint64, Bytes uint64, data io.ReadCloser, mimeType string) (*Node, error) {
+	node, err := h.storageSvc.PutVersion(ctx, NodeID, UserID, Bytes, data, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	h.runAfterPutHooks(ctx, UserID, node)
+	return node, nil
+}
+
+func (h *HookLayer) RestoreVersion(ctx context.Context, NodeID uuid.UUID, VersionID uuid.UUID, UserID uint64) (*Node, error) {
+	node, err := h.storageSvc.RestoreVersion(ctx, NodeID, VersionID, UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The restored content replaces the current one like a new version
+	h.runAfterPutHooks(ctx, UserID, node)
+	return node, nil
+}
+
+func (h *HookLayer) PrepareArchive(ctx context.Context, NodeIDs []uuid.UUID, UserID uint64) (*Archive, error) {
+	return h.storageSvc.PrepareArchive(ctx, NodeIDs, UserID)
+}
+
+func (h *HookLayer) WriteArchive(ctx context.Context, archive *Archive, Format ArchiveFormat, w io.Writer) error {
+	return h.storageSvc.WriteArchive(ctx, archive, Format, w)
+}
+
+func (h *HookLayer) Search(ctx context.Context, UserID uint64, Query SearchQuery) (*SearchResults, error) {
+	return h.storageSvc.Search(ctx, UserID, Query)
+}
+
+func (h *HookLayer) CreateShareLink(ctx context.Context, UserID uint64, NodeID uuid.UUID, Options ShareLinkOptions) (*ShareLink, error) {
+	return h.storageSvc.CreateShareLink(ctx, UserID, NodeID, Options)
+}
+
+func (h *HookLayer) ListShareLinks(ctx context.Context, UserID uint64, NodeID uuid.UUID) ([]ShareLink, error) {
+	return h.storageSvc.ListShareLinks(ctx, UserID, NodeID)
+}
+
+func (h *HookLayer) RevokeShareLink(ctx context.Context, LinkID uuid.UUID, UserID uint64) error {
+	return h.storageSvc.RevokeShareLink(ctx, LinkID, UserID)
+}
+
+func (h *HookLayer) OpenShareLink(ctx context.Context, Token string, Password string) (*ShareLink, *Node, error) {
+	return h.storageSvc.OpenShareLink(ctx, Token, Password)
+}
+
+func (h *HookLayer) GetSharedNode(ctx context.Context, Link *ShareLink, NodeID uuid.UUID) (*Node, error) {
+	return h.storageSvc.GetSharedNode(ctx, Link, NodeID)
+}
+
+func (h *HookLayer) ListSharedNodes(ctx context.Context, Link *ShareLink, NodeID uuid.UUID) ([]Node, error) {
+	return h.storageSvc.ListSharedNodes(ctx, Link, NodeID)
+}
+
+func (h *HookLayer) RecordShareDownload(ctx context.Context, Link *ShareLink) error {
+	return h.storageSvc.RecordShareDownload(ctx, Link)
+}
+
+func (h *HookLayer) CreateFileRequest(ctx context.Context, UserID uint64, DirectoryID uuid.UUID, Options FileRequestOptions) (*FileRequest, error) {
+	return h.storageSvc.CreateFileRequest(ctx, UserID, DirectoryID, Options)
+}
+
+func (h *HookLayer) ListFileRequests(ctx context.Context, UserID uint64) ([]FileRequest, error) {
+	return h.storageSvc.ListFileRequests(ctx, UserID)
+}
+
+func (h *HookLayer) ListFileRequestUploads(ctx context.Context, RequestID uuid.UUID, UserID uint64) ([]FileRequestUpload, error) {
+	return h.storageSvc.ListFileRequestUploads(ctx, RequestID, UserID)
+}
+
+func (h *HookLayer) CloseFileRequest(ctx context.Context, RequestID uuid.UUID, UserID uint64) error {
+	return h.storageSvc.CloseFileRequest(ctx, RequestID, UserID)
+}
+
+func (h *HookLayer) OpenFileRequest(ctx context.Context, Token string) (*FileRequest, error) {
+	return h.storageSvc.OpenFileRequest(ctx, Token)
+}
+
+func (h *HookLayer) RecordFileRequestUpload(ctx context.Context, Request *FileRequest, node *Node, UploaderName string) (*FileRequestUpload, error) {
+	upload, err := h.storageSvc.RecordFileRequestUpload(ctx, Request, node, UploaderName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hook := range h.fileRequestHooks {
+		if err := hook(ctx, Request, upload); err != nil {
+			log.Println("FileRequest hook error : ", err)
+		}
+	}
+	return upload, nil
+}
+
+func (h *HookLayer) GetUsage(ctx context.Context, UserID uint64) (*Usage, error) {
+	return h.storageSvc.GetUsage(ctx, UserID)
 }
 
-func (h *HookLayer) Copy(ctx context.Context, TargetNodeID uuid.UUID, DestinationID uuid.UUID, OwnerID uint64) error {
-	return h.storageSvc.Copy(ctx, TargetNodeID, DestinationID, OwnerID)
+func (h *HookLayer) SetQuota(ctx context.Context, UserID uint64, QuotaBytes *int64) error {
+	return h.storageSvc.SetQuota(ctx, UserID, QuotaBytes)
 }
 
-func (h *HookLayer) Move(ctx context.Context, TargetNodeID uuid.UUID, DestinationParentID uuid.UUID, OwnerID uint64) error {
-	return h.storageSvc.Move(ctx, TargetNodeID, DestinationParentID, OwnerID)
+func (h *HookLayer) ListTrash(ctx context.Context, UserID uint64) ([]TrashItem, error) {
+	return h.storageSvc.ListTrash(ctx, UserID)
+}
+
+func (h *HookLayer) RestoreTrash(ctx context.Context, TrashID uuid.UUID, UserID uint64, ParentID uuid.UUID, Strategy ConflictStrategy) (*Node, error) {
+	return h.storageSvc.RestoreTrash(ctx, TrashID, UserID, ParentID, Strategy)
+}
+
+func (h *HookLayer) PurgeTrash(ctx context.Context, TrashID uuid.UUID, UserID uint64) error {
+	return h.storageSvc.PurgeTrash(ctx, TrashID, UserID)
+}
+
+func (h *HookLayer) EmptyTrash(ctx context.Context, UserID uint64) error {
+	return h.storageSvc.EmptyTrash(ctx, UserID)
 }
 
 func (h *HookLayer) GeneratePostUploadPolicy(ctx context.Context) (*UploadPolicy, error) {
 	return h.storageSvc.GeneratePostUploadPolicy(ctx)
 }
 
+func (h *HookLayer) CreateUpload(ctx context.Context, UserID uint64, ParentID uuid.UUID, Name string, Length uint64) (*Upload, error) {
+	return h.storageSvc.CreateUpload(ctx, UserID, ParentID, Name, Length)
+}
+
+func (h *HookLayer) GetUpload(ctx context.Context, UploadID uuid.UUID, UserID uint64) (*Upload, error) {
+	return h.storageSvc.GetUpload(ctx, UploadID, UserID)
+}
+
+func (h *HookLayer) WriteUpload(ctx context.Context, UploadID uuid.UUID, UserID uint64, Offset uint64, data io.Reader) (*Upload, *Node, error) {
+	upload, node, err := h.storageSvc.WriteUpload(ctx, UploadID, UserID, Offset, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A finished resumable upload is a new file just like a regular Put
+	if node != nil {
+		h.runAfterPutHooks(ctx, UserID, node)
+	}
+	return upload, node, nil
+}
+
+func (h *HookLayer) TerminateUpload(ctx context.Context, UploadID uuid.UUID, UserID uint64) error {
+	return h.storageSvc.TerminateUpload(ctx, UploadID, UserID)
+}
+
+func (h *HookLayer) InitiateUpload(ctx context.Context, UserID uint64, ParentID uuid.UUID, Name string, Bytes uint64, Checksum string, Parts int) (*UploadTicket, error) {
+	return h.storageSvc.InitiateUpload(ctx, UserID, ParentID, Name, Bytes, Checksum, Parts)
+}
+
+func (h *HookLayer) CompleteUpload(ctx context.Context, NodeID uuid.UUID, UserID uint64, Parts []shared.CompletedPart) (*Node, error) {
+	node, err := h.storageSvc.CompleteUpload(ctx, NodeID, UserID, Parts)
+	if err != nil {
+		return nil, err
+	}
+
+	h.runAfterPutHooks(ctx, UserID, node)
+	return node, nil
+}
+
+func (h *HookLayer) GrantAccess(ctx context.Context, UserID uint64, NodeID uuid.UUID, Grantee string, Role AccessRole) (*NodeAccess, error) {
+	return h.storageSvc.GrantAccess(ctx, UserID, NodeID, Grantee, Role)
+}
+
+func (h *HookLayer) UpdateAccess(ctx context.Context, UserID uint64, NodeID uuid.UUID, GranteeID uint64, Role AccessRole) (*NodeAccess, error) {
+	return h.storageSvc.UpdateAccess(ctx, UserID, NodeID, GranteeID, Role)
+}
+
+func (h *HookLayer) RevokeAccess(ctx context.Context, UserID uint64, NodeID uuid.UUID, GranteeID uint64) error {
+	return h.storageSvc.RevokeAccess(ctx, UserID, NodeID, GranteeID)
+}
+
+func (h *HookLayer) ListAccess(ctx context.Context, UserID uint64, NodeID uuid.UUID) ([]NodeAccess, error) {
+	return h.storageSvc.ListAccess(ctx, UserID, NodeID)
+}
+
+func (h *HookLayer) ListSharedWithMe(ctx context.Context, UserID uint64, Cursor string, Limit int) (*SharedWithMePage, error) {
+	return h.storageSvc.ListSharedWithMe(ctx, UserID, Cursor, Limit)
+}
+
+func (h *HookLayer) CreateWorkspace(ctx context.Context, UserID uint64, Name string) (*Workspace, error) {
+	return h.storageSvc.CreateWorkspace(ctx, UserID, Name)
+}
+
+func (h *HookLayer) ListWorkspaces(ctx context.Context, UserID uint64) ([]WorkspaceWithRole, error) {
+	return h.storageSvc.ListWorkspaces(ctx, UserID)
+}
+
+func (h *HookLayer) RenameWorkspace(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID, Name string) (*Workspace, error) {
+	return h.storageSvc.RenameWorkspace(ctx, UserID, WorkspaceID, Name)
+}
 
+func (h *HookLayer) DeleteWorkspace(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID) error {
+	return h.storageSvc.DeleteWorkspace(ctx, UserID, WorkspaceID)
+}
+
+func (h *HookLayer) AddWorkspaceMember(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID, Member string, Role WorkspaceRole) (*WorkspaceMember, error) {
+	return h.storageSvc.AddWorkspaceMember(ctx, UserID, WorkspaceID, Member, Role)
+}
+
+func (h *HookLayer) RemoveWorkspaceMember(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID, MemberID uint64) error {
+	return h.storageSvc.RemoveWorkspaceMember(ctx, UserID, WorkspaceID, MemberID)
+}
+
+func (h *HookLayer) ListWorkspaceMembers(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID) ([]WorkspaceMember, error) {
+	return h.storageSvc.ListWorkspaceMembers(ctx, UserID, WorkspaceID)
+}
+
+func (h *HookLayer) GetWorkspaceUsage(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID) (*WorkspaceUsage, error) {
+	return h.storageSvc.GetWorkspaceUsage(ctx, UserID, WorkspaceID)
+}
+
+func (h *HookLayer) SetWorkspaceQuota(ctx context.Context, WorkspaceID uuid.UUID, QuotaBytes *int64) error {
+	return h.storageSvc.SetWorkspaceQuota(ctx, WorkspaceID, QuotaBytes)
+}
+
+func (h *HookLayer) CreateGroup(ctx context.Context, UserID uint64, Name string) (*Group, error) {
+	return h.storageSvc.CreateGroup(ctx, UserID, Name)
+}
+
+func (h *HookLayer) ListGroups(ctx context.Context, UserID uint64) ([]GroupWithRole, error) {
+	return h.storageSvc.ListGroups(ctx, UserID)
+}
+
+func (h *HookLayer) DeleteGroup(ctx context.Context, UserID uint64, GroupID uuid.UUID) error {
+	return h.storageSvc.DeleteGroup(ctx, UserID, GroupID)
+}
+
+func (h *HookLayer) AddGroupMember(ctx context.Context, UserID uint64, GroupID uuid.UUID, Member string, Role GroupRole) (*GroupMember, error) {
+	return h.storageSvc.AddGroupMember(ctx, UserID, GroupID, Member, Role)
+}
+
+func (h *HookLayer) AddNestedGroup(ctx context.Context, UserID uint64, GroupID uuid.UUID, MemberGroupID uuid.UUID) (*GroupMember, error) {
+	return h.storageSvc.AddNestedGroup(ctx, UserID, GroupID, MemberGroupID)
+}
+
+func (h *HookLayer) RemoveGroupMember(ctx context.Context, UserID uint64, GroupID uuid.UUID, MemberID uint64) error {
+	return h.storageSvc.RemoveGroupMember(ctx, UserID, GroupID, MemberID)
+}
+
+func (h *HookLayer) RemoveNestedGroup(ctx context.Context, UserID uint64, GroupID uuid.UUID, MemberGroupID uuid.UUID) error {
+	return h.storageSvc.RemoveNestedGroup(ctx, UserID, GroupID, MemberGroupID)
+}
+
+func (h *HookLayer) ListGroupMembers(ctx context.Context, UserID uint64, GroupID uuid.UUID) ([]GroupMember, error) {
+	return h.storageSvc.ListGroupMembers(ctx, UserID, GroupID)
+}
+
+func (h *HookLayer) GrantGroupAccess(ctx context.Context, UserID uint64, NodeID uuid.UUID, GroupID uuid.UUID, Role AccessRole) (*NodeAccess, error) {
+	return h.storageSvc.GrantGroupAccess(ctx, UserID, NodeID, GroupID, Role)
+}
+
+func (h *HookLayer) RevokeGroupAccess(ctx context.Context, UserID uint64, NodeID uuid.UUID, GroupID uuid.UUID) error {
+	return h.storageSvc.RevokeGroupAccess(ctx, UserID, NodeID, GroupID)
+}
+
+func (h *HookLayer) AddWorkspaceGroup(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID, GroupID uuid.UUID, Role WorkspaceRole) (*WorkspaceMember, error) {
+	return h.storageSvc.AddWorkspaceGroup(ctx, UserID, WorkspaceID, GroupID, Role)
+}
+
+func (h *HookLayer) RemoveWorkspaceGroup(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID, GroupID uuid.UUID) error {
+	return h.storageSvc.RemoveWorkspaceGroup(ctx, UserID, WorkspaceID, GroupID)
+}
+
+func (h *HookLayer) SetVisibility(ctx context.Context, UserID uint64, NodeID uuid.UUID, Visibility NodeVisibility) (*Node, error) {
+	return h.storageSvc.SetVisibility(ctx, UserID, NodeID, Visibility)
+}
+
+func (h *HookLayer) GetReadableNode(ctx context.Context, UserID uint64, NodeID uuid.UUID) (*Node, error) {
+	return h.storageSvc.GetReadableNode(ctx, UserID, NodeID)
+}
+
+func (h *HookLayer) ListPublicNodes(ctx context.Context, Offset int, Limit int) ([]Node, error) {
+	return h.storageSvc.ListPublicNodes(ctx, Offset, Limit)
+}
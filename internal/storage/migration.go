@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
+	"gorm.io/gorm"
+)
+
+// Nodes and grants converted per relationship write
+const migrationBatchSize = 500
+
+// legacyRole maps a node_permissions type onto the role it amounts to. Next
+// to the three named types, 5 and 7 were accepted for writes by the old
+// directory checks; 7 also covered execute.
+func legacyRole(Type PermissionType) (AccessRole, bool) {
+	switch Type {
+	case PermissionRead:
+		return AccessViewer, true
+	case PermissionWrite, 5:
+		return AccessEditor, true
+	case PermissionExecute, 7:
+		return AccessAdmin, true
+	default:
+		return "", false
+	}
+}
+
+// MigrateRelationships writes the owner and parent relationships of every
+// node and a role for every node_permissions row. Relationships are touched,
+// so it can run any number of times. Rows with a type no role matches are
+// left out and reported.
+func (svc *Service) MigrateRelationships(
+	ctx context.Context,
+) (*MigrationReport, error) {
+	report := MigrationReport{}
+	db := svc.DB.WithContext(ctx)
+
+	var nodes []Node
+	err := db.Select("id", "parent_id", "owner_id").
+		FindInBatches(&nodes, migrationBatchSize, func(tx *gorm.DB, batch int) error {
+			relationships := make([]authorization.Relationship, 0, 2*len(nodes))
+			ids := make([]uuid.UUID, 0, len(nodes))
+			for _, node := range nodes {
+				relationships = append(relationships, authorization.Relationship{
+					ResourceType: authorization.ResourceNode,
+					ResourceID:   node.ID.String(),
+					Relation:     authorization.RelationOwner,
+					SubjectType:  authorization.SubjectUser,
+					SubjectID:    userSubject(node.OwnerID),
+				})
+				if node.ParentID != nil {
+					relationships = append(relationships, parentRelationship(node.ID, *node.ParentID))
+				}
+				ids = append(ids, node.ID)
+			}
+
+			token, err := svc.Authz.WriteRelationships(ctx, relationships, nil)
+			if err != nil {
+				return err
+			}
+			if err := storeZedToken(svc.DB.WithContext(ctx), token, ids); err != nil {
+				return err
+			}
+			report.Nodes += len(nodes)
+			report.ZedToken = token
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions []NodePermission
+	err = db.
+		FindInBatches(&permissions, migrationBatchSize, func(tx *gorm.DB, batch int) error {
+			relationships := make([]authorization.Relationship, 0, len(permissions))
+			ids := make([]uuid.UUID, 0, len(permissions))
+			for _, permission := range permissions {
+				role, ok := legacyRole(permission.Type)
+				if !ok {
+					report.Unmapped = append(report.Unmapped, permission)
+					continue
+				}
+				relationships = append(relationships, roleRelationship(permission.NodeID, uint64(permission.UserID), role))
+				ids = append(ids, permission.NodeID)
+			}
+			if len(relationships) == 0 {
+				return nil
+			}
+
+			token, err := svc.Authz.WriteRelationships(ctx, relationships, nil)
+			if err != nil {
+				return err
+			}
+			if err := storeZedToken(svc.DB.WithContext(ctx), token, ids); err != nil {
+				return err
+			}
+			report.Grants += len(relationships)
+			report.ZedToken = token
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// legacyAllows answers a permission check the way the checks before the
+// SpiceDB migration did, quirks included, so the report shows what users
+// actually gain or lose. Ancestors never mattered to them:
+//   - files were served to anyone, checkNodeDeliverability built its grant
+//     query without ever running it; directories were listed to their owner
+//     and users with a grant on them
+//   - writing into a directory took owning it, or any grant of the user's on
+//     any node, as canWriteIntoDirectory only filtered on the user. Its test
+//     for types 2, 5 and 7 only ran when no row was found, so any type did
+//   - everything else, deleting, moving and copying, took owning the node
+func (svc *Service) legacyAllows(
+	ctx context.Context,
+	node *Node,
+	UserID uint64,
+	Permission string,
+) (bool, error) {
+	if node.OwnerID == UserID {
+		return true, nil
+	}
+
+	db := svc.DB.WithContext(ctx)
+	switch {
+	case Permission == authorization.PermissionRead && node.Type == NodeTypeFile:
+		return true, nil
+	case Permission == authorization.PermissionRead:
+		var count int64
+		err := db.Model(&NodePermission{}).
+			Where("node_id = ? AND user_id = ?", node.ID, UserID).
+			Count(&count).Error
+		return count > 0, err
+	case Permission == authorization.PermissionWrite && node.Type == NodeTypeDirectory:
+		var permission NodePermission
+		err := db.Where("user_id = ?", UserID).First(&permission).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	default:
+		return false, nil
+	}
+}
+
+// VerifyRelationships checks a random sample of personal drive nodes against
+// both models, for their owner and everyone granted a role on them or their
+// ancestors. Workspaces never had a legacy counterpart and are skipped.
+func (svc *Service) VerifyRelationships(
+	ctx context.Context,
+	SampleSize int,
+	ZedToken string,
+) (*ParityReport, error) {
+	var sample []Node
+	err := svc.DB.WithContext(ctx).
+		Order("random()").
+		Limit(SampleSize).
+		Find(&sample).Error
+	if err != nil {
+		return nil, err
+	}
+
+	report := ParityReport{Discrepancies: []Discrepancy{}}
+	for _, node := range sample {
+		ancestors, err := svc.getAncestors(ctx, node.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(ancestors) > 0 && ancestors[0].WorkspaceID != nil {
+			continue
+		}
+		report.Nodes++
+
+		ids := make([]uuid.UUID, 0, len(ancestors))
+		for _, ancestor := range ancestors {
+			ids = append(ids, ancestor.ID)
+		}
+		var userIDs []uint64
+		err = svc.DB.WithContext(ctx).
+			Model(&NodePermission{}).
+			Where("node_id IN ?", ids).
+			Distinct().
+			Pluck("user_id", &userIDs).Error
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, node.OwnerID)
+
+		seen := make(map[uint64]bool, len(userIDs))
+		for _, userID := range userIDs {
+			if seen[userID] {
+				continue
+			}
+			seen[userID] = true
+
+			for _, permission := range []string{
+				authorization.PermissionRead,
+				authorization.PermissionWrite,
+				authorization.PermissionExecute,
+			} {
+				legacy, err := svc.legacyAllows(ctx, &node, userID, permission)
+				if err != nil {
+					return nil, err
+				}
+				spicedb, err := svc.Authz.CheckPermOnResource(
+					ctx,
+					authorization.SubjectUser, userSubject(userID),
+					authorization.ResourceNode, node.ID.String(),
+					permission,
+					ZedToken != "",
+					ZedToken,
+				)
+				if err != nil {
+					return nil, err
+				}
+				report.Checks++
+				if legacy != spicedb {
+					report.Discrepancies = append(report.Discrepancies, Discrepancy{
+						NodeID:     node.ID,
+						UserID:     userID,
+						Permission: permission,
+						Legacy:     legacy,
+						SpiceDB:    spicedb,
+					})
+				}
+			}
+		}
+	}
+	return &report, nil
+}
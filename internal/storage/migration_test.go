@@ -0,0 +1,28 @@
+package storage
+
+import "testing"
+
+func TestLegacyRole(t *testing.T) {
+	tests := []struct {
+		name   string
+		Type   PermissionType
+		want   AccessRole
+		wantOK bool
+	}{
+		{"read", PermissionRead, AccessViewer, true},
+		{"write", PermissionWrite, AccessEditor, true},
+		{"execute", PermissionExecute, AccessAdmin, true},
+		{"legacy write", 5, AccessEditor, true},
+		{"legacy write and execute", 7, AccessAdmin, true},
+		{"none", 0, "", false},
+		{"unknown", 4, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := legacyRole(tt.Type)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("legacyRole(%d) = %q, %v, want %q, %v", tt.Type, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"io"
 	"log"
+	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/sirkartik/cloud_drive_2.0/internal/shared"
 )
 
 func NewMinioStorage(endpoint, accessKey, secretKey string, useSSL bool) (*MinioStorage, error) {
@@ -21,7 +24,10 @@ func NewMinioStorage(endpoint, accessKey, secretKey string, useSSL bool) (*Minio
 		return nil, err
 	}
 
-	return &MinioStorage{client: minioClient}, nil
+	return &MinioStorage{
+		client: minioClient,
+		core:   &minio.Core{Client: minioClient},
+	}, nil
 }
 
 func (m *MinioStorage) Put(
@@ -62,6 +68,31 @@ func (m *MinioStorage) Get(
 	return stream, nil
 }
 
+// GetRange streams length bytes of the object starting at offset
+func (m *MinioStorage) GetRange(
+	ctx context.Context,
+	bucket, key string,
+	offset, length int64,
+) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, err
+	}
+
+	stream, err := m.client.GetObject(ctx, bucket, key, opts)
+	if err != nil {
+		log.Printf("Error encountered in Minio GET() with range:\n %v", err)
+		return nil, err
+	}
+
+	if _, err = stream.Stat(); err != nil {
+		stream.Close()
+		return nil, err
+	}
+
+	return stream, nil
+}
+
 func (m *MinioStorage) Delete(
 	ctx context.Context,
 	bucket, key string,
@@ -95,6 +126,22 @@ func (m *MinioStorage) Copy(
 	return nil
 }
 
+func (m *MinioStorage) Stat(
+	ctx context.Context,
+	bucket, key string,
+) (*shared.ObjectInfo, error) {
+	info, err := m.client.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &shared.ObjectInfo{
+		Size:         info.Size,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+	}, nil
+}
+
 func (m *MinioStorage) GeneratePresignedGetURL(
 	ctx context.Context,
 	bucket, key string,
@@ -108,6 +155,27 @@ func (m *MinioStorage) GeneratePresignedGetURL(
 	)
 }
 
+func (m *MinioStorage) GeneratePresignedPutURL(
+	ctx context.Context,
+	bucket, key string,
+	expiry time.Duration,
+) (*url.URL, error) {
+	return m.client.PresignedPutObject(ctx, bucket, key, expiry)
+}
+
+func (m *MinioStorage) GeneratePresignedPartURL(
+	ctx context.Context,
+	bucket, key, uploadID string,
+	partNumber int,
+	expiry time.Duration,
+) (*url.URL, error) {
+	params := url.Values{}
+	params.Set("uploadId", uploadID)
+	params.Set("partNumber", strconv.Itoa(partNumber))
+
+	return m.client.Presign(ctx, http.MethodPut, bucket, key, expiry, params)
+}
+
 func (m *MinioStorage) GeneratePostUploadPolicy(
 	ctx context.Context,
 	bucket, dirKey string,
@@ -128,3 +196,68 @@ func (m *MinioStorage) GeneratePostUploadPolicy(
 
 	return url, formData, nil
 }
+
+func (m *MinioStorage) NewMultipartUpload(
+	ctx context.Context,
+	bucket, key string,
+) (string, error) {
+	return m.core.NewMultipartUpload(ctx, bucket, key, minio.PutObjectOptions{})
+}
+
+func (m *MinioStorage) PutPart(
+	ctx context.Context,
+	bucket, key, uploadID string,
+	partNumber int,
+	data io.Reader,
+	size int64,
+) (string, error) {
+	part, err := m.core.PutObjectPart(
+		ctx,
+		bucket,
+		key,
+		uploadID,
+		partNumber,
+		data,
+		size,
+		minio.PutObjectPartOptions{},
+	)
+	if err != nil {
+		return "", err
+	}
+	return part.ETag, nil
+}
+
+func (m *MinioStorage) CompleteMultipartUpload(
+	ctx context.Context,
+	bucket, key, uploadID string,
+	parts []shared.CompletedPart,
+) error {
+	completeParts := make([]minio.CompletePart, 0, len(parts))
+	for _, part := range parts {
+		completeParts = append(completeParts, minio.CompletePart{
+			PartNumber: part.PartNumber,
+			ETag:       part.ETag,
+		})
+	}
+
+	_, err := m.core.CompleteMultipartUpload(
+		ctx,
+		bucket,
+		key,
+		uploadID,
+		completeParts,
+		minio.PutObjectOptions{},
+	)
+	return err
+}
+
+func (m *MinioStorage) AbortMultipartUpload(
+	ctx context.Context,
+	bucket, key, uploadID string,
+) error {
+	err := m.core.AbortMultipartUpload(ctx, bucket, key, uploadID)
+	if err != nil {
+		log.Printf("Error encountered in Minio ABORT(): %v\n", err)
+	}
+	return err
+}
@@ -13,6 +13,14 @@ const (
 	NodeTypeDirectory NodeType = "directory"
 )
 
+type NodeStatus string
+
+const (
+	NodeStatusActive  NodeStatus = "active"
+	NodeStatusPending NodeStatus = "pending"
+	NodeStatusTrashed NodeStatus = "trashed"
+)
+
 type PermissionType uint8
 
 const (
@@ -21,6 +29,28 @@ const (
 	PermissionExecute PermissionType = 1
 )
 
+// AccessRole is a role granted on a node, named after its relation in the
+// SpiceDB schema.
+type AccessRole string
+
+const (
+	AccessViewer AccessRole = "viewer"
+	AccessEditor AccessRole = "editor"
+	AccessAdmin  AccessRole = "admin"
+	AccessOwner  AccessRole = "owner" // Reported only, never granted
+)
+
+// NodeVisibility is who can view a node without it being shared with them.
+// Both link and public let anyone read the node and what is below it, public
+// nodes are listed for everyone on top.
+type NodeVisibility string
+
+const (
+	VisibilityPrivate NodeVisibility = "private"
+	VisibilityLink    NodeVisibility = "link"
+	VisibilityPublic  NodeVisibility = "public"
+)
+
 type Node struct {
 	ID        uuid.UUID  `json:"id" db:"id"`
 	ParentID  *uuid.UUID `json:"parent_id" db:"parent_id"`
@@ -30,7 +60,14 @@ type Node struct {
 	Key       *string    `json:"-" db:"object_storage_key"`            // Only for files, No JSON output
 	SizeBytes *uint64    `json:"size_bytes,omitempty" db:"size_bytes"` // Only for files
 	MimeType  *string    `json:"mime_type,omitempty" db:"mime_type"`   // Only for files
+	Status    NodeStatus `json:"-" db:"status" gorm:"default:active"`
 	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	ZedToken  *string    `json:"-" db:"zed_token"` // Last relationship write, for read-after-write checks
+	// When ZedToken was written, telling the freshest of several tokens apart
+	ZedTokenAt *time.Time `json:"-" db:"zed_token_at"`
+
+	WorkspaceID *uuid.UUID     `json:"workspace_id,omitempty" db:"workspace_id"` // Only for the root directory of a workspace
+	Visibility  NodeVisibility `json:"visibility" db:"visibility" gorm:"default:private"`
 }
 
 type Subtitle struct {
@@ -60,9 +97,273 @@ type MusicMetadata struct {
 	Genre           string `json:"genre,omitempty" db:"genre"`
 }
 
+// NodePermission mirrors a role granted on a node in SpiceDB, so listings
+// can be joined against it.
 type NodePermission struct {
-	ID     int64          `json:"id" db:"id"`
-	NodeID uuid.UUID      `json:"node_id" db:"node_id"`
-	UserID int64          `json:"user_id" db:"user_id"`
-	Type   PermissionType `json:"type" db:"type"`
+	ID        int64          `json:"id" db:"id"`
+	NodeID    uuid.UUID      `json:"node_id" db:"node_id" gorm:"uniqueIndex:idx_node_permissions_node_user"`
+	UserID    int64          `json:"user_id" db:"user_id" gorm:"uniqueIndex:idx_node_permissions_node_user"`
+	Type      PermissionType `json:"type" db:"type"`
+	GrantedBy uint64         `json:"granted_by" db:"granted_by"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
+}
+
+// Upload tracks a resumable (tus) upload backed by an object storage
+// multipart upload. Bytes that do not yet fill a whole part are parked in
+// a separate object until the next chunk arrives.
+type Upload struct {
+	ID           uuid.UUID  `json:"id" db:"id"`
+	OwnerID      uint64     `json:"-" db:"owner_id"`
+	ParentID     *uuid.UUID `json:"parent_id" db:"parent_id"`
+	Name         string     `json:"name" db:"name"`
+	Key          string     `json:"-" db:"object_storage_key"`
+	MultipartID  string     `json:"-" db:"multipart_id"`
+	Length       uint64     `json:"length" db:"length"`
+	Offset       uint64     `json:"offset" db:"offset"`
+	PendingBytes uint64     `json:"-" db:"pending_bytes"`
+	PartCount    int        `json:"-" db:"part_count"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+type UploadPart struct {
+	UploadID   uuid.UUID `json:"upload_id" db:"upload_id" gorm:"primaryKey"`
+	PartNumber int       `json:"part_number" db:"part_number" gorm:"primaryKey;autoIncrement:false"`
+	ETag       string    `json:"etag" db:"etag"`
+	Size       uint64    `json:"size" db:"size"`
+}
+
+// DirectUpload holds the bookkeeping for a pending node whose bytes are
+// uploaded straight to object storage through presigned URLs.
+type DirectUpload struct {
+	NodeID      uuid.UUID `json:"node_id" db:"node_id" gorm:"primaryKey"`
+	MultipartID string    `json:"-" db:"multipart_id"` // Empty for single PUT uploads
+	Checksum    string    `json:"-" db:"checksum"`     // Hex encoded SHA-256, optional
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+}
+
+type JobStatus string
+
+const (
+	JobStatusRunning   JobStatus = "running"
+	JobStatusCompleted JobStatus = "completed"
+	JobStatusFailed    JobStatus = "failed"
+)
+
+// CopyJob tracks a directory copy that is too large to finish within the
+// request and runs in the background instead.
+type CopyJob struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	OwnerID       uint64     `json:"-" db:"owner_id"`
+	TargetNodeID  uuid.UUID  `json:"target_id" db:"target_node_id"`
+	DestinationID *uuid.UUID `json:"dest_parent_id" db:"destination_id"`
+	Status        JobStatus  `json:"status" db:"status"`
+	TotalNodes    int        `json:"total_nodes" db:"total_nodes"`
+	CopiedNodes   int        `json:"copied_nodes" db:"copied_nodes"`
+	Error         string     `json:"error,omitempty" db:"error"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// TrashItem is the root of a deleted subtree. The root is detached from its
+// parent while in the trash, so the original location is kept here.
+type TrashItem struct {
+	ID               uuid.UUID  `json:"id" db:"id"`
+	OwnerID          uint64     `json:"-" db:"owner_id"`
+	NodeID           uuid.UUID  `json:"node_id" db:"node_id"`
+	Name             string     `json:"name" db:"name"`
+	Type             NodeType   `json:"node_type" db:"type"`
+	SizeBytes        uint64     `json:"size_bytes" db:"size_bytes"` // Total size of the subtree
+	OriginalParentID *uuid.UUID `json:"original_parent_id" db:"original_parent_id"`
+	OriginalPath     string     `json:"original_path" db:"original_path"`
+	DeletedAt        time.Time  `json:"deleted_at" db:"deleted_at"`
+}
+
+// FileVersion is one revision of a file's content. Versions are only
+// recorded once a file is overwritten, the newest one is the current content.
+type FileVersion struct {
+	ID         uuid.UUID `json:"id" db:"id"`
+	NodeID     uuid.UUID `json:"node_id" db:"node_id" gorm:"index"`
+	Key        string    `json:"-" db:"object_storage_key"`
+	SizeBytes  uint64    `json:"size_bytes" db:"size_bytes"`
+	MimeType   *string   `json:"mime_type,omitempty" db:"mime_type"`
+	UploadedBy uint64    `json:"uploaded_by" db:"uploaded_by"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	Current    bool      `json:"current" gorm:"-"`
+}
+
+// Blob is a stored object shared by every node and file version with the
+// same content. Objects uploaded before deduplication have no hash.
+type Blob struct {
+	Key       string    `json:"-" db:"object_storage_key" gorm:"primaryKey"`
+	Hash      *string   `json:"-" db:"hash" gorm:"uniqueIndex"` // Hex encoded SHA-256
+	SizeBytes uint64    `json:"size_bytes" db:"size_bytes"`
+	RefCount  int64     `json:"ref_count" db:"ref_count"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// StorageUsage is the running total of bytes a user stores. QuotaBytes
+// overrides the configured default when set.
+type StorageUsage struct {
+	UserID     uint64    `json:"user_id" db:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	QuotaBytes *int64    `json:"quota_bytes" db:"quota_bytes"`
+	UsedBytes  int64     `json:"used_bytes" db:"used_bytes"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// DocumentText is the text extracted from a stored object for full-text
+// search. It is keyed like Blob, so every node and version sharing the
+// content shares the entry. The tsvector column is generated from Content.
+type DocumentText struct {
+	Key       string    `json:"-" db:"object_storage_key" gorm:"primaryKey"`
+	Content   string    `json:"-" db:"content"`
+	IndexedAt time.Time `json:"indexed_at" db:"indexed_at"`
+}
+
+type ShareAccess string
+
+const (
+	ShareAccessRead   ShareAccess = "read"
+	ShareAccessUpload ShareAccess = "upload" // Folders only, read access included
+)
+
+// ShareLink opens a file or folder to anyone holding its token, without an
+// account. Revoking a link deletes it.
+type ShareLink struct {
+	ID             uuid.UUID   `json:"id" db:"id"`
+	Token          string      `json:"token" db:"token" gorm:"uniqueIndex"`
+	NodeID         uuid.UUID   `json:"node_id" db:"node_id" gorm:"index"`
+	OwnerID        uint64      `json:"-" db:"owner_id" gorm:"index"`
+	PasswordHash   *string     `json:"-" db:"password_hash"` // bcrypt
+	Access         ShareAccess `json:"access" db:"access"`
+	ExpiresAt      *time.Time  `json:"expires_at" db:"expires_at"`
+	MaxDownloads   *int64      `json:"max_downloads" db:"max_downloads"`
+	DownloadCount  int64       `json:"download_count" db:"download_count"`
+	AccessCount    int64       `json:"access_count" db:"access_count"`
+	LastAccessedAt *time.Time  `json:"last_accessed_at" db:"last_accessed_at"`
+	CreatedAt      time.Time   `json:"created_at" db:"created_at"`
+	HasPassword    bool        `json:"has_password" gorm:"-"`
+}
+
+// FileRequest is an upload-only link into one of its owner's directories.
+// Whoever holds the token can drop files in, but never see what is there.
+type FileRequest struct {
+	ID           uuid.UUID  `json:"id" db:"id"`
+	Token        string     `json:"token" db:"token" gorm:"uniqueIndex"`
+	OwnerID      uint64     `json:"-" db:"owner_id" gorm:"index"`
+	DirectoryID  uuid.UUID  `json:"directory_id" db:"directory_id" gorm:"index"`
+	Title        string     `json:"title" db:"title"`
+	Message      string     `json:"message" db:"message"`
+	MaxFileBytes *uint64    `json:"max_file_bytes" db:"max_file_bytes"`
+	AllowedTypes string     `json:"allowed_types" db:"allowed_types"` // Comma separated, "image/" matching every image
+	ExpiresAt    *time.Time `json:"expires_at" db:"expires_at"`
+	UploadCount  int64      `json:"upload_count" db:"upload_count"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+// FileRequestUpload records a file that arrived through a file request.
+type FileRequestUpload struct {
+	ID           uuid.UUID `json:"id" db:"id"`
+	RequestID    uuid.UUID `json:"request_id" db:"request_id" gorm:"index"`
+	NodeID       uuid.UUID `json:"node_id" db:"node_id" gorm:"index"`
+	Name         string    `json:"name" db:"name"`
+	SizeBytes    uint64    `json:"size_bytes" db:"size_bytes"`
+	MimeType     string    `json:"mime_type" db:"mime_type"`
+	UploaderName string    `json:"uploader_name" db:"uploader_name"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+type WorkspaceRole string
+
+const (
+	WorkspaceRoleOwner  WorkspaceRole = "owner"
+	WorkspaceRoleAdmin  WorkspaceRole = "admin"
+	WorkspaceRoleEditor WorkspaceRole = "editor"
+	WorkspaceRoleMember WorkspaceRole = "member"
+)
+
+// Workspace is a drive shared by a team. Everything in it lives below its
+// root directory, which takes its permissions from the workspace.
+type Workspace struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	Name       string     `json:"name" db:"name"`
+	OwnerID    uint64     `json:"owner_id" db:"owner_id"`
+	RootID     uuid.UUID  `json:"root_id" db:"root_id" gorm:"uniqueIndex"`
+	QuotaBytes *int64     `json:"quota_bytes" db:"quota_bytes"` // Nil for the default
+	UsedBytes  *int64     `json:"-" db:"used_bytes"`            // Nil until first counted
+	ZedToken   *string    `json:"-" db:"zed_token"`
+	ZedTokenAt *time.Time `json:"-" db:"zed_token_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// WorkspaceMembership mirrors a user's role on a workspace in SpiceDB.
+type WorkspaceMembership struct {
+	WorkspaceID uuid.UUID     `json:"workspace_id" db:"workspace_id" gorm:"primaryKey"`
+	UserID      uint64        `json:"user_id" db:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	Role        WorkspaceRole `json:"role" db:"role"`
+	AddedBy     uint64        `json:"added_by" db:"added_by"`
+	CreatedAt   time.Time     `json:"created_at" db:"created_at"`
+}
+
+// WorkspaceGroupMembership mirrors a group's role on a workspace in SpiceDB,
+// every member of the group holding that role.
+type WorkspaceGroupMembership struct {
+	WorkspaceID uuid.UUID     `json:"workspace_id" db:"workspace_id" gorm:"primaryKey"`
+	GroupID     uuid.UUID     `json:"group_id" db:"group_id" gorm:"primaryKey"`
+	Role        WorkspaceRole `json:"role" db:"role"`
+	AddedBy     uint64        `json:"added_by" db:"added_by"`
+	CreatedAt   time.Time     `json:"created_at" db:"created_at"`
+}
+
+type GroupRole string
+
+const (
+	GroupRoleOwner  GroupRole = "owner"
+	GroupRoleAdmin  GroupRole = "admin"
+	GroupRoleMember GroupRole = "member"
+)
+
+// Group is a set of users, and of other groups, nodes and workspaces can be
+// shared with at once.
+type Group struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	Name       string     `json:"name" db:"name"`
+	OwnerID    uint64     `json:"owner_id" db:"owner_id"`
+	ZedToken   *string    `json:"-" db:"zed_token"`
+	ZedTokenAt *time.Time `json:"-" db:"zed_token_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// UserZedToken is the last relationship write that took a user out of a
+// group, which the group's own token no longer covers once they left.
+type UserZedToken struct {
+	UserID     uint64    `json:"user_id" db:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	ZedToken   string    `json:"-" db:"zed_token"`
+	ZedTokenAt time.Time `json:"-" db:"zed_token_at"`
+}
+
+// GroupMembership mirrors a user's role in a group in SpiceDB.
+type GroupMembership struct {
+	GroupID   uuid.UUID `json:"group_id" db:"group_id" gorm:"primaryKey"`
+	UserID    uint64    `json:"user_id" db:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	Role      GroupRole `json:"role" db:"role"`
+	AddedBy   uint64    `json:"added_by" db:"added_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// NestedGroup mirrors a group being a member of another group, its members
+// being members of both.
+type NestedGroup struct {
+	GroupID       uuid.UUID `json:"group_id" db:"group_id" gorm:"primaryKey"`
+	MemberGroupID uuid.UUID `json:"member_group_id" db:"member_group_id" gorm:"primaryKey"`
+	AddedBy       uint64    `json:"added_by" db:"added_by"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+// NodeGroupPermission mirrors a role granted on a node to a group.
+type NodeGroupPermission struct {
+	NodeID    uuid.UUID      `json:"node_id" db:"node_id" gorm:"primaryKey"`
+	GroupID   uuid.UUID      `json:"group_id" db:"group_id" gorm:"primaryKey"`
+	Type      PermissionType `json:"type" db:"type"`
+	GrantedBy uint64         `json:"granted_by" db:"granted_by"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
 }
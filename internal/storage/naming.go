@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
+	"gorm.io/gorm"
+)
+
+type ConflictStrategy string
+
+const (
+	ConflictFail      ConflictStrategy = "fail"
+	ConflictRename    ConflictStrategy = "rename"    // "report.pdf" becomes "report (1).pdf"
+	ConflictOverwrite ConflictStrategy = "overwrite" // Replace the content of the existing file
+)
+
+func ParseConflictStrategy(s string) (ConflictStrategy, error) {
+	switch ConflictStrategy(s) {
+	case "", ConflictFail:
+		return ConflictFail, nil
+	case ConflictRename, ConflictOverwrite:
+		return ConflictStrategy(s), nil
+	}
+	return "", fmt.Errorf("unknown conflict strategy %q", s)
+}
+
+func validateNodeName(Name string) error {
+	if strings.TrimSpace(Name) == "" || Name == "." || Name == ".." || strings.ContainsAny(Name, "/\x00") {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// siblings scopes a query to the children of ParentID. Nodes at the root have
+// no parent, so names only have to be unique per owner there. Workspace roots
+// live outside of anyone's drive.
+func siblings(tx *gorm.DB, ParentID *uuid.UUID, OwnerID uint64) *gorm.DB {
+	query := tx.Model(&Node{}).Where("status <> ?", NodeStatusTrashed)
+	if ParentID == nil {
+		return query.Where("parent_id IS NULL AND owner_id = ? AND workspace_id IS NULL", OwnerID)
+	}
+	return query.Where("parent_id = ?", *ParentID)
+}
+
+func findSibling(
+	tx *gorm.DB,
+	ParentID *uuid.UUID,
+	OwnerID uint64,
+	Name string,
+	ExcludeID uuid.UUID,
+) (*Node, error) {
+	var node Node
+	err := siblings(tx, ParentID, OwnerID).
+		Where("name = ? AND id <> ?", Name, ExcludeID).
+		First(&node).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &node, nil
+}
+
+// uniqueName appends the lowest free " (n)" suffix before the extension.
+func uniqueName(
+	tx *gorm.DB,
+	ParentID *uuid.UUID,
+	OwnerID uint64,
+	Name string,
+) (string, error) {
+	ext := path.Ext(Name)
+	if ext == Name {
+		ext = ""
+	}
+	base := strings.TrimSuffix(Name, ext)
+
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	var taken []string
+	err := siblings(tx, ParentID, OwnerID).
+		Where("name LIKE ?", escaper.Replace(base)+" (%)"+escaper.Replace(ext)).
+		Pluck("name", &taken).Error
+	if err != nil {
+		return "", err
+	}
+
+	used := make(map[string]bool, len(taken))
+	for _, name := range taken {
+		used[name] = true
+	}
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if !used[candidate] {
+			return candidate, nil
+		}
+	}
+}
+
+// renameDuplicateSiblings gives every node sharing its name with an older
+// sibling a " (n)" suffix, so the unique name indexes can be created over
+// nodes written before they existed.
+func renameDuplicateSiblings(DB *gorm.DB) error {
+	// Once both indexes exist there can't be any
+	var indexed int64
+	err := DB.Raw(`
+		SELECT count(*) FROM pg_indexes
+		WHERE indexname IN ('idx_nodes_live_parent_name', 'idx_nodes_live_personal_root_name');
+	`).Scan(&indexed).Error
+	if err != nil || indexed == 2 {
+		return err
+	}
+
+	var duplicates []Node
+	err = DB.Raw(`
+		SELECT id, parent_id, owner_id, name FROM (
+			SELECT id, parent_id, owner_id, name, row_number() OVER (
+				PARTITION BY parent_id, CASE WHEN parent_id IS NULL THEN owner_id END, name
+				ORDER BY created_at, id
+			) AS rank
+			FROM nodes
+			WHERE status <> ? AND (parent_id IS NOT NULL OR workspace_id IS NULL)
+		) ranked
+		WHERE rank > 1;
+	`, NodeStatusTrashed).
+		Scan(&duplicates).Error
+	if err != nil {
+		return err
+	}
+
+	for _, node := range duplicates {
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			name, err := uniqueName(tx, node.ParentID, node.OwnerID, node.Name)
+			if err != nil {
+				return err
+			}
+			return tx.Model(&Node{}).Where("id = ?", node.ID).Update("name", name).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(duplicates) > 0 {
+		log.Printf("Renamed %d nodes that shared a name with a sibling", len(duplicates))
+	}
+	return nil
+}
+
+// resolveNameConflict decides the name a node called Name gets under
+// ParentID. When the strategy is overwrite and a sibling already holds the
+// name, that sibling is returned so the caller can replace its content.
+func resolveNameConflict(
+	tx *gorm.DB,
+	ParentID *uuid.UUID,
+	OwnerID uint64,
+	Name string,
+	Type NodeType,
+	ExcludeID uuid.UUID,
+	Strategy ConflictStrategy,
+) (string, *Node, error) {
+	existing, err := findSibling(tx, ParentID, OwnerID, Name, ExcludeID)
+	if err != nil || existing == nil {
+		return Name, nil, err
+	}
+
+	switch Strategy {
+	case ConflictRename:
+		name, err := uniqueName(tx, ParentID, OwnerID, Name)
+		return name, nil, err
+	case ConflictOverwrite:
+		// Only a file can replace another file
+		if Type != NodeTypeFile || existing.Type != NodeTypeFile || existing.Status != NodeStatusActive {
+			return "", nil, ErrNameConflict
+		}
+		return Name, existing, nil
+	default:
+		return "", nil, ErrNameConflict
+	}
+}
+
+func (svc *Service) deleteObjects(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, key := range keys {
+		_ = svc.Client.Delete(cleanupCtx, svc.Cfg.Storage.BucketName, key)
+	}
+}
+
+func (svc *Service) Rename(
+	ctx context.Context,
+	NodeID uuid.UUID,
+	Name string,
+	UserID uint64,
+	Strategy ConflictStrategy,
+) (*Node, error) {
+	if err := validateNodeName(Name); err != nil {
+		return nil, err
+	}
+
+	var renamed Node
+	if err := svc.DB.WithContext(ctx).Where("id = ? AND status = ?", NodeID, NodeStatusActive).
+		First(&renamed).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNodeNotFound
+		}
+		return nil, err
+	}
+	if renamed.WorkspaceID != nil {
+		return nil, ErrWorkspaceRoot
+	}
+	if err := svc.checkPermission(ctx, &renamed, UserID, authorization.PermissionWrite); err != nil {
+		return nil, err
+	}
+
+	sourceID := renamed.ID
+	var staleKeys []string
+	var merged bool
+
+	err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if renamed.Name == Name {
+			return nil
+		}
+
+		name, existing, err := resolveNameConflict(tx, renamed.ParentID, renamed.OwnerID, Name, renamed.Type, renamed.ID, Strategy)
+		if err != nil {
+			return err
+		}
+
+		if existing != nil {
+			// The renamed file becomes the newest version of the existing one
+			staleKeys, err = svc.mergeInto(tx, &renamed, existing, UserID)
+			if err != nil {
+				return err
+			}
+			merged = true
+			renamed = *existing
+			return nil
+		}
+
+		if err := tx.Model(&Node{}).Where("id = ?", renamed.ID).Update("name", name).Error; err != nil {
+			return translateNameError(err)
+		}
+		renamed.Name = name
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if merged {
+		svc.forgetNodes(ctx, sourceID)
+	}
+	svc.deleteObjects(staleKeys...)
+	return &renamed, nil
+}
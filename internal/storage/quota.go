@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// A user's usage is the size of every file they own, trashed and pending ones
+// included, plus the previous versions of those files. The counter is kept
+// up to date by the operations that change it; users without a row get one
+// computed from their nodes on first use, so ensureUsage must run before the
+// rows being accounted for change.
+
+// previousVersionsClause matches every version of a file except its newest,
+// which is the content the node itself already accounts for.
+const previousVersionsClause = `fv.created_at < (
+	SELECT max(latest.created_at) FROM file_versions latest
+	WHERE latest.node_id = fv.node_id
+)`
+
+func ensureUsage(tx *gorm.DB, UserID uint64) error {
+	return tx.Exec(`
+	INSERT INTO storage_usages (user_id, used_bytes, updated_at)
+	VALUES (
+		?,
+		(SELECT COALESCE(SUM(size_bytes), 0) FROM nodes
+		WHERE owner_id = ? AND type = ?) +
+		(SELECT COALESCE(SUM(fv.size_bytes), 0) FROM file_versions fv
+		JOIN nodes n ON n.id = fv.node_id
+		WHERE n.owner_id = ? AND `+previousVersionsClause+`),
+		now()
+	)
+	ON CONFLICT (user_id) DO NOTHING;
+	`, UserID, UserID, NodeTypeFile, UserID).Error
+}
+
+// chargeUsage adds Delta to the user's usage. Growth past the quota is
+// refused by the update itself, so concurrent writes can't take a user over
+// it between check and charge.
+func (svc *Service) chargeUsage(tx *gorm.DB, UserID uint64, Delta int64) error {
+	if err := ensureUsage(tx, UserID); err != nil {
+		return err
+	}
+	if Delta == 0 {
+		return nil
+	}
+
+	query := tx.Model(&StorageUsage{}).Where("user_id = ?", UserID)
+	if Delta > 0 {
+		quota := svc.Cfg.Storage.DefaultQuotaBytes
+		query = query.Where(
+			"(COALESCE(quota_bytes, ?) <= 0 OR used_bytes + ? <= COALESCE(quota_bytes, ?))",
+			quota, Delta, quota,
+		)
+	}
+	result := query.Updates(map[string]interface{}{
+		"used_bytes": gorm.Expr("used_bytes + ?", Delta),
+		"updated_at": time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 && Delta > 0 {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+func (svc *Service) getUsage(
+	ctx context.Context,
+	UserID uint64,
+) (*StorageUsage, error) {
+	db := svc.DB.WithContext(ctx)
+	if err := ensureUsage(db, UserID); err != nil {
+		return nil, err
+	}
+
+	var usage StorageUsage
+	if err := db.Where("user_id = ?", UserID).First(&usage).Error; err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+func (svc *Service) quotaOf(usage *StorageUsage) int64 {
+	if usage.QuotaBytes != nil {
+		return *usage.QuotaBytes
+	}
+	return svc.Cfg.Storage.DefaultQuotaBytes
+}
+
+// checkQuota rejects writes of Bytes that would take the user past their
+// quota. A quota of zero means unlimited.
+func (svc *Service) checkQuota(
+	ctx context.Context,
+	UserID uint64,
+	Bytes uint64,
+) error {
+	usage, err := svc.getUsage(ctx, UserID)
+	if err != nil {
+		return err
+	}
+
+	quota := svc.quotaOf(usage)
+	if quota > 0 && usage.UsedBytes+int64(Bytes) > quota {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+func (svc *Service) GetUsage(
+	ctx context.Context,
+	UserID uint64,
+) (*Usage, error) {
+	usage, err := svc.getUsage(ctx, UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var categories []struct {
+		Category string
+		Bytes    int64
+	}
+	err = svc.DB.WithContext(ctx).
+		Raw(`
+		WITH owned AS (
+			SELECT size_bytes, mime_type FROM nodes
+			WHERE owner_id = ? AND type = ?
+
+			UNION ALL
+
+			SELECT fv.size_bytes, fv.mime_type FROM file_versions fv
+			JOIN nodes n ON n.id = fv.node_id
+			WHERE n.owner_id = ? AND `+previousVersionsClause+`
+		)
+		SELECT
+			CASE
+				WHEN mime_type LIKE 'video/%' THEN 'video'
+				WHEN mime_type LIKE 'image/%' THEN 'images'
+				WHEN mime_type LIKE 'text/%'
+					OR mime_type IN ('application/pdf', 'application/rtf', 'application/msword',
+						'application/vnd.ms-excel', 'application/vnd.ms-powerpoint')
+					OR mime_type LIKE 'application/vnd.openxmlformats-officedocument.%'
+					OR mime_type LIKE 'application/vnd.oasis.opendocument.%'
+					THEN 'documents'
+				ELSE 'other'
+			END AS category,
+			COALESCE(SUM(size_bytes), 0) AS bytes
+		FROM owned
+		GROUP BY category;
+	`, UserID, NodeTypeFile, UserID).
+		Scan(&categories).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := Usage{
+		QuotaBytes: svc.quotaOf(usage),
+		UsedBytes:  usage.UsedBytes,
+		Categories: map[string]int64{
+			"video":     0,
+			"images":    0,
+			"documents": 0,
+			"other":     0,
+		},
+	}
+	for _, category := range categories {
+		result.Categories[category.Category] = category.Bytes
+	}
+	return &result, nil
+}
+
+// SetQuota overrides the default quota for a user, nil restores the default.
+func (svc *Service) SetQuota(
+	ctx context.Context,
+	UserID uint64,
+	QuotaBytes *int64,
+) error {
+	db := svc.DB.WithContext(ctx)
+	if err := ensureUsage(db, UserID); err != nil {
+		return err
+	}
+	return db.Model(&StorageUsage{}).
+		Where("user_id = ?", UserID).
+		Update("quota_bytes", QuotaBytes).Error
+}
@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/shared"
+	"gorm.io/gorm"
+)
+
+// Object storage rejects multipart parts smaller than 5 MiB unless they
+// are the last part of the object.
+const uploadPartSize = 5 << 20
+
+func pendingPartKey(key string) string {
+	return key + ".pending"
+}
+
+func (svc *Service) CreateUpload(
+	ctx context.Context,
+	UserID uint64,
+	ParentID uuid.UUID,
+	Name string,
+	Length uint64,
+) (*Upload, error) {
+	if err := validateNodeName(Name); err != nil {
+		return nil, err
+	}
+
+	var parentID *uuid.UUID
+
+	if ParentID != uuid.Nil {
+		parentID = &ParentID
+		if err := svc.canWriteIntoDirectory(ctx, ParentID, UserID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrParentNodeNotFound
+			}
+			return nil, err
+		}
+	}
+
+	// Uploads still in progress will be charged once they finish
+	var inFlight uint64
+	err := svc.DB.WithContext(ctx).
+		Model(&Upload{}).
+		Where("owner_id = ?", UserID).
+		Select("COALESCE(SUM(length), 0)").
+		Scan(&inFlight).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := svc.checkQuota(ctx, UserID, inFlight+Length); err != nil {
+		return nil, err
+	}
+	if err := svc.checkWorkspaceQuota(ctx, ParentID, Length); err != nil {
+		return nil, err
+	}
+
+	key := uuid.NewString()
+	multipartID, err := svc.Client.NewMultipartUpload(ctx, svc.Cfg.Storage.BucketName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	upload := Upload{
+		ID:          uuid.New(),
+		OwnerID:     UserID,
+		ParentID:    parentID,
+		Name:        Name,
+		Key:         key,
+		MultipartID: multipartID,
+		Length:      Length,
+		CreatedAt:   time.Now(),
+	}
+
+	if err := svc.DB.WithContext(ctx).Create(&upload).Error; err != nil {
+		_ = svc.Client.AbortMultipartUpload(ctx, svc.Cfg.Storage.BucketName, key, multipartID)
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+func (svc *Service) GetUpload(
+	ctx context.Context,
+	UploadID uuid.UUID,
+	UserID uint64,
+) (*Upload, error) {
+	var upload Upload
+	err := svc.DB.WithContext(ctx).
+		Where("id = ? AND owner_id = ?", UploadID, UserID).
+		First(&upload).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// WriteUpload appends data at Offset. The returned node is non-nil once the
+// last byte has been received and the upload has been turned into a file.
+func (svc *Service) WriteUpload(
+	ctx context.Context,
+	UploadID uuid.UUID,
+	UserID uint64,
+	Offset uint64,
+	data io.Reader,
+) (*Upload, *Node, error) {
+	upload, err := svc.GetUpload(ctx, UploadID, UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if Offset != upload.Offset {
+		return nil, nil, ErrUploadOffsetMismatch
+	}
+
+	// Bytes that made it to the server must be persisted even if the client
+	// disconnects halfway through the chunk
+	ctx = context.WithoutCancel(ctx)
+
+	bucket := svc.Cfg.Storage.BucketName
+	committed := upload.Offset - upload.PendingBytes
+	reader := io.LimitReader(data, int64(upload.Length-upload.Offset))
+
+	if upload.PendingBytes > 0 {
+		pending, err := svc.Client.Get(ctx, bucket, pendingPartKey(upload.Key))
+		if err != nil {
+			return nil, nil, err
+		}
+		defer pending.Close()
+		reader = io.MultiReader(pending, reader)
+	}
+
+	buf := make([]byte, uploadPartSize)
+	for {
+		n, readErr := io.ReadFull(reader, buf)
+		if readErr != nil && !errors.Is(readErr, io.EOF) && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+			log.Println("upload chunk interrupted: ", readErr)
+		}
+
+		isLastPart := committed+uint64(n) == upload.Length
+		if n == uploadPartSize || (isLastPart && (n > 0 || upload.PartCount == 0)) {
+			if err := svc.putUploadPart(ctx, upload, buf[:n], committed); err != nil {
+				return nil, nil, err
+			}
+			committed += uint64(n)
+			if isLastPart {
+				break
+			}
+			continue
+		}
+
+		if n > 0 {
+			if err := svc.Client.Put(ctx, bucket, pendingPartKey(upload.Key), bytes.NewReader(buf[:n]), int64(n)); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err := svc.advanceUpload(ctx, upload, committed+uint64(n), uint64(n), upload.PartCount); err != nil {
+			return nil, nil, err
+		}
+		break
+	}
+
+	if upload.Offset < upload.Length {
+		return upload, nil, nil
+	}
+
+	node, err := svc.finishUpload(ctx, upload)
+	if err != nil {
+		return nil, nil, err
+	}
+	return upload, node, nil
+}
+
+func (svc *Service) putUploadPart(
+	ctx context.Context,
+	upload *Upload,
+	data []byte,
+	committed uint64,
+) error {
+	partNumber := upload.PartCount + 1
+	etag, err := svc.Client.PutPart(
+		ctx,
+		svc.Cfg.Storage.BucketName,
+		upload.Key,
+		upload.MultipartID,
+		partNumber,
+		bytes.NewReader(data),
+		int64(len(data)),
+	)
+	if err != nil {
+		return err
+	}
+
+	part := UploadPart{
+		UploadID:   upload.ID,
+		PartNumber: partNumber,
+		ETag:       etag,
+		Size:       uint64(len(data)),
+	}
+	if err := svc.DB.WithContext(ctx).Create(&part).Error; err != nil {
+		return err
+	}
+
+	if upload.PendingBytes > 0 {
+		_ = svc.Client.Delete(ctx, svc.Cfg.Storage.BucketName, pendingPartKey(upload.Key))
+	}
+	return svc.advanceUpload(ctx, upload, committed+uint64(len(data)), 0, partNumber)
+}
+
+// advanceUpload moves the offset forward, failing if another request has
+// written to the same upload in the meantime.
+func (svc *Service) advanceUpload(
+	ctx context.Context,
+	upload *Upload,
+	offset uint64,
+	pendingBytes uint64,
+	partCount int,
+) error {
+	result := svc.DB.WithContext(ctx).
+		Model(&Upload{}).
+		Where("id = ? AND \"offset\" = ? AND part_count = ?", upload.ID, upload.Offset, upload.PartCount).
+		Updates(map[string]interface{}{
+			"offset":        offset,
+			"pending_bytes": pendingBytes,
+			"part_count":    partCount,
+		})
+
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUploadOffsetMismatch
+	}
+
+	upload.Offset = offset
+	upload.PendingBytes = pendingBytes
+	upload.PartCount = partCount
+	return nil
+}
+
+func (svc *Service) finishUpload(
+	ctx context.Context,
+	upload *Upload,
+) (*Node, error) {
+	bucket := svc.Cfg.Storage.BucketName
+
+	var parts []UploadPart
+	err := svc.DB.WithContext(ctx).
+		Where("upload_id = ?", upload.ID).
+		Order("part_number").
+		Find(&parts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	completedParts := make([]shared.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completedParts = append(completedParts, shared.CompletedPart{
+			PartNumber: part.PartNumber,
+			ETag:       part.ETag,
+		})
+	}
+
+	err = svc.Client.CompleteMultipartUpload(ctx, bucket, upload.Key, upload.MultipartID, completedParts)
+	if err != nil {
+		return nil, err
+	}
+
+	mimeType, hash, err := svc.hashObject(ctx, upload.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	size := upload.Length
+	node := Node{
+		ID:        uuid.New(),
+		OwnerID:   upload.OwnerID,
+		ParentID:  upload.ParentID,
+		CreatedAt: time.Now(),
+		SizeBytes: &size,
+		MimeType:  &mimeType,
+		Type:      NodeTypeFile,
+		Name:      upload.Name,
+	}
+
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The client can't be asked what to do after the last byte arrived
+		name, _, err := resolveNameConflict(tx, node.ParentID, node.OwnerID, node.Name, NodeTypeFile, node.ID, ConflictRename)
+		if err != nil {
+			return err
+		}
+		node.Name = name
+
+		key, err := acquireBlob(tx, hash, upload.Key, size)
+		if err != nil {
+			return err
+		}
+		node.Key = &key
+
+		if err := svc.chargeUsage(tx, node.OwnerID, int64(size)); err != nil {
+			return err
+		}
+		if err := svc.chargeWorkspaceUsage(tx, node.ParentID, int64(size)); err != nil {
+			return err
+		}
+		if err := tx.Create(&node).Error; err != nil {
+			return translateNameError(err)
+		}
+		if err := tx.Where("upload_id = ?", upload.ID).Delete(&UploadPart{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&Upload{}, "id = ?", upload.ID).Error; err != nil {
+			return err
+		}
+		return svc.relateNodes(ctx, tx, &node)
+	})
+
+	if err != nil || *node.Key != upload.Key {
+		svc.deleteObjects(upload.Key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &node, nil
+}
+
+func (svc *Service) TerminateUpload(
+	ctx context.Context,
+	UploadID uuid.UUID,
+	UserID uint64,
+) error {
+	upload, err := svc.GetUpload(ctx, UploadID, UserID)
+	if err != nil {
+		return err
+	}
+
+	bucket := svc.Cfg.Storage.BucketName
+	if err := svc.Client.AbortMultipartUpload(ctx, bucket, upload.Key, upload.MultipartID); err != nil {
+		return err
+	}
+	if upload.PendingBytes > 0 {
+		_ = svc.Client.Delete(ctx, bucket, pendingPartKey(upload.Key))
+	}
+
+	return svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("upload_id = ?", upload.ID).Delete(&UploadPart{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Upload{}, "id = ?", upload.ID).Error
+	})
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+
+	headerTusResumable   = "Tus-Resumable"
+	headerTusVersion     = "Tus-Version"
+	headerTusExtension   = "Tus-Extension"
+	headerUploadOffset   = "Upload-Offset"
+	headerUploadLength   = "Upload-Length"
+	headerUploadMetadata = "Upload-Metadata"
+
+	tusPatchContentType = "application/offset+octet-stream"
+)
+
+func tusResumableSupported(c echo.Context) bool {
+	c.Response().Header().Set(headerTusResumable, tusVersion)
+	if c.Request().Header.Get(headerTusResumable) != tusVersion {
+		c.Response().Header().Set(headerTusVersion, tusVersion)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) UploadOptions(c echo.Context) error {
+	c.Response().Header().Set(headerTusResumable, tusVersion)
+	c.Response().Header().Set(headerTusVersion, tusVersion)
+	c.Response().Header().Set(headerTusExtension, tusExtensions)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) CreateUpload(c echo.Context) error {
+	if !tusResumableSupported(c) {
+		return c.NoContent(http.StatusPreconditionFailed)
+	}
+	ctx := c.Request().Context()
+
+	length, err := strconv.ParseUint(c.Request().Header.Get(headerUploadLength), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "missing or invalid Upload-Length header")
+	}
+
+	metadata, err := parseUploadMetadata(c.Request().Header.Get(headerUploadMetadata))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid Upload-Metadata header")
+	}
+
+	filename := metadata["filename"]
+	if filename == "" {
+		return c.JSON(http.StatusBadRequest, "missing filename in Upload-Metadata")
+	}
+
+	parentId, err := uuid.Parse(metadata["parent_id"])
+	if err != nil {
+		parentId = uuid.Nil
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	upload, err := h.svc.CreateUpload(ctx, user.ID, parentId, filename, length)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error creating upload")
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, c.Request().URL.Path+"/"+upload.ID.String())
+	return c.NoContent(http.StatusCreated)
+}
+
+func (h *Handler) UploadStatus(c echo.Context) error {
+	if !tusResumableSupported(c) {
+		return c.NoContent(http.StatusPreconditionFailed)
+	}
+	ctx := c.Request().Context()
+
+	uploadId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	upload, err := h.svc.GetUpload(ctx, uploadId, user.ID)
+	if err != nil {
+		return c.NoContent(errorStatus(err))
+	}
+
+	c.Response().Header().Set(headerUploadOffset, strconv.FormatUint(upload.Offset, 10))
+	c.Response().Header().Set(headerUploadLength, strconv.FormatUint(upload.Length, 10))
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *Handler) WriteUpload(c echo.Context) error {
+	if !tusResumableSupported(c) {
+		return c.NoContent(http.StatusPreconditionFailed)
+	}
+	ctx := c.Request().Context()
+
+	if c.Request().Header.Get(echo.HeaderContentType) != tusPatchContentType {
+		return c.JSON(http.StatusUnsupportedMediaType, "content type must be "+tusPatchContentType)
+	}
+
+	offset, err := strconv.ParseUint(c.Request().Header.Get(headerUploadOffset), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "missing or invalid Upload-Offset header")
+	}
+
+	uploadId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "upload not found")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	upload, _, err := h.svc.WriteUpload(ctx, uploadId, user.ID, offset, c.Request().Body)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error writing upload chunk")
+	}
+
+	c.Response().Header().Set(headerUploadOffset, strconv.FormatUint(upload.Offset, 10))
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) TerminateUpload(c echo.Context) error {
+	if !tusResumableSupported(c) {
+		return c.NoContent(http.StatusPreconditionFailed)
+	}
+	ctx := c.Request().Context()
+
+	uploadId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "upload not found")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	if err := h.svc.TerminateUpload(ctx, uploadId, user.ID); err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error terminating upload")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -4,19 +4,97 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
-func AttachRoutes(e *echo.Echo, svc StorageService, jwtMiddleware echo.MiddlewareFunc){
+func AttachRoutes(e *echo.Echo, svc StorageService, jwtMiddleware echo.MiddlewareFunc, anonymousMiddleware echo.MiddlewareFunc, internalMiddleware echo.MiddlewareFunc){
 	handler := NewHandler(svc)
 	api := e.Group("/api")
 	internalApi := e.Group("/internal")
+	publicApi := e.Group("/public")
 	api.Use(jwtMiddleware)
 	api.POST("/upload", handler.Upload)
+	api.POST("/upload/extract", handler.UploadArchive)
+	api.POST("/upload/initiate", handler.InitiateUpload)
+	api.POST("/upload/complete", handler.CompleteUpload)
 	api.POST("/download", handler.Download)
+	api.GET("/download/:id", handler.StreamDownload)
+	api.HEAD("/download/:id", handler.StreamDownload)
+	api.POST("/download/archive", handler.DownloadArchive)
 	api.POST("/list", handler.List)
+	api.GET("/search", handler.Search)
 	api.POST("/mkdir", handler.CreateDirectoryNode)
 	api.POST("/copy", handler.Copy)
+	api.GET("/copy/:id", handler.CopyStatus)
 	api.POST("/move", handler.Move)
+	api.POST("/rename", handler.Rename)
 	api.POST("/delete", handler.Delete)
+	api.GET("/versions/:id", handler.ListVersions)
+	api.GET("/versions/:id/:version", handler.DownloadVersion)
+	api.POST("/versions/upload", handler.UploadVersion)
+	api.POST("/versions/restore", handler.RestoreVersion)
+	api.GET("/usage", handler.Usage)
+	api.GET("/trash", handler.ListTrash)
+	api.POST("/trash/restore", handler.RestoreTrash)
+	api.POST("/trash/purge", handler.PurgeTrash)
+	api.POST("/trash/empty", handler.EmptyTrash)
+	api.POST("/shares", handler.CreateShareLink)
+	api.GET("/shares", handler.ListShareLinks)
+	api.POST("/shares/revoke", handler.RevokeShareLink)
+	api.POST("/requests", handler.CreateFileRequest)
+	api.GET("/requests", handler.ListFileRequests)
+	api.GET("/requests/:id/uploads", handler.ListFileRequestUploads)
+	api.POST("/requests/close", handler.CloseFileRequest)
+	api.POST("/access", handler.GrantAccess)
+	api.GET("/access/:id", handler.ListAccess)
+	api.POST("/access/update", handler.UpdateAccess)
+	api.POST("/access/revoke", handler.RevokeAccess)
+	api.POST("/access/groups", handler.GrantGroupAccess)
+	api.POST("/access/groups/revoke", handler.RevokeGroupAccess)
+	api.GET("/shared", handler.SharedWithMe)
+	api.POST("/visibility", handler.SetVisibility)
+	api.POST("/workspaces", handler.CreateWorkspace)
+	api.GET("/workspaces", handler.ListWorkspaces)
+	api.POST("/workspaces/rename", handler.RenameWorkspace)
+	api.POST("/workspaces/delete", handler.DeleteWorkspace)
+	api.GET("/workspaces/:id/members", handler.ListWorkspaceMembers)
+	api.POST("/workspaces/members", handler.AddWorkspaceMember)
+	api.POST("/workspaces/members/remove", handler.RemoveWorkspaceMember)
+	api.POST("/workspaces/groups", handler.AddWorkspaceGroup)
+	api.POST("/workspaces/groups/remove", handler.RemoveWorkspaceGroup)
+	api.GET("/workspaces/:id/usage", handler.WorkspaceUsage)
+	api.POST("/groups", handler.CreateGroup)
+	api.GET("/groups", handler.ListGroups)
+	api.POST("/groups/delete", handler.DeleteGroup)
+	api.GET("/groups/:id/members", handler.ListGroupMembers)
+	api.POST("/groups/members", handler.AddGroupMember)
+	api.POST("/groups/members/remove", handler.RemoveGroupMember)
+
+	// Resumable uploads (tus 1.0)
+	uploads := api.Group("/uploads")
+	uploads.OPTIONS("", handler.UploadOptions)
+	uploads.POST("", handler.CreateUpload)
+	uploads.HEAD("/:id", handler.UploadStatus)
+	uploads.PATCH("/:id", handler.WriteUpload)
+	uploads.DELETE("/:id", handler.TerminateUpload)
+
+	// Share links, no JWT required
+	publicApi.GET("/shares/:token", handler.OpenShare)
+	publicApi.GET("/shares/:token/list", handler.ListShare)
+	publicApi.GET("/shares/:token/download", handler.DownloadShare)
+	publicApi.POST("/shares/:token/upload", handler.UploadShare)
+
+	// Nodes visible to everyone, the token is optional
+	publicNodes := publicApi.Group("/nodes", anonymousMiddleware)
+	publicNodes.GET("", handler.ListPublicNodes)
+	publicNodes.GET("/:id", handler.PublicNode)
+	publicNodes.GET("/:id/children", handler.PublicChildren)
+	publicNodes.GET("/:id/download", handler.StreamDownload)
+	publicNodes.HEAD("/:id/download", handler.StreamDownload)
+
+	// File requests, upload only
+	publicApi.GET("/requests/:token", handler.OpenFileRequest)
+	publicApi.POST("/requests/:token/upload", handler.UploadFileRequest)
 
 	// Internal API methods
 	internalApi.GET("/policy", handler.GeneratePostUploadPolicy)
+	internalApi.POST("/quota", handler.SetQuota, internalMiddleware)
+	internalApi.POST("/workspaces/quota", handler.SetWorkspaceQuota, internalMiddleware)
 }
\ No newline at end of file
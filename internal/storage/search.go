@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"context"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+)
+
+// Matches are marked with control characters, which extracted text never
+// contains, so the snippet can be HTML escaped before <mark> goes in.
+const snippetOptions = "StartSel=\x02, StopSel=\x03, MaxFragments=2, MaxWords=24, MinWords=8, FragmentDelimiter=\" ... \""
+
+var snippetMarker = strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>")
+
+func highlightSnippet(snippet *string) string {
+	if snippet == nil {
+		return ""
+	}
+	return snippetMarker.Replace(html.EscapeString(*snippet))
+}
+
+// Matches looked at per query and bulk check
+const searchBatchSize = 500
+
+type searchRow struct {
+	Node
+	Score   float64
+	Snippet *string
+}
+
+func (svc *Service) Search(
+	ctx context.Context,
+	UserID uint64,
+	Query SearchQuery,
+) (*SearchResults, error) {
+	limit := Query.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	} else if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	offset := Query.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	db := svc.DB.WithContext(ctx).
+		Table("nodes").
+		Where("nodes.status = ?", NodeStatusActive)
+
+	// Name similarity and content rank add up to the score
+	score := "0"
+	var scoreArgs []interface{}
+
+	term := strings.TrimSpace(Query.Term)
+	if term != "" {
+		escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+		score = "GREATEST(similarity(nodes.name, ?), word_similarity(?, nodes.name))"
+		scoreArgs = append(scoreArgs, term, term)
+		db = db.Where("nodes.name % ? OR nodes.name ILIKE ?", term, "%"+escaper.Replace(term)+"%")
+	}
+
+	content := strings.TrimSpace(Query.Content)
+	if content != "" {
+		score += " + ts_rank(document_texts.document, websearch_to_tsquery('english', ?))"
+		scoreArgs = append(scoreArgs, content)
+		db = db.
+			Joins("JOIN document_texts ON document_texts.key = nodes.key").
+			Where("document_texts.document @@ websearch_to_tsquery('english', ?)", content).
+			Select("nodes.*, "+score+" AS score, ts_headline('english', document_texts.content, websearch_to_tsquery('english', ?), ?) AS snippet",
+				append(scoreArgs, content, snippetOptions)...)
+	} else {
+		db = db.Select("nodes.*, "+score+" AS score", scoreArgs...)
+	}
+
+	if term != "" || content != "" {
+		db = db.Order("score DESC")
+	} else {
+		db = db.Order("nodes.created_at DESC")
+	}
+
+	if Query.Type != "" {
+		db = db.Where("nodes.type = ?", Query.Type)
+	}
+	if Query.MimeType != "" {
+		// "image/" matches every image, anything else has to match exactly
+		if strings.HasSuffix(Query.MimeType, "/") {
+			db = db.Where("nodes.mime_type LIKE ?", Query.MimeType+"%")
+		} else {
+			db = db.Where("nodes.mime_type = ?", Query.MimeType)
+		}
+	}
+	if Query.MinSize != nil {
+		db = db.Where("nodes.size_bytes >= ?", *Query.MinSize)
+	}
+	if Query.MaxSize != nil {
+		db = db.Where("nodes.size_bytes <= ?", *Query.MaxSize)
+	}
+	if Query.CreatedAfter != nil {
+		db = db.Where("nodes.created_at >= ?", *Query.CreatedAfter)
+	}
+	if Query.CreatedBefore != nil {
+		db = db.Where("nodes.created_at < ?", *Query.CreatedBefore)
+	}
+	if Query.OwnerID != nil {
+		db = db.Where("nodes.owner_id = ?", *Query.OwnerID)
+	}
+	if Query.FolderID != uuid.Nil {
+		db = db.Where(`nodes.id IN (
+			WITH RECURSIVE scope AS (
+				SELECT id FROM nodes WHERE parent_id = ?
+
+				UNION ALL
+
+				SELECT n.id FROM nodes n JOIN scope s ON n.parent_id = s.id
+			)
+			SELECT id FROM scope
+		)`, Query.FolderID)
+	}
+
+	// Access is confirmed with bulk checks rather than in the query, so
+	// matches are read in batches until the page is full. One extra row
+	// tells whether there is another page.
+	db = db.Order("nodes.name").Order("nodes.id").Session(&gorm.Session{})
+	var rows []searchRow
+	skipped := 0
+	for scanned := 0; len(rows) <= limit; scanned += searchBatchSize {
+		var batch []searchRow
+		err := db.Limit(searchBatchSize).Offset(scanned).Scan(&batch).Error
+		if err != nil {
+			return nil, err
+		}
+		readable, err := svc.searchable(ctx, batch, UserID)
+		if err != nil {
+			return nil, err
+		}
+		for i, row := range batch {
+			if !readable[i] {
+				continue
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			rows = append(rows, row)
+			if len(rows) > limit {
+				break
+			}
+		}
+		if len(batch) < searchBatchSize {
+			break
+		}
+	}
+
+	results := SearchResults{
+		Hits:   []SearchHit{},
+		Offset: offset,
+		Limit:  limit,
+	}
+	if len(rows) > limit {
+		results.HasMore = true
+		rows = rows[:limit]
+	}
+	if len(rows) == 0 {
+		return &results, nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+	breadcrumbs, err := svc.getBreadcrumbs(ctx, ids, UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		crumbs := breadcrumbs[row.ID]
+		names := make([]string, 0, len(crumbs)+1)
+		for _, crumb := range crumbs {
+			names = append(names, crumb.Name)
+		}
+		names = append(names, row.Name)
+
+		results.Hits = append(results.Hits, SearchHit{
+			Node:        row.Node,
+			Score:       row.Score,
+			Path:        "/" + strings.Join(names, "/"),
+			Breadcrumbs: crumbs,
+			Snippet:     highlightSnippet(row.Snippet),
+		})
+	}
+	return &results, nil
+}
+
+// searchable tells which rows the user may find: the ones they own, and the
+// ones they can read that aren't open to everyone. Nodes anyone can view
+// are left out, so link-only ones aren't revealed through search.
+func (svc *Service) searchable(
+	ctx context.Context,
+	rows []searchRow,
+	UserID uint64,
+) ([]bool, error) {
+	var zedToken string
+	var freshest *time.Time
+	checks := make([]authorization.PermissionCheck, 0, 2*len(rows))
+	for _, row := range rows {
+		if row.OwnerID == UserID {
+			continue
+		}
+		if row.ZedToken != nil && row.ZedTokenAt != nil && (freshest == nil || row.ZedTokenAt.After(*freshest)) {
+			zedToken, freshest = *row.ZedToken, row.ZedTokenAt
+		}
+		checks = append(checks,
+			readCheck(UserID, row.ID),
+			readCheck(authentication.AnonymousID, row.ID),
+		)
+	}
+	allowed, err := svc.Authz.CheckBulkPermissions(ctx, checks, zedToken)
+	if err != nil {
+		return nil, err
+	}
+
+	readable := make([]bool, len(rows))
+	next := 0
+	for i, row := range rows {
+		if row.OwnerID == UserID {
+			readable[i] = true
+			continue
+		}
+		readable[i] = allowed[next] && !allowed[next+1]
+		next += 2
+	}
+	return readable, nil
+}
+
+// getBreadcrumbs returns the ancestors of every node in NodeIDs, root first.
+// For nodes shared with the user the trail stops below the first ancestor
+// they can't read, so nothing above what was shared is revealed.
+func (svc *Service) getBreadcrumbs(
+	ctx context.Context,
+	NodeIDs []uuid.UUID,
+	UserID uint64,
+) (map[uuid.UUID][]Breadcrumb, error) {
+	var chain []struct {
+		HitID      uuid.UUID
+		ID         uuid.UUID
+		Name       string
+		OwnerID    uint64
+		ZedToken   *string
+		ZedTokenAt *time.Time
+		Depth      int
+	}
+	err := svc.DB.WithContext(ctx).
+		Raw(`
+		WITH RECURSIVE chain AS (
+		SELECT id AS hit_id, id, parent_id, name, owner_id, zed_token, zed_token_at, 0 AS depth
+		FROM nodes WHERE id IN ?
+
+		UNION ALL
+
+		SELECT c.hit_id, n.id, n.parent_id, n.name, n.owner_id, n.zed_token, n.zed_token_at, c.depth + 1
+		FROM nodes n JOIN chain c ON n.id = c.parent_id
+		)
+		SELECT * FROM chain ORDER BY hit_id, depth;
+	`, NodeIDs).
+		Scan(&chain).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Ancestors someone else owns are checked all at once
+	var zedToken string
+	var freshest *time.Time
+	var ancestors []uuid.UUID
+	seen := make(map[uuid.UUID]bool)
+	for _, link := range chain {
+		if link.ZedToken != nil && link.ZedTokenAt != nil && (freshest == nil || link.ZedTokenAt.After(*freshest)) {
+			zedToken, freshest = *link.ZedToken, link.ZedTokenAt
+		}
+		if link.Depth > 0 && link.OwnerID != UserID && !seen[link.ID] {
+			seen[link.ID] = true
+			ancestors = append(ancestors, link.ID)
+		}
+	}
+	checks := make([]authorization.PermissionCheck, 0, len(ancestors))
+	for _, id := range ancestors {
+		checks = append(checks, readCheck(UserID, id))
+	}
+	allowed, err := svc.Authz.CheckBulkPermissions(ctx, checks, zedToken)
+	if err != nil {
+		return nil, err
+	}
+	readable := make(map[uuid.UUID]bool, len(ancestors))
+	for i, id := range ancestors {
+		readable[id] = allowed[i]
+	}
+
+	breadcrumbs := make(map[uuid.UUID][]Breadcrumb, len(NodeIDs))
+	stopped := make(map[uuid.UUID]bool, len(NodeIDs))
+	for _, link := range chain {
+		// Walking up from the hit, the hit itself is not part of its trail
+		if stopped[link.HitID] || link.Depth == 0 {
+			continue
+		}
+		if link.OwnerID != UserID && !readable[link.ID] {
+			stopped[link.HitID] = true
+			continue
+		}
+		breadcrumbs[link.HitID] = append(
+			[]Breadcrumb{{ID: link.ID, Name: link.Name}},
+			breadcrumbs[link.HitID]...,
+		)
+	}
+	return breadcrumbs, nil
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+)
+
+func parseOptionalTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (h *Handler) Search(c echo.Context) error {
+	var req Search
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid search parameters")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	query := SearchQuery{
+		Term:     req.Query,
+		Content:  req.Content,
+		MimeType: req.MimeType,
+		MinSize:  req.MinSize,
+		MaxSize:  req.MaxSize,
+		OwnerID:  req.OwnerID,
+		Limit:    req.Limit,
+		Offset:   req.Offset,
+	}
+
+	switch NodeType(req.Type) {
+	case "", NodeTypeFile, NodeTypeDirectory:
+		query.Type = NodeType(req.Type)
+	default:
+		return c.JSON(http.StatusBadRequest, "invalid type param")
+	}
+
+	var err error
+	if query.CreatedAfter, err = parseOptionalTime(req.CreatedAfter); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid created_after param")
+	}
+	if query.CreatedBefore, err = parseOptionalTime(req.CreatedBefore); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid created_before param")
+	}
+	if req.FolderID != "" {
+		if query.FolderID, err = uuid.Parse(req.FolderID); err != nil {
+			return c.JSON(http.StatusBadRequest, "invalid folder param")
+		}
+	}
+
+	results, err := h.svc.Search(ctx, user.ID, query)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error searching nodes")
+	}
+	return c.JSON(http.StatusOK, results)
+}
@@ -3,18 +3,22 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/url"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
 	"github.com/sirkartik/cloud_drive_2.0/internal/config"
 	"github.com/sirkartik/cloud_drive_2.0/internal/shared"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func NewService(DB *gorm.DB, storageClient shared.ObjectStorage, Cfg config.Config) *Service {
+func NewService(DB *gorm.DB, storageClient shared.ObjectStorage, Cfg config.Config, Authz authorization.Authorizer) (*Service, error) {
 	DB.AutoMigrate(&Node{})
 	var count int64
 	DB.Raw(`
@@ -36,14 +40,118 @@ func NewService(DB *gorm.DB, storageClient shared.ObjectStorage, Cfg config.Conf
 			log.Printf("Failed to create owner foreign key: %v", err)
 		}
 	}
-	DB.AutoMigrate(&NodePermission{})
+	if err := migrateNodePermissions(DB); err != nil {
+		return nil, err
+	}
+
+	// Sibling names are unique. Root nodes have no parent so they are
+	// unique per owner instead, workspace roots aside. Trashed nodes don't
+	// hold on to their names.
+	if err := renameDuplicateSiblings(DB); err != nil {
+		return nil, fmt.Errorf("renaming duplicate node names: %w", err)
+	}
+	for _, index := range []string{
+		`DROP INDEX IF EXISTS idx_nodes_parent_name`,
+		`DROP INDEX IF EXISTS idx_nodes_root_name`,
+		`DROP INDEX IF EXISTS idx_nodes_live_root_name`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_nodes_live_parent_name ON nodes (parent_id, name) WHERE parent_id IS NOT NULL AND status <> 'trashed'`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_nodes_live_personal_root_name ON nodes (owner_id, name) WHERE parent_id IS NULL AND workspace_id IS NULL AND status <> 'trashed'`,
+	} {
+		if err := DB.Exec(index).Error; err != nil {
+			return nil, fmt.Errorf("creating node name index: %w", err)
+		}
+	}
+	DB.AutoMigrate(&Upload{}, &UploadPart{})
+	DB.AutoMigrate(&DirectUpload{})
+	DB.AutoMigrate(&CopyJob{})
+	DB.AutoMigrate(&TrashItem{})
+	DB.AutoMigrate(&FileVersion{})
+	DB.AutoMigrate(&Blob{})
+	DB.AutoMigrate(&StorageUsage{})
+	DB.AutoMigrate(&ShareLink{})
+	DB.AutoMigrate(&FileRequest{}, &FileRequestUpload{})
+	DB.AutoMigrate(&Workspace{}, &WorkspaceMembership{}, &WorkspaceGroupMembership{})
+	DB.AutoMigrate(&Group{}, &GroupMembership{}, &NestedGroup{}, &NodeGroupPermission{})
+	DB.AutoMigrate(&UserZedToken{})
+
+	// Fuzzy name search
+	for _, statement := range []string{
+		`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+		`CREATE INDEX IF NOT EXISTS idx_nodes_name_trgm ON nodes USING gin (name gin_trgm_ops)`,
+	} {
+		if err := DB.Exec(statement).Error; err != nil {
+			log.Printf("Failed to set up name search: %v", err)
+		}
+	}
+
+	// Full-text content search
+	DB.AutoMigrate(&DocumentText{})
+	for _, statement := range []string{
+		`ALTER TABLE document_texts ADD COLUMN IF NOT EXISTS document tsvector GENERATED ALWAYS AS (to_tsvector('english', content)) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_document_texts_document ON document_texts USING gin (document)`,
+	} {
+		if err := DB.Exec(statement).Error; err != nil {
+			log.Printf("Failed to set up content search: %v", err)
+		}
+	}
+
+	// Background jobs don't survive a restart
+	DB.Model(&CopyJob{}).
+		Where("status = ?", JobStatusRunning).
+		Updates(map[string]interface{}{
+			"status": JobStatusFailed,
+			"error":  "interrupted by server restart",
+		})
 	return &Service{
 		DB:     DB,
 		Client: storageClient,
 		Cfg:    Cfg,
+		Authz:  Authz,
+	}, nil
+}
+
+// NewMigrationService sets up a service over the tables as they are, for
+// tools that run next to a live server. Unlike NewService it migrates,
+// cleans up and fails nothing, and it has no object storage.
+func NewMigrationService(DB *gorm.DB, Cfg config.Config, Authz authorization.Authorizer) *Service {
+	return &Service{
+		DB:    DB,
+		Cfg:   Cfg,
+		Authz: Authz,
 	}
 }
 
+// migrateNodePermissions keeps a single grant per user and node, the
+// strongest, before the unique index over them is created. Grants used to be
+// inserted without a check for an existing one.
+func migrateNodePermissions(DB *gorm.DB) error {
+	if DB.Migrator().HasTable(&NodePermission{}) {
+		err := DB.Exec(`
+		DELETE FROM node_permissions p
+		USING (
+			SELECT id, row_number() OVER (
+				PARTITION BY node_id, user_id
+				ORDER BY CASE
+					WHEN type IN (?, 7) THEN 3
+					WHEN type IN (?, 5) THEN 2
+					WHEN type = ? THEN 1
+					ELSE 0
+				END DESC, id DESC
+			) AS rank
+			FROM node_permissions
+		) ranked
+		WHERE p.id = ranked.id AND ranked.rank > 1;
+		`, PermissionExecute, PermissionWrite, PermissionRead).Error
+		if err != nil {
+			return fmt.Errorf("deduplicating node permissions: %w", err)
+		}
+	}
+	if err := DB.AutoMigrate(&NodePermission{}); err != nil {
+		return fmt.Errorf("migrating node permissions: %w", err)
+	}
+	return nil
+}
+
 func (svc *Service) GetNode(
 	ctx context.Context,
 	ID uuid.UUID,
@@ -67,23 +175,12 @@ func (svc *Service) canWriteIntoDirectory(
 		return err
 	}
 
-	if node.Type != NodeTypeDirectory {
+	if node.Status != NodeStatusActive {
+		return ErrParentNodeNotFound
+	} else if node.Type != NodeTypeDirectory {
 		return ErrNodeIsFile
-	} else if node.OwnerID != UserID {
-		var permission NodePermission
-
-		err = svc.DB.
-			Where("user_id = ?", UserID).
-			First(&permission).
-			Error
-		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) || (permission.Type != 2 && permission.Type != 5 && permission.Type != 7) {
-				return ErrUnauthorized
-			}
-			return err
-		}
 	}
-	return nil
+	return svc.checkPermission(ctx, node, UserID, authorization.PermissionWrite)
 }
 
 func (svc *Service) checkNodeDeliverability(
@@ -91,19 +188,12 @@ func (svc *Service) checkNodeDeliverability(
 	node *Node,
 	UserID uint64,
 ) error {
-	err := svc.DB.Model(&NodePermission{}).
-		Where("user_id = ?", UserID).
-		Error
-
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) && UserID != node.OwnerID {
-			return ErrUnauthorized
-		}
-		return err
-	} else if node.Type != NodeTypeFile {
+	if node.Type != NodeTypeFile {
 		return ErrNodeIsDirectory
+	} else if node.Status != NodeStatusActive {
+		return ErrNodeNotFound
 	}
-	return nil
+	return svc.checkPermission(ctx, node, UserID, authorization.PermissionRead)
 }
 
 func (svc *Service) DetectMimeType(
@@ -122,9 +212,14 @@ func (svc *Service) Put(ctx context.Context,
 	Bytes uint64,
 	data io.ReadCloser,
 	mimeType string,
+	Strategy ConflictStrategy,
 ) (*Node, error) {
 	defer data.Close()
 
+	if err := validateNodeName(Name); err != nil {
+		return nil, err
+	}
+
 	var parentID *uuid.UUID
 
 	if ParentID != uuid.Nil {
@@ -136,12 +231,46 @@ func (svc *Service) Put(ctx context.Context,
 			return nil, err
 		}
 	}
-	key := uuid.NewString()
+	if err := svc.checkQuota(ctx, UserID, Bytes); err != nil {
+		return nil, err
+	}
+	if err := svc.checkWorkspaceQuota(ctx, ParentID, Bytes); err != nil {
+		return nil, err
+	}
+
+	uploadedKey, hash, err := svc.storeObject(ctx, data, Bytes)
+	if err != nil {
+		return nil, err
+	}
 	nodeID := uuid.New()
 
 	var createdNode *Node
+	var staleKeys []string
 
-	err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		name, existing, err := resolveNameConflict(tx, parentID, UserID, Name, NodeTypeFile, nodeID, Strategy)
+		if err != nil {
+			return err
+		}
+
+		// Identical content is already stored, point at that object instead
+		key, err := acquireBlob(tx, hash, uploadedKey, Bytes)
+		if err != nil {
+			return err
+		}
+		if key != uploadedKey {
+			staleKeys = append(staleKeys, uploadedKey)
+		}
+
+		if existing != nil {
+			orphaned, err := svc.replaceContent(tx, existing, key, &Bytes, &mimeType, UserID)
+			if err != nil {
+				return err
+			}
+			staleKeys = append(staleKeys, orphaned...)
+			createdNode = existing
+			return nil
+		}
 
 		node := Node{
 			ID:        nodeID,
@@ -152,26 +281,31 @@ func (svc *Service) Put(ctx context.Context,
 			SizeBytes: &Bytes,
 			MimeType:  &mimeType,
 			Type:      NodeTypeFile,
-			Name:      Name,
+			Name:      name,
 		}
 
-		result := tx.Create(&node)
-		if result.Error != nil {
-			return result.Error
+		if err := svc.chargeUsage(tx, UserID, int64(Bytes)); err != nil {
+			return err
 		}
-		err := svc.Client.Put(ctx, svc.Cfg.Storage.BucketName, key, data, int64(Bytes))
-		if err != nil {
+		if err := svc.chargeWorkspaceUsage(tx, parentID, int64(Bytes)); err != nil {
+			return err
+		}
+		if err := tx.Create(&node).Error; err != nil {
+			return translateNameError(err)
+		}
+		if err := svc.relateNodes(ctx, tx, &node); err != nil {
 			return err
 		}
-
 		createdNode = &node
 		return nil
 	})
 
 	if err != nil {
+		svc.deleteObjects(uploadedKey)
 		return nil, err
 	}
 
+	svc.deleteObjects(staleKeys...)
 	return createdNode, nil
 }
 
@@ -195,6 +329,50 @@ func (svc *Service) GetData(
 	return stream, node, err
 }
 
+// StatData returns the node along with the stored object's metadata so
+// callers can evaluate conditional and range requests before reading.
+func (svc *Service) StatData(
+	ctx context.Context,
+	NodeID uuid.UUID,
+	UserID uint64,
+) (*Node, *shared.ObjectInfo, error) {
+	node, err := svc.GetNode(ctx, NodeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = svc.checkNodeDeliverability(ctx, node, UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := svc.Client.Stat(ctx, svc.Cfg.Storage.BucketName, *node.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return node, info, nil
+}
+
+func (svc *Service) GetDataRange(
+	ctx context.Context,
+	NodeID uuid.UUID,
+	UserID uint64,
+	Offset int64,
+	Length int64,
+) (io.ReadCloser, *Node, error) {
+	node, err := svc.GetNode(ctx, NodeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = svc.checkNodeDeliverability(ctx, node, UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	stream, err := svc.Client.GetRange(ctx, svc.Cfg.Storage.BucketName, *node.Key, Offset, Length)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stream, node, nil
+}
+
 func (svc *Service) GeneratePresignedGetURL(
 	ctx context.Context,
 	key string,
@@ -206,30 +384,50 @@ func (svc *Service) GeneratePresignedGetURL(
 	)
 }
 
+// Delete moves a node and its subtree to the owner's trash, for anyone who
+// may write to it. Pending uploads have nothing worth restoring and are
+// removed right away by their uploader.
 func (svc *Service) Delete(
 	ctx context.Context,
 	NodeID uuid.UUID,
 	UserID uint64,
 ) error {
+	node, err := svc.GetNode(ctx, NodeID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNodeNotFound
+		}
+		return err
+	}
 
-	// Fetch all matching nodes
-	var nodes []Node
-	err := svc.DB.WithContext(ctx).
-		Raw(`
-		WITH RECURSIVE subtree AS (
-		SELECT * FROM nodes WHERE id = ?
-		AND owner_id = ?
-		
-		UNION ALL
-		
-		SELECT n.* FROM nodes n JOIN
-		subtree s ON n.parent_id = s.id
-		)
-	
-		SELECT * FROM subtree;
-	`, NodeID, UserID).
-		Scan(&nodes).Error
+	switch {
+	case node.WorkspaceID != nil:
+		return ErrWorkspaceRoot
+	case node.Status == NodeStatusPending:
+		if node.OwnerID != UserID {
+			return ErrNodeNotFound
+		}
+		return svc.purgeSubtree(ctx, NodeID, UserID)
+	case node.Status == NodeStatusTrashed:
+		return ErrNodeNotFound
+	}
+	if err := svc.checkPermission(ctx, node, UserID, authorization.PermissionWrite); err != nil {
+		return err
+	}
+
+	_, err = svc.moveToTrash(ctx, node)
+	return err
+}
+
+// purgeSubtree permanently removes a node, its descendants and their objects.
+func (svc *Service) purgeSubtree(
+	ctx context.Context,
+	NodeID uuid.UUID,
+	UserID uint64,
+) error {
 
+	// Fetch all matching nodes
+	nodes, err := svc.getSubtree(ctx, NodeID, UserID)
 	if err != nil {
 		return err
 	}
@@ -238,32 +436,103 @@ func (svc *Service) Delete(
 		return ErrNodeNotFound
 	}
 
-	// Deletion from object storage
-
+	nodeIDs := make([]uuid.UUID, 0, len(nodes))
+	keys := make([]string, 0, len(nodes))
+	keysByNode := make(map[uuid.UUID]string, len(nodes))
+	owners := make(map[uuid.UUID]uint64, len(nodes))
+	freed := make(map[uint64]int64)
+	var files int64
 	for _, item := range nodes {
-		if item.Key == nil {
-			continue
+		nodeIDs = append(nodeIDs, item.ID)
+		owners[item.ID] = item.OwnerID
+		if item.Key != nil {
+			keys = append(keys, *item.Key)
+			keysByNode[item.ID] = *item.Key
+		}
+		if item.Type == NodeTypeFile && item.SizeBytes != nil {
+			freed[item.OwnerID] += int64(*item.SizeBytes)
+			files += int64(*item.SizeBytes)
 		}
-		svc.Client.Delete(ctx, svc.Cfg.Storage.BucketName, *item.Key)
 	}
 
-	return svc.DB.WithContext(ctx).
-		Exec(`
-	WITH RECURSIVE subtree AS (
-		SELECT id
-		FROM nodes
-		WHERE id = ? AND owner_id = ?
+	var orphaned []string
+	var directUploads []DirectUpload
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Pending nodes may have a multipart upload holding parts
+		err := tx.Clauses(clause.Returning{}).
+			Where("node_id IN ?", nodeIDs).
+			Delete(&directUploads).Error
+		if err != nil {
+			return err
+		}
 
-		UNION ALL
+		var versions []FileVersion
+		err = tx.Where("node_id IN ?", nodeIDs).
+			Order("node_id, created_at DESC").
+			Find(&versions).Error
+		if err != nil {
+			return err
+		}
 
-		SELECT n.id
-		FROM nodes n
-		JOIN subtree s ON n.parent_id = s.id
-	)
-	DELETE FROM nodes
-	WHERE id IN (SELECT id FROM subtree);
-	`, NodeID, UserID).
-		Error
+		for i, version := range versions {
+			keys = append(keys, version.Key)
+			// The newest version of a file is the node's own content
+			if i > 0 && versions[i-1].NodeID == version.NodeID {
+				freed[owners[version.NodeID]] += int64(version.SizeBytes)
+			}
+		}
+
+		// Usage and references have to be settled before the rows go
+		for owner, size := range freed {
+			if err := svc.chargeUsage(tx, owner, -size); err != nil {
+				return err
+			}
+		}
+		if err := svc.chargeWorkspaceUsage(tx, &NodeID, -files); err != nil {
+			return err
+		}
+		orphaned, err = releaseBlobs(tx, keys)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("node_id IN ?", nodeIDs).Delete(&FileVersion{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("node_id IN ?", nodeIDs).Delete(&ShareLink{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("node_id IN ?", nodeIDs).Delete(&NodePermission{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("node_id IN ?", nodeIDs).Delete(&NodeGroupPermission{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("node_id IN ?", nodeIDs).Delete(&FileRequestUpload{}).Error; err != nil {
+			return err
+		}
+		if err := deleteFileRequests(tx, "directory_id IN ?", nodeIDs); err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", nodeIDs).Delete(&Node{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	svc.forgetNodes(ctx, nodeIDs...)
+
+	for _, directUpload := range directUploads {
+		if key, ok := keysByNode[directUpload.NodeID]; ok && directUpload.MultipartID != "" {
+			_ = svc.Client.AbortMultipartUpload(ctx, svc.Cfg.Storage.BucketName, key, directUpload.MultipartID)
+		}
+	}
+
+	// Deletion from object storage, only for objects nothing else shares
+	for _, key := range orphaned {
+		svc.Client.Delete(ctx, svc.Cfg.Storage.BucketName, key)
+	}
+	return nil
 }
 
 // No AUTH version for Get for Internal Services
@@ -283,6 +552,10 @@ func (svc *Service) GetDataNoAuth(
 	return stream, node, err
 }
 
+// ListNodes lists the user's own root nodes along with the top of what is
+// shared with them, or the children of a directory the user may read.
+// PermissionType is what the user can do with nodes they don't own, roles on
+// the directory carrying over to its children.
 func (svc *Service) ListNodes(
 	ctx context.Context,
 	ParentNodeID uuid.UUID,
@@ -293,17 +566,26 @@ func (svc *Service) ListNodes(
 
 	db := svc.DB.WithContext(ctx).
 		Table("nodes").
-		Select("nodes.*, node_permissions.type AS permission_type").
-		Joins(`
-			LEFT JOIN node_permissions 
-			ON node_permissions.node_id = nodes.id 
-			AND node_permissions.user_id = ?
-		`, UserID).
-		Where("nodes.owner_id = ? OR node_permissions.user_id = ?", UserID, UserID)
+		Where("nodes.status = ?", NodeStatusActive)
 
+	var parent *Node
 	if ParentNodeID == uuid.Nil {
-		db = db.Where("nodes.parent_id IS NULL")
+		db = db.Where("nodes.parent_id IS NULL AND nodes.owner_id = ? AND nodes.workspace_id IS NULL", UserID)
 	} else {
+		var err error
+		parent, err = svc.GetNode(ctx, ParentNodeID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrNodeNotFound
+			}
+			return nil, err
+		}
+		if parent.Status != NodeStatusActive {
+			return nil, ErrNodeNotFound
+		}
+		if err := svc.checkPermission(ctx, parent, UserID, authorization.PermissionRead); err != nil {
+			return nil, err
+		}
 		db = db.Where("nodes.parent_id = ?", ParentNodeID)
 	}
 
@@ -312,6 +594,49 @@ func (svc *Service) ListNodes(
 		return nil, err
 	}
 
+	var zedToken string
+	var freshest *time.Time
+	if parent == nil {
+		roots, err := svc.sharedRoots(ctx, UserID, "", math.MaxInt)
+		if err != nil {
+			return nil, err
+		}
+		for _, root := range roots {
+			nodeList = append(nodeList, NodeWithPermission{Node: root.node})
+		}
+	} else {
+		zedToken, freshest, err = svc.nodeZedToken(ctx, parent.ID, UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Every node someone else owns is checked, children may grant more
+	// than their parent
+	var children []*Node
+	var listed []*NodeWithPermission
+	for i := range nodeList {
+		node := &nodeList[i]
+		if node.OwnerID == UserID {
+			continue
+		}
+		children = append(children, &node.Node)
+		listed = append(listed, node)
+	}
+	// Children shared after the last write above them carry their own
+	for _, child := range children {
+		if child.ZedToken != nil && child.ZedTokenAt != nil && (freshest == nil || child.ZedTokenAt.After(*freshest)) {
+			zedToken, freshest = *child.ZedToken, child.ZedTokenAt
+		}
+	}
+	permissions, err := svc.effectivePermissions(ctx, children, UserID, zedToken)
+	if err != nil {
+		return nil, err
+	}
+	for i, node := range listed {
+		node.PermissionType = &permissions[i]
+	}
+
 	return nodeList, nil
 }
 
@@ -320,7 +645,12 @@ func (svc *Service) CreateDirectoryNode(
 	Name string,
 	ParentNodeID uuid.UUID,
 	OwnerID uint64,
-) error {
+	Strategy ConflictStrategy,
+) (*Node, error) {
+	if err := validateNodeName(Name); err != nil {
+		return nil, err
+	}
+
 	var parentId *uuid.UUID = nil
 	if ParentNodeID != uuid.Nil {
 		parentId = &ParentNodeID
@@ -334,19 +664,31 @@ func (svc *Service) CreateDirectoryNode(
 		Type:      NodeTypeDirectory,
 	}
 
-	return svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		if ParentNodeID != uuid.Nil {
-			tx = tx.Where("id = ? AND owner_id = ? AND type = 'directory'", ParentNodeID, OwnerID)
-			var parentNode Node
-			if err := tx.First(&parentNode).Error; err != nil {
-				return err
+	if ParentNodeID != uuid.Nil {
+		if err := svc.canWriteIntoDirectory(ctx, ParentNodeID, OwnerID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrParentNodeNotFound
 			}
+			return nil, err
 		}
-		if err := tx.Create(&node).Error; err != nil {
+	}
+
+	err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		name, _, err := resolveNameConflict(tx, parentId, OwnerID, Name, NodeTypeDirectory, node.ID, Strategy)
+		if err != nil {
 			return err
 		}
-		return nil
+		node.Name = name
+
+		if err := tx.Create(&node).Error; err != nil {
+			return translateNameError(err)
+		}
+		return svc.relateNodes(ctx, tx, &node)
 	})
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
 }
 
 func (svc *Service) Copy(
@@ -354,48 +696,48 @@ func (svc *Service) Copy(
 	TargetNodeID uuid.UUID,
 	DestinationID uuid.UUID,
 	OwnerID uint64,
-) error {
+	Strategy ConflictStrategy,
+) (*CopyJob, error) {
 	if TargetNodeID == uuid.Nil {
-		return errors.New("target node id can't be nil")
+		return nil, errors.New("target node id can't be nil")
+	}
+	if TargetNodeID == DestinationID {
+		return nil, errors.New("cannot copy node into itself")
 	}
 
 	db := svc.DB.WithContext(ctx)
 
 	var targetNode Node
-	if err := db.Where("id = ? AND owner_id = ?", TargetNodeID, OwnerID).
+	if err := db.Where("id = ? AND status = ?", TargetNodeID, NodeStatusActive).
 		First(&targetNode).Error; err != nil {
-		return err
+		return nil, err
 	}
-
-	if targetNode.Type == NodeTypeDirectory {
-		return errors.New("directory copy not supported")
+	if err := svc.checkPermission(ctx, &targetNode, OwnerID, authorization.PermissionRead); err != nil {
+		return nil, err
 	}
 
 	if DestinationID != uuid.Nil {
-		isDes, err := svc.isDescendant(ctx, TargetNodeID, DestinationID, OwnerID)
+		isDes, err := svc.isDescendant(ctx, TargetNodeID, DestinationID)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if isDes {
-			return errors.New("cannot copy node into its own subtree")
+			return nil, errors.New("cannot copy node into its own subtree")
 		}
 	}
 
 	if DestinationID != uuid.Nil {
 		var destinationNode Node
-		if err := db.Where("id = ? AND owner_id = ?", DestinationID, OwnerID).
+		if err := db.Where("id = ? AND status = ?", DestinationID, NodeStatusActive).
 			First(&destinationNode).Error; err != nil {
-			return err
+			return nil, err
 		}
 		if destinationNode.Type == NodeTypeFile {
-			return errors.New("cannot copy into a file")
+			return nil, errors.New("cannot copy into a file")
+		}
+		if err := svc.checkPermission(ctx, &destinationNode, OwnerID, authorization.PermissionWrite); err != nil {
+			return nil, err
 		}
-	}
-
-	newNodeKey := uuid.NewString()
-
-	if err := svc.Client.Copy(ctx, svc.Cfg.Storage.BucketName, *targetNode.Key, newNodeKey); err != nil {
-		return err
 	}
 
 	var destinationID *uuid.UUID
@@ -403,33 +745,112 @@ func (svc *Service) Copy(
 		destinationID = &DestinationID
 	}
 
+	name, existing, err := resolveNameConflict(db, destinationID, OwnerID, targetNode.Name, targetNode.Type, uuid.Nil, Strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	if targetNode.Type == NodeTypeDirectory {
+		return svc.copyDirectory(ctx, &targetNode, name, destinationID, OwnerID)
+	}
+
+	var size uint64
+	if targetNode.SizeBytes != nil {
+		size = *targetNode.SizeBytes
+	}
+	if err := svc.checkQuota(ctx, OwnerID, size); err != nil {
+		return nil, err
+	}
+	if err := svc.checkWorkspaceQuota(ctx, DestinationID, size); err != nil {
+		return nil, err
+	}
+
+	// Files share the object with the original, only metadata is written
+	if existing != nil {
+		var staleKeys []string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := retainBlob(tx, *targetNode.Key, 1); err != nil {
+				return err
+			}
+			var err error
+			staleKeys, err = svc.replaceContent(tx, existing, *targetNode.Key, targetNode.SizeBytes, targetNode.MimeType, OwnerID)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		svc.deleteObjects(staleKeys...)
+		return nil, nil
+	}
+
 	newNode := Node{
 		ID:        uuid.New(),
 		ParentID:  destinationID,
 		OwnerID:   OwnerID,
-		Name:      targetNode.Name,
+		Name:      name,
 		Type:      targetNode.Type,
-		Key:       &newNodeKey,
+		Key:       targetNode.Key,
 		SizeBytes: targetNode.SizeBytes,
 		MimeType:  targetNode.MimeType,
 		CreatedAt: time.Now(),
 	}
 
-	if err := svc.DB.WithContext(ctx).Create(&newNode).Error; err != nil {
-		cleanupCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		_ = svc.Client.Delete(cleanupCtx, svc.Cfg.Storage.BucketName, newNodeKey)
-		return err
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := retainBlob(tx, *targetNode.Key, 1); err != nil {
+			return err
+		}
+		if err := svc.chargeUsage(tx, OwnerID, int64(size)); err != nil {
+			return err
+		}
+		if err := svc.chargeWorkspaceUsage(tx, destinationID, int64(size)); err != nil {
+			return err
+		}
+		if err := tx.Create(&newNode).Error; err != nil {
+			return translateNameError(err)
+		}
+		return svc.relateNodes(ctx, tx, &newNode)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return nil, nil
+}
+
+// getSubtree returns the node owned by OwnerID along with all of its
+// descendants, parents always ordered before their children.
+func (svc *Service) getSubtree(
+	ctx context.Context,
+	NodeID uuid.UUID,
+	OwnerID uint64,
+) ([]Node, error) {
+	var nodes []Node
+	err := svc.DB.WithContext(ctx).
+		Raw(`
+		WITH RECURSIVE subtree AS (
+		SELECT nodes.*, 0 AS depth FROM nodes WHERE id = ?
+		AND owner_id = ?
+
+		UNION ALL
+
+		SELECT n.*, s.depth + 1 FROM nodes n JOIN
+		subtree s ON n.parent_id = s.id
+		)
+
+		SELECT * FROM subtree ORDER BY depth;
+	`, NodeID, OwnerID).
+		Scan(&nodes).Error
+
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
 }
 
 func (svc *Service) isDescendant(
 	ctx context.Context,
 	TargetNodeID uuid.UUID,
 	DestinationNodeID uuid.UUID,
-	OwnerID uint64,
 ) (bool, error) {
 	var found int = 0
 
@@ -438,7 +859,6 @@ func (svc *Service) isDescendant(
 		WITH RECURSIVE subtree AS (
 		SELECT id FROM public.nodes
 		WHERE parent_id = ?
-		AND owner_id = ?
 
 		UNION ALL
 
@@ -446,7 +866,7 @@ func (svc *Service) isDescendant(
 		FROM subtree s JOIN public.nodes n ON s.id = n.parent_id
 		)
 		SELECT 1 FROM subtree WHERE id = ? LIMIT 1;
-	`, TargetNodeID, OwnerID, DestinationNodeID).Scan(&found).Error
+	`, TargetNodeID, DestinationNodeID).Scan(&found).Error
 
 	if err != nil {
 		return false, err
@@ -455,44 +875,95 @@ func (svc *Service) isDescendant(
 	return found == 1, nil
 }
 
+// Move needs write access to the node and to the destination. Only the
+// owner can move a node to the root of their drive.
 func (svc *Service) Move(
 	ctx context.Context,
 	TargetNodeID uuid.UUID,
 	DestinationParentID uuid.UUID,
 	OwnerID uint64,
+	Strategy ConflictStrategy,
 ) error {
 	if TargetNodeID == uuid.Nil {
 		return errors.New("target node id can't be nil")
 	}
+
+	var targetNode Node
+	if err := svc.DB.WithContext(ctx).Where("id = ? AND status = ?", TargetNodeID, NodeStatusActive).
+		First(&targetNode).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("node not found or source parent mismatch")
+		}
+		return err
+	}
+	if targetNode.WorkspaceID != nil {
+		return ErrWorkspaceRoot
+	}
+	if err := svc.checkPermission(ctx, &targetNode, OwnerID, authorization.PermissionWrite); err != nil {
+		return err
+	}
+
 	var destId *uuid.UUID = nil
 
 	if DestinationParentID != uuid.Nil {
-		isDes, err := svc.isDescendant(ctx, TargetNodeID, DestinationParentID, OwnerID)
+		isDes, err := svc.isDescendant(ctx, TargetNodeID, DestinationParentID)
 
 		if err != nil {
 			return err
 		}
 
-		if isDes {
+		if isDes || DestinationParentID == TargetNodeID {
 			return errors.New("cannot move node into its own subtree")
 		}
+		if err := svc.canWriteIntoDirectory(ctx, DestinationParentID, OwnerID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrParentNodeNotFound
+			}
+			return err
+		}
 		destId = &DestinationParentID
+	} else if targetNode.OwnerID != OwnerID {
+		return ErrUnauthorized
 	}
 
-	query := svc.DB.WithContext(ctx).
-		Model(&Node{}).
-		Where("owner_id = ?", OwnerID)
+	var staleKeys []string
+	var merged bool
+
+	err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		name, existing, err := resolveNameConflict(tx, destId, targetNode.OwnerID, targetNode.Name, targetNode.Type, targetNode.ID, Strategy)
+		if err != nil {
+			return err
+		}
 
-	query = query.Where("id = ?", TargetNodeID)
-	result := query.Update("parent_id", destId)
+		if existing != nil {
+			// The moved file becomes the newest version of the existing one
+			staleKeys, err = svc.mergeInto(tx, &targetNode, existing, OwnerID)
+			merged = err == nil
+			return err
+		}
 
-	if result.Error != nil {
-		return result.Error
+		if err := svc.moveWorkspaceUsage(tx, targetNode.ID, destId); err != nil {
+			return err
+		}
+		err = tx.Model(&Node{}).
+			Where("id = ?", targetNode.ID).
+			Updates(map[string]interface{}{
+				"parent_id": destId,
+				"name":      name,
+			}).Error
+		if err != nil {
+			return translateNameError(err)
+		}
+		return svc.reparentNode(ctx, tx, &targetNode, destId)
+	})
+	if err != nil {
+		return err
 	}
 
-	if result.RowsAffected == 0 {
-		return errors.New("node not found or source parent mismatch")
+	if merged {
+		svc.forgetNodes(ctx, targetNode.ID)
 	}
+	svc.deleteObjects(staleKeys...)
 	return nil
 }
 
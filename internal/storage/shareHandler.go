@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+)
+
+// sharePasswordHeader carries the password of a protected share link, so it
+// never ends up in URLs or access logs.
+const sharePasswordHeader = "X-Share-Password"
+
+func (h *Handler) CreateShareLink(c echo.Context) error {
+	var req CreateShareLink
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.NodeID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	access := ShareAccess(req.Access)
+	switch access {
+	case "", ShareAccessRead, ShareAccessUpload:
+	default:
+		return c.JSON(http.StatusBadRequest, "invalid access param")
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return c.JSON(http.StatusBadRequest, "expires_at must be in the future")
+	}
+	if req.MaxDownloads != nil && *req.MaxDownloads <= 0 {
+		return c.JSON(http.StatusBadRequest, "max_downloads must be positive")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	link, err := h.svc.CreateShareLink(ctx, user.ID, id, ShareLinkOptions{
+		Access:       access,
+		Password:     req.Password,
+		ExpiresAt:    req.ExpiresAt,
+		MaxDownloads: req.MaxDownloads,
+	})
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error creating share link")
+	}
+	return c.JSON(http.StatusCreated, link)
+}
+
+func (h *Handler) ListShareLinks(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var id uuid.UUID
+	if rawId := c.QueryParam("id"); rawId != "" {
+		var err error
+		if id, err = uuid.Parse(rawId); err != nil {
+			return c.JSON(http.StatusBadRequest, "invalid id param")
+		}
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	links, err := h.svc.ListShareLinks(ctx, user.ID, id)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error listing share links")
+	}
+	return c.JSON(http.StatusOK, links)
+}
+
+func (h *Handler) RevokeShareLink(c echo.Context) error {
+	var req RevokeShareLink
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "missing id param in request body")
+	}
+	id, err := uuid.Parse(req.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	if err := h.svc.RevokeShareLink(ctx, id, user.ID); err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error revoking share link")
+	}
+	return c.JSON(http.StatusOK, "share link revoked")
+}
+
+// shareError reports why a share link can't be used. The reasons are meant
+// for the visitor, anything else stays generic.
+func shareError(c echo.Context, err error) error {
+	log.Println(err)
+	status := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		return c.JSON(status, "error opening share link")
+	}
+	return c.JSON(status, err.Error())
+}
+
+// openShare resolves the link in the URL and, from the optional id query
+// param, the node below it the request is about.
+func (h *Handler) openShare(c echo.Context) (*ShareLink, *Node, error) {
+	ctx := c.Request().Context()
+
+	link, _, err := h.svc.OpenShareLink(ctx, c.Param("token"), c.Request().Header.Get(sharePasswordHeader))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var id uuid.UUID
+	if rawId := c.QueryParam("id"); rawId != "" {
+		if id, err = uuid.Parse(rawId); err != nil {
+			return nil, nil, ErrNodeNotFound
+		}
+	}
+	node, err := h.svc.GetSharedNode(ctx, link, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return link, node, nil
+}
+
+// OpenShare describes what a share link points to, no account needed.
+func (h *Handler) OpenShare(c echo.Context) error {
+	link, node, err := h.openShare(c)
+	if err != nil {
+		return shareError(c, err)
+	}
+	// Nothing above the shared node is revealed
+	if node.ID == link.NodeID {
+		node.ParentID = nil
+	}
+	return c.JSON(http.StatusOK, PublicShare{
+		Access:    link.Access,
+		ExpiresAt: link.ExpiresAt,
+		Node:      *node,
+	})
+}
+
+func (h *Handler) ListShare(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	link, node, err := h.openShare(c)
+	if err != nil {
+		return shareError(c, err)
+	}
+
+	nodes, err := h.svc.ListSharedNodes(ctx, link, node.ID)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error listing shared folder")
+	}
+	return c.JSON(http.StatusOK, nodes)
+}
+
+// DownloadShare serves a shared file, or a shared folder as an archive. Each
+// request counts as one download against the link's limit.
+func (h *Handler) DownloadShare(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	link, node, err := h.openShare(c)
+	if err != nil {
+		return shareError(c, err)
+	}
+
+	if node.Type == NodeTypeDirectory {
+		format, err := ParseArchiveFormat(c.QueryParam("format"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		archive, err := h.svc.PrepareArchive(ctx, []uuid.UUID{node.ID}, link.OwnerID)
+		if err != nil {
+			log.Println(err)
+			return c.JSON(errorStatus(err), "error preparing archive")
+		}
+		if err := h.svc.RecordShareDownload(ctx, link); err != nil {
+			return shareError(c, err)
+		}
+
+		c.Response().Header().Set(
+			echo.HeaderContentDisposition,
+			fmt.Sprintf(`attachment; filename="%s.%s"`, archive.Name, format),
+		)
+		c.Response().Header().Set(echo.HeaderContentType, format.ContentType())
+		c.Response().WriteHeader(http.StatusOK)
+
+		if err := h.svc.WriteArchive(ctx, archive, format, c.Response().Writer); err != nil {
+			log.Println("Error streaming archive: ", err)
+		}
+		return nil
+	}
+
+	stream, node, err := h.svc.GetData(ctx, node.ID, link.OwnerID)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error getting data from storage service")
+	}
+	defer stream.Close()
+	// Only counted once there is something to send
+	if err := h.svc.RecordShareDownload(ctx, link); err != nil {
+		return shareError(c, err)
+	}
+
+	mimeType := "application/octet-stream"
+	if node.MimeType != nil && *node.MimeType != "" {
+		mimeType = *node.MimeType
+	}
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, mimeType)
+	header.Set(
+		echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="%s"`, node.Name),
+	)
+	if node.SizeBytes != nil {
+		header.Set(echo.HeaderContentLength, strconv.FormatUint(*node.SizeBytes, 10))
+	}
+
+	c.Response().WriteHeader(http.StatusOK)
+	_, err = io.Copy(c.Response().Writer, stream)
+	return err
+}
+
+// UploadShare stores a file in a shared folder of an upload link. The file
+// belongs to, and counts against the quota of, the link's owner.
+func (h *Handler) UploadShare(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	link, dir, err := h.openShare(c)
+	if err != nil {
+		return shareError(c, err)
+	}
+	if link.Access != ShareAccessUpload {
+		return c.JSON(http.StatusForbidden, "share link is read-only")
+	}
+	if dir.Type != NodeTypeDirectory {
+		return c.JSON(http.StatusBadRequest, ErrNodeIsFile.Error())
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		log.Println(err.Error())
+		return c.JSON(http.StatusBadRequest, "missing file")
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, "cannot open file")
+	}
+	defer file.Close()
+
+	mimeType, newStream, err := h.svc.DetectMimeType(ctx, file)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid file stream")
+	}
+	// Visitors never overwrite the owner's files
+	node, err := h.svc.Put(ctx, link.OwnerID, dir.ID, fileHeader.Filename, uint64(fileHeader.Size), newStream, mimeType, ConflictRename)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error writing file to the storage")
+	}
+	return c.JSON(http.StatusCreated, node)
+}
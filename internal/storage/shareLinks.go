@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const shareTokenBytes = 24
+
+func newShareToken() (string, error) {
+	token := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+func (svc *Service) CreateShareLink(
+	ctx context.Context,
+	UserID uint64,
+	NodeID uuid.UUID,
+	Options ShareLinkOptions,
+) (*ShareLink, error) {
+	node, err := svc.GetNode(ctx, NodeID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNodeNotFound
+		}
+		return nil, err
+	}
+	if node.Status != NodeStatusActive {
+		return nil, ErrNodeNotFound
+	}
+	if err := svc.checkPermission(ctx, node, UserID, authorization.PermissionExecute); err != nil {
+		return nil, err
+	}
+
+	access := Options.Access
+	if access == "" {
+		access = ShareAccessRead
+	}
+	if access == ShareAccessUpload && node.Type != NodeTypeDirectory {
+		return nil, ErrNodeIsFile
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		return nil, err
+	}
+	link := ShareLink{
+		ID:           uuid.New(),
+		Token:        token,
+		NodeID:       node.ID,
+		OwnerID:      UserID,
+		Access:       access,
+		ExpiresAt:    Options.ExpiresAt,
+		MaxDownloads: Options.MaxDownloads,
+		CreatedAt:    time.Now(),
+	}
+	if Options.Password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(Options.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		hash := string(hashed)
+		link.PasswordHash = &hash
+		link.HasPassword = true
+	}
+
+	if err := svc.DB.WithContext(ctx).Create(&link).Error; err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// ListShareLinks returns the links a user created, for a single node when
+// NodeID is set.
+func (svc *Service) ListShareLinks(
+	ctx context.Context,
+	UserID uint64,
+	NodeID uuid.UUID,
+) ([]ShareLink, error) {
+	db := svc.DB.WithContext(ctx).Where("owner_id = ?", UserID)
+	if NodeID != uuid.Nil {
+		db = db.Where("node_id = ?", NodeID)
+	}
+
+	links := []ShareLink{}
+	if err := db.Order("created_at DESC").Find(&links).Error; err != nil {
+		return nil, err
+	}
+	for i := range links {
+		links[i].HasPassword = links[i].PasswordHash != nil
+	}
+	return links, nil
+}
+
+func (svc *Service) RevokeShareLink(
+	ctx context.Context,
+	LinkID uuid.UUID,
+	UserID uint64,
+) error {
+	result := svc.DB.WithContext(ctx).
+		Where("id = ? AND owner_id = ?", LinkID, UserID).
+		Delete(&ShareLink{})
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return ErrShareLinkNotFound
+	}
+	return nil
+}
+
+// OpenShareLink checks a token and its password and records the access. The
+// shared node has to still be live, links to trashed nodes stop working
+// until the node is restored.
+func (svc *Service) OpenShareLink(
+	ctx context.Context,
+	Token string,
+	Password string,
+) (*ShareLink, *Node, error) {
+	db := svc.DB.WithContext(ctx)
+
+	var link ShareLink
+	if err := db.Where("token = ?", Token).First(&link).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrShareLinkNotFound
+		}
+		return nil, nil, err
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		return nil, nil, ErrShareLinkExpired
+	}
+	if link.PasswordHash != nil {
+		err := bcrypt.CompareHashAndPassword([]byte(*link.PasswordHash), []byte(Password))
+		if err != nil {
+			return nil, nil, ErrSharePasswordInvalid
+		}
+		link.HasPassword = true
+	}
+
+	node, err := svc.GetNode(ctx, link.NodeID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrShareLinkNotFound
+		}
+		return nil, nil, err
+	}
+	if node.Status != NodeStatusActive {
+		return nil, nil, ErrShareLinkNotFound
+	}
+
+	now := time.Now()
+	err = db.Model(&ShareLink{}).
+		Where("id = ?", link.ID).
+		Updates(map[string]interface{}{
+			"access_count":     gorm.Expr("access_count + 1"),
+			"last_accessed_at": now,
+		}).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	link.AccessCount++
+	link.LastAccessedAt = &now
+	return &link, node, nil
+}
+
+// GetSharedNode resolves a node reached through a share link, which has to
+// be the shared node itself or a live node below it. uuid.Nil stands for the
+// shared node.
+func (svc *Service) GetSharedNode(
+	ctx context.Context,
+	Link *ShareLink,
+	NodeID uuid.UUID,
+) (*Node, error) {
+	if NodeID == uuid.Nil {
+		NodeID = Link.NodeID
+	}
+
+	ancestors, err := svc.getAncestors(ctx, NodeID)
+	if err != nil {
+		return nil, err
+	}
+	for i, ancestor := range ancestors {
+		if ancestor.ID != Link.NodeID {
+			continue
+		}
+		for _, node := range ancestors[i:] {
+			if node.Status != NodeStatusActive {
+				return nil, ErrNodeNotFound
+			}
+		}
+		return &ancestors[len(ancestors)-1], nil
+	}
+	return nil, ErrNodeNotFound
+}
+
+func (svc *Service) ListSharedNodes(
+	ctx context.Context,
+	Link *ShareLink,
+	NodeID uuid.UUID,
+) ([]Node, error) {
+	dir, err := svc.GetSharedNode(ctx, Link, NodeID)
+	if err != nil {
+		return nil, err
+	}
+	if dir.Type != NodeTypeDirectory {
+		return nil, ErrNodeIsFile
+	}
+
+	nodes := []Node{}
+	err = svc.DB.WithContext(ctx).
+		Where("parent_id = ? AND status = ?", dir.ID, NodeStatusActive).
+		Order("type, name").
+		Find(&nodes).Error
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// RecordShareDownload counts a download against the link's limit, failing
+// once the limit is used up.
+func (svc *Service) RecordShareDownload(
+	ctx context.Context,
+	Link *ShareLink,
+) error {
+	result := svc.DB.WithContext(ctx).
+		Model(&ShareLink{}).
+		Where("id = ?", Link.ID).
+		Where("max_downloads IS NULL OR download_count < max_downloads").
+		Update("download_count", gorm.Expr("download_count + 1"))
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return ErrShareDownloadLimit
+	}
+	Link.DownloadCount++
+	return nil
+}
@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
+)
+
+const (
+	defaultSharedLimit = 50
+	maxSharedLimit     = 200
+
+	// Readable nodes looked up per request and bulk check
+	sharedLookupBatchSize = 500
+)
+
+// sharedCursor resumes the lookup of readable nodes after the last node of a
+// page.
+type sharedCursor struct {
+	Lookup string `json:"l"`
+}
+
+func encodeSharedCursor(lookup string) string {
+	raw, _ := json.Marshal(sharedCursor{Lookup: lookup})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeSharedCursor(cursor string) (*sharedCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var decoded sharedCursor
+	if err := json.Unmarshal(raw, &decoded); err != nil || decoded.Lookup == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &decoded, nil
+}
+
+// sharedRoot is a node at the top of something shared with the user, with
+// the lookup cursor that resumes the listing after it.
+type sharedRoot struct {
+	node   Node
+	cursor string
+}
+
+// sharedRoots returns up to Limit nodes after the lookup cursor that someone
+// else owns and the user can read while not being able to read their
+// parent: the top of everything shared with them. Readable nodes come from
+// LookupResources a page at a time, in its order, and their parents are
+// confirmed with bulk checks, so a page only costs the nodes it passes over.
+// Workspaces have their own listing, and what anyone can view is not shared
+// with anyone in particular.
+func (svc *Service) sharedRoots(
+	ctx context.Context,
+	UserID uint64,
+	Cursor string,
+	Limit int,
+) ([]sharedRoot, error) {
+	roots := []sharedRoot{}
+	for len(roots) < Limit {
+		results, next, err := svc.Authz.LookupResourcesPage(
+			ctx,
+			authorization.ResourceNode,
+			authorization.PermissionRead,
+			authorization.SubjectUser, userSubject(UserID),
+			Cursor,
+			sharedLookupBatchSize,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]uuid.UUID, 0, len(results))
+		for _, result := range results {
+			if id, err := uuid.Parse(result.ResourceID); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		var nodes []Node
+		if len(ids) > 0 {
+			err = svc.DB.WithContext(ctx).
+				Where("id IN ? AND owner_id <> ? AND status = ?", ids, UserID, NodeStatusActive).
+				Where("workspace_id IS NULL AND visibility = ?", VisibilityPrivate).
+				Find(&nodes).Error
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// A candidate's parent must not be readable
+		var zedToken string
+		var freshest *time.Time
+		checks := make([]authorization.PermissionCheck, 0, len(nodes))
+		for _, node := range nodes {
+			if node.ZedToken != nil && node.ZedTokenAt != nil && (freshest == nil || node.ZedTokenAt.After(*freshest)) {
+				zedToken, freshest = *node.ZedToken, node.ZedTokenAt
+			}
+			if node.ParentID != nil {
+				checks = append(checks, readCheck(UserID, *node.ParentID))
+			}
+		}
+		parentReadable, err := svc.Authz.CheckBulkPermissions(ctx, checks, zedToken)
+		if err != nil {
+			return nil, err
+		}
+		candidates := make(map[uuid.UUID]Node, len(nodes))
+		check := 0
+		for _, node := range nodes {
+			if node.ParentID != nil {
+				readable := parentReadable[check]
+				check++
+				if readable {
+					continue
+				}
+			}
+			candidates[node.ID] = node
+		}
+
+		// Lookup order, so the last root's cursor resumes right after it
+		for _, result := range results {
+			id, err := uuid.Parse(result.ResourceID)
+			if err != nil {
+				continue
+			}
+			if node, ok := candidates[id]; ok {
+				roots = append(roots, sharedRoot{node: node, cursor: result.Cursor})
+				if len(roots) == Limit {
+					break
+				}
+			}
+		}
+
+		if next == "" {
+			break
+		}
+		Cursor = next
+	}
+	return roots, nil
+}
+
+func readCheck(UserID uint64, NodeID uuid.UUID) authorization.PermissionCheck {
+	return authorization.PermissionCheck{
+		SubjectType:  authorization.SubjectUser,
+		SubjectID:    userSubject(UserID),
+		ResourceType: authorization.ResourceNode,
+		ResourceID:   NodeID.String(),
+		Permission:   authorization.PermissionRead,
+	}
+}
+
+// ListSharedWithMe pages through what other users shared with the user,
+// every shared folder showing up once rather than with all its content.
+func (svc *Service) ListSharedWithMe(
+	ctx context.Context,
+	UserID uint64,
+	Cursor string,
+	Limit int,
+) (*SharedWithMePage, error) {
+	if Limit <= 0 {
+		Limit = defaultSharedLimit
+	} else if Limit > maxSharedLimit {
+		Limit = maxSharedLimit
+	}
+	var after string
+	if Cursor != "" {
+		decoded, err := decodeSharedCursor(Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = decoded.Lookup
+	}
+
+	// One more than fits tells whether there is a next page
+	roots, err := svc.sharedRoots(ctx, UserID, after, Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	result := SharedWithMePage{Nodes: make([]SharedNode, 0, min(len(roots), Limit))}
+	if len(roots) > Limit {
+		roots = roots[:Limit]
+		result.NextCursor = encodeSharedCursor(roots[Limit-1].cursor)
+	}
+	if len(roots) == 0 {
+		return &result, nil
+	}
+
+	page := make([]*Node, 0, len(roots))
+	nodeIDs := make([]uuid.UUID, 0, len(roots))
+	var zedToken string
+	var freshest *time.Time
+	for i := range roots {
+		node := &roots[i].node
+		page = append(page, node)
+		nodeIDs = append(nodeIDs, node.ID)
+		if node.ZedToken != nil && node.ZedTokenAt != nil && (freshest == nil || node.ZedTokenAt.After(*freshest)) {
+			zedToken, freshest = *node.ZedToken, node.ZedTokenAt
+		}
+	}
+	permissions, err := svc.effectivePermissions(ctx, page, UserID, zedToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// Grants only tell who shared and when, access was settled above
+	var grants []NodePermission
+	err = svc.DB.WithContext(ctx).
+		Where("node_id IN ? AND user_id = ?", nodeIDs, UserID).
+		Find(&grants).Error
+	if err != nil {
+		return nil, err
+	}
+	granted := make(map[uuid.UUID]NodePermission, len(grants))
+	for _, grant := range grants {
+		granted[grant.NodeID] = grant
+	}
+
+	sharerIDs := []uint64{}
+	for i, node := range page {
+		shared := SharedNode{Node: *node, SharedBy: node.OwnerID, Role: roleOf(permissions[i])}
+		// Unknown for access reached some other way, like a group
+		if grant, ok := granted[node.ID]; ok {
+			shared.SharedBy = grant.GrantedBy
+			shared.SharedAt = &grant.CreatedAt
+		}
+		result.Nodes = append(result.Nodes, shared)
+		sharerIDs = append(sharerIDs, shared.SharedBy)
+	}
+
+	var sharers []authentication.User
+	if err := svc.DB.WithContext(ctx).Where("id IN ?", sharerIDs).Find(&sharers).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[uint64]string, len(sharers))
+	for _, sharer := range sharers {
+		names[sharer.ID] = sharer.Username
+	}
+	for i := range result.Nodes {
+		result.Nodes[i].SharedByName = names[result.Nodes[i].SharedBy]
+	}
+	return &result, nil
+}
+
+// roleOf is the role a listing's permission type stands for.
+func roleOf(permission PermissionType) AccessRole {
+	switch permission {
+	case PermissionExecute:
+		return AccessAdmin
+	case PermissionWrite:
+		return AccessEditor
+	default:
+		return AccessViewer
+	}
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSharedCursorRoundTrip(t *testing.T) {
+	for _, lookup := range []string{
+		"GhQKEjAxOTI4YjY3LWZhZTctNzQ2Ng==",
+		"5c0d6a8e-2f4b-4a55-9c61-2b1f0e6f7d3a",
+	} {
+		decoded, err := decodeSharedCursor(encodeSharedCursor(lookup))
+		if err != nil {
+			t.Fatalf("decodeSharedCursor(encodeSharedCursor(%q)) failed: %v", lookup, err)
+		}
+		if decoded.Lookup != lookup {
+			t.Errorf("round trip of %q = %q", lookup, decoded.Lookup)
+		}
+	}
+}
+
+func TestDecodeSharedCursorRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "not a cursor!"},
+		{"not json", "bm90IGpzb24"},
+		{"empty object", "e30"},
+		{"empty lookup", "eyJsIjoiIn0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeSharedCursor(tt.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeSharedCursor(%q) = %v, want ErrInvalidCursor", tt.cursor, err)
+			}
+		})
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"io"
 	"net/url"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
 	"github.com/sirkartik/cloud_drive_2.0/internal/config"
 	"github.com/sirkartik/cloud_drive_2.0/internal/shared"
 	"gorm.io/gorm"
@@ -16,6 +18,7 @@ type Service struct {
 	DB     *gorm.DB
 	Client shared.ObjectStorage
 	Cfg    config.Config
+	Authz  authorization.Authorizer
 }
 
 type Handler struct {
@@ -25,22 +28,88 @@ type Handler struct {
 type StorageService interface {
 	GetNode(ctx context.Context, ID uuid.UUID) (*Node, error)
 	DetectMimeType(ctx context.Context, data io.ReadCloser) (string, io.ReadCloser, error)
-	Put(ctx context.Context, UserID uint64, ParentID uuid.UUID, Name string, Bytes uint64, data io.ReadCloser, mimeType string) (*Node, error)
+	Put(ctx context.Context, UserID uint64, ParentID uuid.UUID, Name string, Bytes uint64, data io.ReadCloser, mimeType string, Strategy ConflictStrategy) (*Node, error)
 	GetData(ctx context.Context, NodeID uuid.UUID, UserID uint64) (io.ReadCloser, *Node, error)
+	StatData(ctx context.Context, NodeID uuid.UUID, UserID uint64) (*Node, *shared.ObjectInfo, error)
+	GetDataRange(ctx context.Context, NodeID uuid.UUID, UserID uint64, Offset int64, Length int64) (io.ReadCloser, *Node, error)
 	GeneratePresignedGetURL(ctx context.Context, key string) (*url.URL, error)
 	Delete(ctx context.Context, NodeID uuid.UUID, UserID uint64) error
+	ListVersions(ctx context.Context, NodeID uuid.UUID, UserID uint64) ([]FileVersion, error)
+	GetVersionData(ctx context.Context, NodeID uuid.UUID, VersionID uuid.UUID, UserID uint64) (io.ReadCloser, *FileVersion, *Node, error)
+	PutVersion(ctx context.Context, NodeID uuid.UUID, UserID uint64, Bytes uint64, data io.ReadCloser, mimeType string) (*Node, error)
+	RestoreVersion(ctx context.Context, NodeID uuid.UUID, VersionID uuid.UUID, UserID uint64) (*Node, error)
+	ListTrash(ctx context.Context, UserID uint64) ([]TrashItem, error)
+	PrepareArchive(ctx context.Context, NodeIDs []uuid.UUID, UserID uint64) (*Archive, error)
+	WriteArchive(ctx context.Context, archive *Archive, Format ArchiveFormat, w io.Writer) error
+	Search(ctx context.Context, UserID uint64, Query SearchQuery) (*SearchResults, error)
+	CreateShareLink(ctx context.Context, UserID uint64, NodeID uuid.UUID, Options ShareLinkOptions) (*ShareLink, error)
+	ListShareLinks(ctx context.Context, UserID uint64, NodeID uuid.UUID) ([]ShareLink, error)
+	RevokeShareLink(ctx context.Context, LinkID uuid.UUID, UserID uint64) error
+	OpenShareLink(ctx context.Context, Token string, Password string) (*ShareLink, *Node, error)
+	GetSharedNode(ctx context.Context, Link *ShareLink, NodeID uuid.UUID) (*Node, error)
+	ListSharedNodes(ctx context.Context, Link *ShareLink, NodeID uuid.UUID) ([]Node, error)
+	RecordShareDownload(ctx context.Context, Link *ShareLink) error
+	CreateFileRequest(ctx context.Context, UserID uint64, DirectoryID uuid.UUID, Options FileRequestOptions) (*FileRequest, error)
+	ListFileRequests(ctx context.Context, UserID uint64) ([]FileRequest, error)
+	ListFileRequestUploads(ctx context.Context, RequestID uuid.UUID, UserID uint64) ([]FileRequestUpload, error)
+	CloseFileRequest(ctx context.Context, RequestID uuid.UUID, UserID uint64) error
+	OpenFileRequest(ctx context.Context, Token string) (*FileRequest, error)
+	RecordFileRequestUpload(ctx context.Context, Request *FileRequest, node *Node, UploaderName string) (*FileRequestUpload, error)
+	GrantAccess(ctx context.Context, UserID uint64, NodeID uuid.UUID, Grantee string, Role AccessRole) (*NodeAccess, error)
+	UpdateAccess(ctx context.Context, UserID uint64, NodeID uuid.UUID, GranteeID uint64, Role AccessRole) (*NodeAccess, error)
+	RevokeAccess(ctx context.Context, UserID uint64, NodeID uuid.UUID, GranteeID uint64) error
+	ListAccess(ctx context.Context, UserID uint64, NodeID uuid.UUID) ([]NodeAccess, error)
+	ListSharedWithMe(ctx context.Context, UserID uint64, Cursor string, Limit int) (*SharedWithMePage, error)
+	CreateWorkspace(ctx context.Context, UserID uint64, Name string) (*Workspace, error)
+	ListWorkspaces(ctx context.Context, UserID uint64) ([]WorkspaceWithRole, error)
+	RenameWorkspace(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID, Name string) (*Workspace, error)
+	DeleteWorkspace(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID) error
+	AddWorkspaceMember(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID, Member string, Role WorkspaceRole) (*WorkspaceMember, error)
+	RemoveWorkspaceMember(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID, MemberID uint64) error
+	ListWorkspaceMembers(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID) ([]WorkspaceMember, error)
+	GetWorkspaceUsage(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID) (*WorkspaceUsage, error)
+	SetWorkspaceQuota(ctx context.Context, WorkspaceID uuid.UUID, QuotaBytes *int64) error
+	CreateGroup(ctx context.Context, UserID uint64, Name string) (*Group, error)
+	ListGroups(ctx context.Context, UserID uint64) ([]GroupWithRole, error)
+	DeleteGroup(ctx context.Context, UserID uint64, GroupID uuid.UUID) error
+	AddGroupMember(ctx context.Context, UserID uint64, GroupID uuid.UUID, Member string, Role GroupRole) (*GroupMember, error)
+	AddNestedGroup(ctx context.Context, UserID uint64, GroupID uuid.UUID, MemberGroupID uuid.UUID) (*GroupMember, error)
+	RemoveGroupMember(ctx context.Context, UserID uint64, GroupID uuid.UUID, MemberID uint64) error
+	RemoveNestedGroup(ctx context.Context, UserID uint64, GroupID uuid.UUID, MemberGroupID uuid.UUID) error
+	ListGroupMembers(ctx context.Context, UserID uint64, GroupID uuid.UUID) ([]GroupMember, error)
+	GrantGroupAccess(ctx context.Context, UserID uint64, NodeID uuid.UUID, GroupID uuid.UUID, Role AccessRole) (*NodeAccess, error)
+	RevokeGroupAccess(ctx context.Context, UserID uint64, NodeID uuid.UUID, GroupID uuid.UUID) error
+	AddWorkspaceGroup(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID, GroupID uuid.UUID, Role WorkspaceRole) (*WorkspaceMember, error)
+	RemoveWorkspaceGroup(ctx context.Context, UserID uint64, WorkspaceID uuid.UUID, GroupID uuid.UUID) error
+	SetVisibility(ctx context.Context, UserID uint64, NodeID uuid.UUID, Visibility NodeVisibility) (*Node, error)
+	GetReadableNode(ctx context.Context, UserID uint64, NodeID uuid.UUID) (*Node, error)
+	ListPublicNodes(ctx context.Context, Offset int, Limit int) ([]Node, error)
+	GetUsage(ctx context.Context, UserID uint64) (*Usage, error)
+	SetQuota(ctx context.Context, UserID uint64, QuotaBytes *int64) error
+	RestoreTrash(ctx context.Context, TrashID uuid.UUID, UserID uint64, ParentID uuid.UUID, Strategy ConflictStrategy) (*Node, error)
+	PurgeTrash(ctx context.Context, TrashID uuid.UUID, UserID uint64) error
+	EmptyTrash(ctx context.Context, UserID uint64) error
 	GetDataNoAuth(ctx context.Context, NodeID uuid.UUID) (io.ReadCloser, *Node, error)
 	ListNodes(ctx context.Context, ParentNodeID uuid.UUID, UserID uint64) ([]NodeWithPermission, error)
 	PutHLS(ctx context.Context, HLSDirPath, ParentKey string) error
-	CreateDirectoryNode(ctx context.Context, Name string, ParentNodeID uuid.UUID, OwnerID uint64) error
-	Copy(ctx context.Context, TargetNodeID uuid.UUID, DestinationID uuid.UUID, OwnerID uint64) error
-	Move(ctx context.Context, TargetNodeID uuid.UUID, DestinationParentID uuid.UUID, OwnerID uint64) error
+	CreateDirectoryNode(ctx context.Context, Name string, ParentNodeID uuid.UUID, OwnerID uint64, Strategy ConflictStrategy) (*Node, error)
+	Copy(ctx context.Context, TargetNodeID uuid.UUID, DestinationID uuid.UUID, OwnerID uint64, Strategy ConflictStrategy) (*CopyJob, error)
+	GetCopyJob(ctx context.Context, JobID uuid.UUID, UserID uint64) (*CopyJob, error)
+	Move(ctx context.Context, TargetNodeID uuid.UUID, DestinationParentID uuid.UUID, OwnerID uint64, Strategy ConflictStrategy) error
+	Rename(ctx context.Context, NodeID uuid.UUID, Name string, UserID uint64, Strategy ConflictStrategy) (*Node, error)
 	GeneratePostUploadPolicy(ctx context.Context) (*UploadPolicy, error)
+	CreateUpload(ctx context.Context, UserID uint64, ParentID uuid.UUID, Name string, Length uint64) (*Upload, error)
+	GetUpload(ctx context.Context, UploadID uuid.UUID, UserID uint64) (*Upload, error)
+	WriteUpload(ctx context.Context, UploadID uuid.UUID, UserID uint64, Offset uint64, data io.Reader) (*Upload, *Node, error)
+	TerminateUpload(ctx context.Context, UploadID uuid.UUID, UserID uint64) error
+	InitiateUpload(ctx context.Context, UserID uint64, ParentID uuid.UUID, Name string, Bytes uint64, Checksum string, Parts int) (*UploadTicket, error)
+	CompleteUpload(ctx context.Context, NodeID uuid.UUID, UserID uint64, Parts []shared.CompletedPart) (*Node, error)
 }
 
 type HookLayer struct {
-	storageSvc    StorageService
-	putHooksAfter []PutHook
+	storageSvc       StorageService
+	putHooksAfter    []PutHook
+	fileRequestHooks []FileRequestHook
 }
 
 type PutHook func(
@@ -54,6 +123,13 @@ type PutHook func(
 	sizeBytes uint64,
 ) error
 
+// FileRequestHook runs after a file arrived through a file request.
+type FileRequestHook func(
+	ctx context.Context,
+	request *FileRequest,
+	upload *FileRequestUpload,
+) error
+
 type NodeWithPermission struct {
 	Node
 	PermissionType *PermissionType
@@ -61,6 +137,7 @@ type NodeWithPermission struct {
 
 type MinioStorage struct {
 	client *minio.Client
+	core   *minio.Core
 }
 
 type UploadPolicy struct {
@@ -68,3 +145,193 @@ type UploadPolicy struct {
 	Fields    map[string]string
 	KeyPrefix string
 }
+
+type UploadTicket struct {
+	NodeID    uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url,omitempty"`       // Single PUT upload
+	PartURLs  []string  `json:"part_urls,omitempty"` // Multipart upload, in part order
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type Usage struct {
+	QuotaBytes int64            `json:"quota_bytes"` // Zero means unlimited
+	UsedBytes  int64            `json:"used_bytes"`
+	Categories map[string]int64 `json:"categories"` // video, images, documents and other
+}
+
+type ArchiveEntry struct {
+	Path string // Slash separated path inside the archive
+	Node Node
+}
+
+type Archive struct {
+	Name    string // Without extension
+	Entries []ArchiveEntry
+}
+
+type ExtractResult struct {
+	Path   string     `json:"path"`
+	Status string     `json:"status"` // created, skipped or failed
+	NodeID *uuid.UUID `json:"id,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+type ExtractSummary struct {
+	Created int             `json:"created"`
+	Skipped int             `json:"skipped"`
+	Failed  int             `json:"failed"`
+	Results []ExtractResult `json:"results"`
+}
+
+type SearchQuery struct {
+	Term          string // Matched against names
+	Content       string // Matched against the text of documents, web search syntax
+	Type          NodeType
+	MimeType      string // Exact, or a prefix ending in "/" such as "image/"
+	MinSize       *uint64
+	MaxSize       *uint64
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	OwnerID       *uint64
+	FolderID      uuid.UUID // Searches the whole subtree when set
+	Limit         int
+	Offset        int
+}
+
+type Breadcrumb struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+type SearchHit struct {
+	Node
+	Score       float64      `json:"score"`
+	Path        string       `json:"path"`
+	Breadcrumbs []Breadcrumb `json:"breadcrumbs"`       // Root first, the node itself excluded
+	Snippet     string       `json:"snippet,omitempty"` // HTML escaped, matches wrapped in <mark>
+}
+
+type SearchResults struct {
+	Hits    []SearchHit `json:"hits"`
+	Offset  int         `json:"offset"`
+	Limit   int         `json:"limit"`
+	HasMore bool        `json:"has_more"`
+}
+
+type ShareLinkOptions struct {
+	Access       ShareAccess
+	Password     string // Empty for no password
+	ExpiresAt    *time.Time
+	MaxDownloads *int64
+}
+
+// PublicShare is what a share link reveals to whoever opens it.
+type PublicShare struct {
+	Access    ShareAccess `json:"access"`
+	ExpiresAt *time.Time  `json:"expires_at"`
+	Node      Node        `json:"node"`
+}
+
+type FileRequestOptions struct {
+	Title        string
+	Message      string
+	MaxFileBytes *uint64
+	AllowedTypes []string // MIME types, "image/" accepting every image
+	ExpiresAt    *time.Time
+}
+
+// PublicFileRequest is what an anonymous uploader gets to see.
+type PublicFileRequest struct {
+	Title        string     `json:"title"`
+	Message      string     `json:"message"`
+	MaxFileBytes *uint64    `json:"max_file_bytes"`
+	AllowedTypes []string   `json:"allowed_types"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+}
+
+// NodeAccess is a user or group who can reach a node, through a role on the
+// node itself or on one of its ancestors.
+type NodeAccess struct {
+	UserID        uint64     `json:"user_id,omitempty"`
+	Username      string     `json:"username,omitempty"`
+	Email         string     `json:"email,omitempty"`
+	GroupID       *uuid.UUID `json:"group_id,omitempty"`
+	GroupName     string     `json:"group_name,omitempty"`
+	Role          AccessRole `json:"role"`
+	InheritedFrom *uuid.UUID `json:"inherited_from,omitempty"` // Ancestor holding the role
+}
+
+// SharedNode is the top of something shared with the user.
+type SharedNode struct {
+	Node
+	Role         AccessRole `json:"role"`
+	SharedBy     uint64     `json:"shared_by"`
+	SharedByName string     `json:"shared_by_username"`
+	SharedAt     *time.Time `json:"shared_at,omitempty"` // Unknown for access not granted to the user directly
+}
+
+type SharedWithMePage struct {
+	Nodes      []SharedNode `json:"nodes"`
+	NextCursor string       `json:"next_cursor,omitempty"` // Empty on the last page
+}
+
+type WorkspaceWithRole struct {
+	Workspace
+	Role WorkspaceRole `json:"role"` // The caller's role
+}
+
+// WorkspaceMember is a user, or a group, with a role on a workspace.
+type WorkspaceMember struct {
+	UserID    uint64        `json:"user_id,omitempty"`
+	Username  string        `json:"username,omitempty"`
+	Email     string        `json:"email,omitempty"`
+	GroupID   *uuid.UUID    `json:"group_id,omitempty"`
+	GroupName string        `json:"group_name,omitempty"`
+	Role      WorkspaceRole `json:"role"`
+	AddedAt   time.Time     `json:"added_at"`
+}
+
+type WorkspaceUsage struct {
+	UsedBytes  int64 `json:"used_bytes"`
+	QuotaBytes int64 `json:"quota_bytes"` // Zero for unlimited
+}
+
+type GroupWithRole struct {
+	Group
+	Role GroupRole `json:"role"` // The caller's role
+}
+
+// GroupMember is a user in a group, or a group nested in it.
+type GroupMember struct {
+	UserID    uint64     `json:"user_id,omitempty"`
+	Username  string     `json:"username,omitempty"`
+	Email     string     `json:"email,omitempty"`
+	GroupID   *uuid.UUID `json:"group_id,omitempty"`
+	GroupName string     `json:"group_name,omitempty"`
+	Role      GroupRole  `json:"role"`
+	AddedAt   time.Time  `json:"added_at"`
+}
+
+// MigrationReport sums up a run of MigrateRelationships.
+type MigrationReport struct {
+	Nodes    int              `json:"nodes"`
+	Grants   int              `json:"grants"`
+	Unmapped []NodePermission `json:"unmapped"` // Rows with a type no role matches
+	ZedToken string           `json:"zed_token"`
+}
+
+// Discrepancy is a check the legacy tables and SpiceDB answer differently.
+type Discrepancy struct {
+	NodeID     uuid.UUID `json:"node_id"`
+	UserID     uint64    `json:"user_id"`
+	Permission string    `json:"permission"`
+	Legacy     bool      `json:"legacy"`
+	SpiceDB    bool      `json:"spicedb"`
+}
+
+type ParityReport struct {
+	Nodes         int           `json:"nodes"`
+	Checks        int           `json:"checks"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
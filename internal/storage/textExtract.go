@@ -0,0 +1,412 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	maxIndexedObjectBytes = 64 << 20  // Larger documents are not indexed
+	maxIndexedTextBytes   = 512 << 10 // Postgres caps a tsvector at 1 MiB
+)
+
+const (
+	mimeDocx = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	mimeXlsx = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	mimePptx = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+)
+
+func baseMimeType(mimeType string) string {
+	base, _, _ := strings.Cut(mimeType, ";")
+	return strings.TrimSpace(base)
+}
+
+func isPlainText(mimeType string) bool {
+	switch mimeType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-sh", "application/x-yaml", "application/rtf":
+		return true
+	}
+	return strings.HasPrefix(mimeType, "text/")
+}
+
+// IsIndexable reports whether text can be extracted from files of this MIME
+// type: plain text, Markdown and source code, PDF and Office documents.
+func IsIndexable(mimeType string) bool {
+	mimeType = baseMimeType(mimeType)
+	switch mimeType {
+	case "application/pdf", mimeDocx, mimeXlsx, mimePptx:
+		return true
+	}
+	return isPlainText(mimeType) ||
+		strings.HasPrefix(mimeType, "application/vnd.oasis.opendocument.")
+}
+
+// textBuffer collects extracted text up to maxIndexedTextBytes, dropping
+// control characters that Postgres or the snippet markers would trip over.
+type textBuffer struct {
+	strings.Builder
+}
+
+func (b *textBuffer) full() bool {
+	return b.Len() >= maxIndexedTextBytes
+}
+
+func (b *textBuffer) add(s string) {
+	for _, r := range s {
+		if b.full() {
+			return
+		}
+		switch {
+		case r == utf8.RuneError:
+			continue
+		case r == '\n' || r == '\t':
+		case unicode.IsControl(r):
+			r = ' '
+		}
+		b.WriteRune(r)
+	}
+}
+
+func (b *textBuffer) newline() {
+	if b.Len() > 0 && !b.full() {
+		b.WriteByte('\n')
+	}
+}
+
+// extractText returns the searchable text of a document.
+func extractText(mimeType string, data []byte) (string, error) {
+	var text textBuffer
+
+	mimeType = baseMimeType(mimeType)
+	switch {
+	case mimeType == "application/pdf":
+		extractPDFText(data, &text)
+	case mimeType == mimeDocx, mimeType == mimeXlsx, mimeType == mimePptx,
+		strings.HasPrefix(mimeType, "application/vnd.oasis.opendocument."):
+		if err := extractOfficeText(data, &text); err != nil {
+			return "", err
+		}
+	default:
+		text.add(strings.ToValidUTF8(string(data), ""))
+	}
+	return text.String(), nil
+}
+
+// officeMembers lists the parts of an Office Open XML or OpenDocument
+// package holding the document text, in reading order.
+func officeMembers(zr *zip.Reader) []*zip.File {
+	var members []*zip.File
+	for _, f := range zr.File {
+		switch {
+		case f.Name == "content.xml", // OpenDocument
+			f.Name == "word/document.xml",
+			f.Name == "xl/sharedStrings.xml",
+			strings.HasPrefix(f.Name, "word/header"), strings.HasPrefix(f.Name, "word/footer"),
+			strings.HasPrefix(f.Name, "ppt/slides/slide") && path.Ext(f.Name) == ".xml":
+			members = append(members, f)
+		}
+	}
+
+	// slide10.xml has to come after slide2.xml
+	number := regexp.MustCompile(`\d+`)
+	sort.SliceStable(members, func(i, j int) bool {
+		a, b := members[i].Name, members[j].Name
+		if path.Dir(a) == path.Dir(b) {
+			na, _ := strconv.Atoi(number.FindString(path.Base(a)))
+			nb, _ := strconv.Atoi(number.FindString(path.Base(b)))
+			if na != nb {
+				return na < nb
+			}
+		}
+		return false
+	})
+	return members
+}
+
+func extractOfficeText(data []byte, text *textBuffer) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range officeMembers(zr) {
+		if text.full() {
+			break
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		// OpenDocument keeps text directly in its paragraphs, Office Open
+		// XML only in <t> runs
+		err = extractXMLText(io.LimitReader(r, maxIndexedObjectBytes), text, f.Name != "content.xml")
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractXMLText(r io.Reader, text *textBuffer, runsOnly bool) error {
+	decoder := xml.NewDecoder(r)
+	inRun := false
+
+	for !text.full() {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inRun = true
+			case "tab", "s":
+				text.add(" ")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inRun = false
+			case "p", "h", "si", "tr":
+				text.newline()
+			case "tc", "table-cell":
+				text.add(" ")
+			}
+		case xml.CharData:
+			if inRun || !runsOnly {
+				text.add(string(t))
+			}
+		}
+	}
+	return nil
+}
+
+var pdfStream = regexp.MustCompile(`stream\r?\n`)
+
+// extractPDFText pulls the text shown by the page content streams of a PDF.
+// Only strings drawn between BT and ET count; text in fonts without a
+// standard encoding (CID fonts without their ToUnicode maps applied) comes
+// out as noise and is mostly filtered by the tokenizer anyway.
+func extractPDFText(data []byte, text *textBuffer) {
+	for offset := 0; offset < len(data) && !text.full(); {
+		match := pdfStream.FindIndex(data[offset:])
+		if match == nil {
+			return
+		}
+		start := offset + match[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			return
+		}
+		body := data[start : start+end]
+
+		// The stream dictionary sits between the object header and the
+		// stream keyword
+		dict := data[offset : offset+match[0]]
+		if obj := bytes.LastIndex(dict, []byte("obj")); obj >= 0 {
+			dict = dict[obj:]
+		}
+		offset = start + end + len("endstream")
+
+		switch {
+		case bytes.Contains(dict, []byte("/FlateDecode")):
+			zr, err := zlib.NewReader(bytes.NewReader(body))
+			if err != nil {
+				continue
+			}
+			// A truncated stream still yields what was inflated
+			body, _ = io.ReadAll(io.LimitReader(zr, maxIndexedObjectBytes))
+			zr.Close()
+		case bytes.Contains(dict, []byte("/Filter")):
+			continue // Images and other encodings
+		}
+
+		extractPDFContent(body, text)
+	}
+}
+
+func isPDFDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return isPDFSpace(c)
+}
+
+func isPDFSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0:
+		return true
+	}
+	return false
+}
+
+// extractPDFContent walks the operators of a content stream.
+func extractPDFContent(content []byte, text *textBuffer) {
+	inText := false
+	var pending strings.Builder
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case isPDFSpace(c), c == '[', c == ']', c == '{', c == '}', c == '>', c == ')':
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			s, next := readPDFLiteral(content, i+1)
+			if inText {
+				pending.WriteString(decodePDFString(s))
+			}
+			i = next
+		case c == '<' && i+1 < len(content) && content[i+1] == '<':
+			i += 2
+		case c == '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return
+			}
+			if inText {
+				pending.WriteString(decodePDFHex(content[i+1 : i+end]))
+			}
+			i += end + 1
+		default:
+			// Names start with a delimiter, operators and numbers don't
+			start := i
+			i++
+			for i < len(content) && !isPDFDelimiter(content[i]) {
+				i++
+			}
+			token := string(content[start:i])
+
+			switch token {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				text.newline()
+			case "Tj", "TJ", "'", `"`:
+				text.add(pending.String())
+				pending.Reset()
+			case "T*", "Td", "TD", "Tm":
+				text.add(" ")
+			default:
+				// Large negative kerning inside a TJ array separates words
+				if n, err := strconv.ParseFloat(token, 64); err == nil && inText && n <= -200 {
+					pending.WriteByte(' ')
+				}
+			}
+		}
+	}
+}
+
+// readPDFLiteral reads a (string) starting after its opening parenthesis.
+func readPDFLiteral(content []byte, i int) ([]byte, int) {
+	var s []byte
+	depth := 1
+	for i < len(content) {
+		c := content[i]
+		i++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return s, i
+			}
+		case '\\':
+			if i >= len(content) {
+				return s, i
+			}
+			c = content[i]
+			i++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				continue // Line continuation
+			default:
+				if c >= '0' && c <= '7' {
+					n := int(c - '0')
+					for k := 0; k < 2 && i < len(content) && content[i] >= '0' && content[i] <= '7'; k++ {
+						n = n*8 + int(content[i]-'0')
+						i++
+					}
+					c = byte(n)
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return s, i
+}
+
+func decodePDFHex(hex []byte) string {
+	var s []byte
+	var digits []byte
+	for _, c := range hex {
+		if isPDFSpace(c) {
+			continue
+		}
+		digits = append(digits, c)
+		if len(digits) == 2 {
+			n, err := strconv.ParseUint(string(digits), 16, 8)
+			if err != nil {
+				return ""
+			}
+			s = append(s, byte(n))
+			digits = digits[:0]
+		}
+	}
+	return decodePDFString(s)
+}
+
+// decodePDFString decodes UTF-16 strings marked with a byte order mark and
+// treats everything else as Latin-1, close enough to PDFDocEncoding.
+func decodePDFString(s []byte) string {
+	if len(s) >= 2 && s[0] == 0xFE && s[1] == 0xFF {
+		units := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+
+	runes := make([]rune, 0, len(s))
+	for _, c := range s {
+		if c < 0x20 && c != '\t' && c != '\n' {
+			continue // Glyph IDs of embedded fonts, not text
+		}
+		runes = append(runes, rune(c))
+	}
+	return string(runes)
+}
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// pdfWithStreams lays content streams out as PDF objects, each with its
+// stream dictionary.
+func pdfWithStreams(t *testing.T, streams ...struct {
+	dict    string
+	content []byte
+}) []byte {
+	t.Helper()
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	for i, stream := range streams {
+		fmt.Fprintf(&pdf, "%d 0 obj\n<< /Length %d %s >>\nstream\n", i+1, len(stream.content), stream.dict)
+		pdf.Write(stream.content)
+		pdf.WriteString("\nendstream\nendobj\n")
+	}
+	pdf.WriteString("%%EOF\n")
+	return pdf.Bytes()
+}
+
+func deflate(t *testing.T, data string) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return compressed.Bytes()
+}
+
+func TestExtractPDFText(t *testing.T) {
+	type stream = struct {
+		dict    string
+		content []byte
+	}
+	tests := []struct {
+		name    string
+		streams []stream
+		want    string
+	}{
+		{
+			name:    "literal string",
+			streams: []stream{{"", []byte("BT /F1 12 Tf 72 712 Td (Hello) Tj ET")}},
+			want:    "Hello",
+		},
+		{
+			name:    "kerned array",
+			streams: []stream{{"", []byte("BT [(Hel) -10 (lo) -250 (World)] TJ ET")}},
+			want:    "Hello World",
+		},
+		{
+			name:    "hex and escaped strings",
+			streams: []stream{{"", []byte(`BT <4869> Tj T* (caf\351 \(1\)) Tj ET`)}},
+			want:    "Hi café (1)",
+		},
+		{
+			name:    "text outside BT ignored",
+			streams: []stream{{"", []byte("(Outside) Tj BT (Inside) Tj ET")}},
+			want:    "Inside",
+		},
+		{
+			name:    "flate compressed",
+			streams: []stream{{"/Filter /FlateDecode", deflate(t, "BT (Compressed) Tj ET")}},
+			want:    "Compressed",
+		},
+		{
+			name: "other encodings skipped",
+			streams: []stream{
+				{"/Filter /DCTDecode", []byte("BT (Image) Tj ET")},
+				{"", []byte("BT (Page) Tj ET")},
+			},
+			want: "Page",
+		},
+		{
+			name: "pages in order",
+			streams: []stream{
+				{"", []byte("BT (One) Tj ET")},
+				{"/Filter /FlateDecode", deflate(t, "BT (Two) Tj ET")},
+			},
+			want: "One Two",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var text textBuffer
+			extractPDFText(pdfWithStreams(t, tt.streams...), &text)
+			if got := strings.Join(strings.Fields(text.String()), " "); got != tt.want {
+				t.Errorf("extractPDFText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodePDFString(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want string
+	}{
+		{"ascii", []byte("Hello"), "Hello"},
+		{"latin-1", []byte{'c', 'a', 'f', 0xE9}, "café"},
+		{"utf-16", []byte{0xFE, 0xFF, 0x00, 'H', 0x00, 0xE9}, "Hé"},
+		{"utf-16 surrogate pair", []byte{0xFE, 0xFF, 0xD8, 0x3D, 0xDE, 0x00}, "😀"},
+		{"utf-16 odd trailing byte", []byte{0xFE, 0xFF, 0x00, 'A', 0x00}, "A"},
+		{"glyph ids dropped", []byte{0x01, 'A', 0x02, 'B'}, "AB"},
+		{"tabs and newlines kept", []byte("a\tb\nc"), "a\tb\nc"},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodePDFString(tt.in); got != tt.want {
+				t.Errorf("decodePDFString(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// officePackage zips members in the order given.
+func officePackage(t *testing.T, members ...[2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, member := range members {
+		w, err := zw.Create(member[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(member[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractTextOffice(t *testing.T) {
+	const w = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+	const a = `xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main"`
+	tests := []struct {
+		name     string
+		mimeType string
+		data     []byte
+		want     string
+	}{
+		{
+			name:     "docx runs and paragraphs",
+			mimeType: mimeDocx,
+			data: officePackage(t,
+				[2]string{"[Content_Types].xml", `<Types><Default Extension="xml"/></Types>`},
+				[2]string{"word/document.xml", `<w:document ` + w + `><w:body>` +
+					`<w:p><w:r><w:t>Hello</w:t></w:r><w:r><w:tab/><w:t>world</w:t></w:r></w:p>` +
+					`<w:p><w:r><w:instrText>PAGE</w:instrText><w:t>Second</w:t></w:r></w:p>` +
+					`</w:body></w:document>`},
+			),
+			want: "Hello world\nSecond\n",
+		},
+		{
+			name:     "docx table cells",
+			mimeType: mimeDocx,
+			data: officePackage(t,
+				[2]string{"word/document.xml", `<w:document ` + w + `><w:body><w:tbl><w:tr>` +
+					`<w:tc><w:r><w:t>A</w:t></w:r></w:tc><w:tc><w:r><w:t>B</w:t></w:r></w:tc>` +
+					`</w:tr></w:tbl></w:body></w:document>`},
+			),
+			want: "A B \n",
+		},
+		{
+			name:     "xlsx shared strings",
+			mimeType: mimeXlsx + "; charset=binary",
+			data: officePackage(t,
+				[2]string{"xl/worksheets/sheet1.xml", `<worksheet><sheetData><row><c><v>42</v></c></row></sheetData></worksheet>`},
+				[2]string{"xl/sharedStrings.xml", `<sst><si><t>Name</t></si><si><t>Total</t></si></sst>`},
+			),
+			want: "Name\nTotal\n",
+		},
+		{
+			name:     "pptx slides in numeric order",
+			mimeType: mimePptx,
+			data: officePackage(t,
+				[2]string{"ppt/slides/slide10.xml", `<p:sld ` + a + `><a:p><a:r><a:t>Ten</a:t></a:r></a:p></p:sld>`},
+				[2]string{"ppt/slides/slide2.xml", `<p:sld ` + a + `><a:p><a:r><a:t>Two</a:t></a:r></a:p></p:sld>`},
+				[2]string{"ppt/slides/_rels/slide2.xml.rels", `<Relationships/>`},
+			),
+			want: "Two\nTen\n",
+		},
+		{
+			name:     "opendocument text",
+			mimeType: "application/vnd.oasis.opendocument.text",
+			data: officePackage(t,
+				[2]string{"content.xml", `<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" ` +
+					`xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0"><office:body><office:text>` +
+					`<text:h>Title</text:h><text:p>Open<text:s/>document</text:p>` +
+					`</office:text></office:body></office:document-content>`},
+			),
+			want: "Title\nOpen document\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractText(tt.mimeType, tt.data)
+			if err != nil {
+				t.Fatalf("extractText failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("extractText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractTextOfficeInvalid(t *testing.T) {
+	if _, err := extractText(mimeDocx, []byte("not a zip")); err == nil {
+		t.Error("extractText succeeded on a corrupt docx, want an error")
+	}
+	broken := officePackage(t, [2]string{"word/document.xml", `<w:document><w:body><w:p>`})
+	if _, err := extractText(mimeDocx, broken); err == nil {
+		t.Error("extractText succeeded on truncated XML, want an error")
+	}
+}
+
+func TestExtractTextPlain(t *testing.T) {
+	got, err := extractText("text/plain; charset=utf-8", []byte("line\tone\nab\x00c\xff"))
+	if err != nil {
+		t.Fatalf("extractText failed: %v", err)
+	}
+	if want := "line\tone\nab c"; got != want {
+		t.Errorf("extractText = %q, want %q", got, want)
+	}
+
+	long := strings.Repeat("a", maxIndexedTextBytes+10)
+	got, err = extractText("text/markdown", []byte(long))
+	if err != nil {
+		t.Fatalf("extractText failed: %v", err)
+	}
+	if len(got) != maxIndexedTextBytes {
+		t.Errorf("extractText kept %d bytes, want %d", len(got), maxIndexedTextBytes)
+	}
+}
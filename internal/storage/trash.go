@@ -0,0 +1,319 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// getAncestors returns the chain of nodes from the root down to NodeID,
+// NodeID itself included.
+func (svc *Service) getAncestors(
+	ctx context.Context,
+	NodeID uuid.UUID,
+) ([]Node, error) {
+	var nodes []Node
+	err := svc.DB.WithContext(ctx).
+		Raw(`
+		WITH RECURSIVE ancestors AS (
+		SELECT nodes.*, 0 AS depth FROM nodes WHERE id = ?
+
+		UNION ALL
+
+		SELECT n.*, a.depth + 1 FROM nodes n JOIN
+		ancestors a ON n.id = a.parent_id
+		)
+
+		SELECT * FROM ancestors ORDER BY depth DESC;
+	`, NodeID).
+		Scan(&nodes).Error
+
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func nodePath(ancestors []Node) string {
+	names := make([]string, 0, len(ancestors))
+	for _, node := range ancestors {
+		names = append(names, node.Name)
+	}
+	return "/" + strings.Join(names, "/")
+}
+
+func setSubtreeStatus(tx *gorm.DB, NodeID uuid.UUID, from, to NodeStatus) error {
+	return tx.Exec(`
+	WITH RECURSIVE subtree AS (
+		SELECT id
+		FROM nodes
+		WHERE id = ?
+
+		UNION ALL
+
+		SELECT n.id
+		FROM nodes n
+		JOIN subtree s ON n.parent_id = s.id
+	)
+	UPDATE nodes SET status = ?
+	WHERE id IN (SELECT id FROM subtree) AND status = ?;
+	`, NodeID, to, from).Error
+}
+
+func (svc *Service) moveToTrash(
+	ctx context.Context,
+	node *Node,
+) (*TrashItem, error) {
+	ancestors, err := svc.getAncestors(ctx, node.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var size uint64
+	err = svc.DB.WithContext(ctx).
+		Raw(`
+		WITH RECURSIVE subtree AS (
+		SELECT id, size_bytes FROM nodes WHERE id = ?
+
+		UNION ALL
+
+		SELECT n.id, n.size_bytes FROM nodes n JOIN
+		subtree s ON n.parent_id = s.id
+		)
+
+		SELECT COALESCE(SUM(size_bytes), 0) FROM subtree;
+	`, node.ID).
+		Scan(&size).Error
+	if err != nil {
+		return nil, err
+	}
+
+	item := TrashItem{
+		ID:               uuid.New(),
+		OwnerID:          node.OwnerID,
+		NodeID:           node.ID,
+		Name:             node.Name,
+		Type:             node.Type,
+		SizeBytes:        size,
+		OriginalParentID: node.ParentID,
+		OriginalPath:     nodePath(ancestors),
+		DeletedAt:        time.Now(),
+	}
+
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := svc.chargeWorkspaceUsage(tx, &node.ID, -int64(size)); err != nil {
+			return err
+		}
+		if err := setSubtreeStatus(tx, node.ID, NodeStatusActive, NodeStatusTrashed); err != nil {
+			return err
+		}
+		// Detach the root so the trashed subtree no longer shows up under
+		// its parent or blocks its name there, nor inherits its access
+		if err := tx.Model(&Node{}).Where("id = ?", node.ID).Update("parent_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&item).Error; err != nil {
+			return err
+		}
+		return svc.reparentNode(ctx, tx, node, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func (svc *Service) getTrashItem(
+	ctx context.Context,
+	TrashID uuid.UUID,
+	UserID uint64,
+) (*TrashItem, error) {
+	var item TrashItem
+	err := svc.DB.WithContext(ctx).
+		Where("id = ? AND owner_id = ?", TrashID, UserID).
+		First(&item).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTrashItemNotFound
+		}
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (svc *Service) ListTrash(
+	ctx context.Context,
+	UserID uint64,
+) ([]TrashItem, error) {
+	var items []TrashItem
+	err := svc.DB.WithContext(ctx).
+		Where("owner_id = ?", UserID).
+		Order("deleted_at DESC").
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// RestoreTrash puts a trashed subtree back under its original parent, or
+// under ParentID when one is given.
+func (svc *Service) RestoreTrash(
+	ctx context.Context,
+	TrashID uuid.UUID,
+	UserID uint64,
+	ParentID uuid.UUID,
+	Strategy ConflictStrategy,
+) (*Node, error) {
+	if Strategy == ConflictOverwrite {
+		return nil, errors.New("overwrite is not supported when restoring from trash")
+	}
+
+	item, err := svc.getTrashItem(ctx, TrashID, UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	parentID := item.OriginalParentID
+	if ParentID != uuid.Nil {
+		parentID = &ParentID
+	}
+
+	if parentID != nil {
+		if err := svc.canWriteIntoDirectory(ctx, *parentID, UserID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrParentNodeNotFound
+			}
+			return nil, err
+		}
+	}
+
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		name, _, err := resolveNameConflict(tx, parentID, UserID, item.Name, item.Type, item.NodeID, Strategy)
+		if err != nil {
+			return err
+		}
+
+		if err := setSubtreeStatus(tx, item.NodeID, NodeStatusTrashed, NodeStatusActive); err != nil {
+			return err
+		}
+		var node Node
+		if err := tx.Where("id = ?", item.NodeID).First(&node).Error; err != nil {
+			return err
+		}
+		// Items trashed before the parent relationship was dropped on
+		// trashing still have it, deleting it again is harmless
+		if node.ParentID == nil {
+			node.ParentID = item.OriginalParentID
+		}
+		size, err := subtreeBytes(tx, item.NodeID)
+		if err != nil {
+			return err
+		}
+		if err := svc.chargeWorkspaceUsage(tx, parentID, int64(size)); err != nil {
+			return err
+		}
+		err = tx.Model(&Node{}).
+			Where("id = ?", item.NodeID).
+			Updates(map[string]interface{}{
+				"parent_id": parentID,
+				"name":      name,
+			}).Error
+		if err != nil {
+			return translateNameError(err)
+		}
+		if err := tx.Delete(&TrashItem{}, "id = ?", item.ID).Error; err != nil {
+			return err
+		}
+		return svc.reparentNode(ctx, tx, &node, parentID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return svc.GetNode(ctx, item.NodeID)
+}
+
+func (svc *Service) PurgeTrash(
+	ctx context.Context,
+	TrashID uuid.UUID,
+	UserID uint64,
+) error {
+	item, err := svc.getTrashItem(ctx, TrashID, UserID)
+	if err != nil {
+		return err
+	}
+	return svc.purgeTrashItem(ctx, item)
+}
+
+func (svc *Service) EmptyTrash(
+	ctx context.Context,
+	UserID uint64,
+) error {
+	items, err := svc.ListTrash(ctx, UserID)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		if err := svc.purgeTrashItem(ctx, &items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (svc *Service) purgeTrashItem(
+	ctx context.Context,
+	item *TrashItem,
+) error {
+	err := svc.purgeSubtree(ctx, item.NodeID, item.OwnerID)
+	if err != nil && !errors.Is(err, ErrNodeNotFound) {
+		return err
+	}
+	return svc.DB.WithContext(ctx).Delete(&TrashItem{}, "id = ?", item.ID).Error
+}
+
+// PurgeExpiredTrash permanently deletes everything that has been in the trash
+// for longer than the configured retention.
+func (svc *Service) PurgeExpiredTrash(ctx context.Context) error {
+	var expired []TrashItem
+	err := svc.DB.WithContext(ctx).
+		Where("deleted_at < ?", time.Now().Add(-svc.Cfg.Storage.TrashRetention)).
+		Find(&expired).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range expired {
+		if err := svc.purgeTrashItem(ctx, &expired[i]); err != nil {
+			return err
+		}
+	}
+
+	if len(expired) > 0 {
+		log.Printf("Purged %d expired trash items", len(expired))
+	}
+	return nil
+}
+
+func (svc *Service) StartTrashSweeper(ctx context.Context) {
+	ticker := time.NewTicker(svc.Cfg.Storage.TrashSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := svc.PurgeExpiredTrash(ctx); err != nil {
+				log.Println("Error purging expired trash: ", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+)
+
+func (h *Handler) ListTrash(
+	c echo.Context,
+) error {
+	ctx := c.Request().Context()
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	items, err := h.svc.ListTrash(ctx, user.ID)
+	if err != nil {
+		log.Println(err.Error())
+		return c.JSON(errorStatus(err), "error listing trash")
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+func (h *Handler) RestoreTrash(
+	c echo.Context,
+) error {
+	var req RestoreTrash
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+	trashId, err := uuid.Parse(req.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	parentId, err := uuid.Parse(req.ParentID)
+	if err != nil {
+		parentId = uuid.Nil
+	}
+	strategy, err := ParseConflictStrategy(req.Conflict)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if strategy == ConflictOverwrite {
+		return c.JSON(http.StatusBadRequest, "overwrite is not supported when restoring from trash")
+	}
+
+	node, err := h.svc.RestoreTrash(ctx, trashId, user.ID, parentId, strategy)
+	if err != nil {
+		log.Println(err.Error())
+		return c.JSON(errorStatus(err), "error restoring from trash")
+	}
+	return c.JSON(http.StatusOK, node)
+}
+
+func (h *Handler) PurgeTrash(
+	c echo.Context,
+) error {
+	var req PurgeTrash
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+	trashId, err := uuid.Parse(req.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+
+	if err := h.svc.PurgeTrash(ctx, trashId, user.ID); err != nil {
+		log.Println(err.Error())
+		return c.JSON(errorStatus(err), "error purging trash item")
+	}
+	return c.JSON(http.StatusAccepted, "permanently deleted")
+}
+
+func (h *Handler) EmptyTrash(
+	c echo.Context,
+) error {
+	ctx := c.Request().Context()
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	if err := h.svc.EmptyTrash(ctx, user.ID); err != nil {
+		log.Println(err.Error())
+		return c.JSON(errorStatus(err), "error emptying trash")
+	}
+	return c.JSON(http.StatusAccepted, "trash emptied")
+}
@@ -1,9 +1,15 @@
 package storage
 
 import (
-	"github.com/gabriel-vasile/mimetype"
 	"bytes"
+	"encoding/base64"
+	"errors"
 	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 func detectMimeType(r io.Reader) (string, io.Reader, error) {
@@ -18,4 +24,119 @@ func detectMimeType(r io.Reader) (string, io.Reader, error) {
 	newReader := io.MultiReader(&buf, r)
 
 	return mime.String(), newReader, nil
-}
\ No newline at end of file
+}
+
+// parseUploadMetadata decodes a tus Upload-Metadata header, a comma
+// separated list of "key base64(value)" pairs.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseByteRange parses a single "bytes=" range against an object of the
+// given size and returns the offset and length to read. Multiple ranges are
+// not supported and are reported as an error so callers can fall back to
+// serving the whole object.
+func parseByteRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, errors.New("unsupported range")
+	}
+
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errors.New("invalid range")
+	}
+
+	if startStr == "" {
+		// Suffix range, the last N bytes
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, 0, errors.New("invalid range")
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, suffix, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errors.New("invalid range")
+	}
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, errors.New("invalid range")
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, nil
+}
+
+// etagMatches reports whether an If-None-Match style header matches etag
+// using weak comparison.
+func etagMatches(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// translateNameError maps a violation of the sibling name unique index,
+// which can happen when two requests race for the same name, to
+// ErrNameConflict.
+func translateNameError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrNameConflict
+	}
+	return err
+}
+
+// alreadyCompressed reports whether deflating content of this MIME type is
+// unlikely to make it any smaller.
+func alreadyCompressed(mimeType string) bool {
+	switch {
+	case strings.HasPrefix(mimeType, "image/") && mimeType != "image/bmp" && mimeType != "image/svg+xml",
+		strings.HasPrefix(mimeType, "video/"),
+		strings.HasPrefix(mimeType, "audio/") && mimeType != "audio/wav":
+		return true
+	}
+	switch mimeType {
+	case "application/zip", "application/gzip", "application/x-bzip2", "application/x-xz",
+		"application/x-7z-compressed", "application/vnd.rar", "application/zstd":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseUploadMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "pairs",
+			header: "filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,filetype YXBwbGljYXRpb24vcGRm",
+			want:   map[string]string{"filename": "world_domination_plan.pdf", "filetype": "application/pdf"},
+		},
+		{
+			name:   "key without value",
+			header: "filename cmVwb3J0LnR4dA==, is_confidential",
+			want:   map[string]string{"filename": "report.txt", "is_confidential": ""},
+		},
+		{
+			name:   "empty entries",
+			header: " , filename YQ==,",
+			want:   map[string]string{"filename": "a"},
+		},
+		{
+			name:   "empty header",
+			header: "",
+			want:   map[string]string{},
+		},
+		{
+			name:    "invalid base64",
+			header:  "filename not-base64!",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseUploadMetadata(tt.header)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseUploadMetadata(%q) = %v, want an error", tt.header, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseUploadMetadata(%q) failed: %v", tt.header, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseUploadMetadata(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseByteRange(t *testing.T) {
+	const (
+		valid = iota
+		invalid
+		unsatisfiable
+	)
+	tests := []struct {
+		header string
+		size   int64
+		offset int64
+		length int64
+		result int
+	}{
+		{"bytes=0-9", 100, 0, 10, valid},
+		{"bytes=90-", 100, 90, 10, valid},
+		{"bytes=95-200", 100, 95, 5, valid},
+		{"bytes=-10", 100, 90, 10, valid},
+		{"bytes=-200", 100, 0, 100, valid},
+		{"bytes= 5-5", 100, 5, 1, valid},
+		{"bytes=100-", 100, 0, 0, unsatisfiable},
+		{"bytes=-0", 100, 0, 0, unsatisfiable},
+		{"bytes=-5", 0, 0, 0, unsatisfiable},
+		{"bytes=0-1,5-6", 100, 0, 0, invalid},
+		{"items=0-9", 100, 0, 0, invalid},
+		{"bytes=9-0", 100, 0, 0, invalid},
+		{"bytes=a-b", 100, 0, 0, invalid},
+		{"bytes=10", 100, 0, 0, invalid},
+		{"bytes=--5", 100, 0, 0, invalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			offset, length, err := parseByteRange(tt.header, tt.size)
+			switch tt.result {
+			case valid:
+				if err != nil {
+					t.Fatalf("parseByteRange(%q, %d) failed: %v", tt.header, tt.size, err)
+				}
+				if offset != tt.offset || length != tt.length {
+					t.Errorf("parseByteRange(%q, %d) = %d, %d, want %d, %d", tt.header, tt.size, offset, length, tt.offset, tt.length)
+				}
+			case unsatisfiable:
+				if !errors.Is(err, errRangeNotSatisfiable) {
+					t.Errorf("parseByteRange(%q, %d) error = %v, want %v", tt.header, tt.size, err, errRangeNotSatisfiable)
+				}
+			case invalid:
+				if err == nil || errors.Is(err, errRangeNotSatisfiable) {
+					t.Errorf("parseByteRange(%q, %d) error = %v, want an invalid range", tt.header, tt.size, err)
+				}
+			}
+		})
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		want   bool
+	}{
+		{`"abc"`, `"abc"`, true},
+		{`W/"abc"`, `"abc"`, true},
+		{`"abc"`, `W/"abc"`, true},
+		{`"x", "abc"`, `"abc"`, true},
+		{` * `, `"abc"`, true},
+		{`"x"`, `"abc"`, false},
+		{`abc`, `"abc"`, false},
+		{``, `"abc"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, tt.etag); got != tt.want {
+			t.Errorf("etagMatches(%q, %q) = %v, want %v", tt.header, tt.etag, got, tt.want)
+		}
+	}
+}
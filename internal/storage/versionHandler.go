@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+)
+
+func (h *Handler) ListVersions(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	versions, err := h.svc.ListVersions(ctx, id, user.ID)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error listing file versions")
+	}
+	return c.JSON(http.StatusOK, versions)
+}
+
+func (h *Handler) DownloadVersion(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	versionId, err := uuid.Parse(c.Param("version"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid version param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	stream, version, node, err := h.svc.GetVersionData(ctx, id, versionId, user.ID)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error getting data from storage service")
+	}
+	defer stream.Close()
+
+	mimeType := "application/octet-stream"
+	if version.MimeType != nil && *version.MimeType != "" {
+		mimeType = *version.MimeType
+	}
+
+	c.Response().Header().Set(
+		echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="%s"`, node.Name),
+	)
+	c.Response().Header().Set(echo.HeaderContentType, mimeType)
+	c.Response().WriteHeader(http.StatusOK)
+	_, err = io.Copy(c.Response().Writer, stream)
+	return err
+}
+
+func (h *Handler) UploadVersion(c echo.Context) error {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		log.Println(err.Error())
+		return c.JSON(http.StatusBadRequest, "missing file")
+	}
+	ctx := c.Request().Context()
+
+	id, err := uuid.Parse(c.FormValue("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, "cannot open file")
+	}
+	defer file.Close()
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	mimeType, newStream, err := h.svc.DetectMimeType(ctx, file)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid file stream")
+	}
+
+	node, err := h.svc.PutVersion(ctx, id, user.ID, uint64(fileHeader.Size), newStream, mimeType)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error writing new file version")
+	}
+	return c.JSON(http.StatusCreated, node)
+}
+
+func (h *Handler) RestoreVersion(c echo.Context) error {
+	var req RestoreVersion
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+	id, err := uuid.Parse(req.NodeID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	versionId, err := uuid.Parse(req.VersionID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid version_id param")
+	}
+
+	node, err := h.svc.RestoreVersion(ctx, id, versionId, user.ID)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error restoring file version")
+	}
+	return c.JSON(http.StatusOK, node)
+}
@@ -0,0 +1,542 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
+	"gorm.io/gorm"
+)
+
+// replaceContent points an existing file node at new content, keeping the
+// previous content as a version. The caller holds a reference on Key which
+// passes to the node. It returns the keys of objects that are no longer
+// referenced, to be deleted once the transaction commits.
+func (svc *Service) replaceContent(
+	tx *gorm.DB,
+	existing *Node,
+	Key string,
+	Bytes *uint64,
+	mimeType *string,
+	UploaderID uint64,
+) ([]string, error) {
+	if err := ensureBlob(tx, Key); err != nil {
+		return nil, err
+	}
+	if err := ensureUsage(tx, existing.OwnerID); err != nil {
+		return nil, err
+	}
+	// Same content as the current version, nothing to record
+	if existing.Key != nil && *existing.Key == Key {
+		return releaseBlobs(tx, []string{Key})
+	}
+
+	if err := recordCurrentVersion(tx, existing); err != nil {
+		return nil, err
+	}
+	var orphaned []string
+	if existing.Key != nil {
+		var err error
+		orphaned, err = releaseBlobs(tx, []string{*existing.Key})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Only what the file grows or shrinks by changes its workspace's usage
+	var current, replacement int64
+	if existing.SizeBytes != nil {
+		current = int64(*existing.SizeBytes)
+	}
+	if Bytes != nil {
+		replacement = int64(*Bytes)
+	}
+	if err := svc.chargeWorkspaceUsage(tx, &existing.ID, replacement-current); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err := tx.Model(&Node{}).
+		Where("id = ?", existing.ID).
+		Updates(map[string]interface{}{
+			"key":        Key,
+			"size_bytes": Bytes,
+			"mime_type":  mimeType,
+			"created_at": now,
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// The replaced content is still counted, as a previous version now
+	var size uint64
+	if Bytes != nil {
+		size = *Bytes
+	}
+	if err := svc.chargeUsage(tx, existing.OwnerID, int64(size)); err != nil {
+		return nil, err
+	}
+
+	if err := retainBlob(tx, Key, 1); err != nil {
+		return nil, err
+	}
+	version := FileVersion{
+		ID:         uuid.New(),
+		NodeID:     existing.ID,
+		Key:        Key,
+		MimeType:   mimeType,
+		SizeBytes:  size,
+		UploadedBy: UploaderID,
+		CreatedAt:  now,
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return nil, err
+	}
+
+	existing.Key = &Key
+	existing.SizeBytes = Bytes
+	existing.MimeType = mimeType
+	existing.CreatedAt = now
+
+	pruned, err := svc.pruneVersions(tx, existing)
+	if err != nil {
+		return nil, err
+	}
+	return append(orphaned, pruned...), nil
+}
+
+// recordCurrentVersion stores the node's content as its first version when
+// the file has no history yet, which is the case until it is overwritten.
+func recordCurrentVersion(tx *gorm.DB, node *Node) error {
+	if node.Key == nil {
+		return nil
+	}
+
+	var count int64
+	err := tx.Model(&FileVersion{}).
+		Where("node_id = ?", node.ID).
+		Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+
+	if err := retainBlob(tx, *node.Key, 1); err != nil {
+		return err
+	}
+	version := FileVersion{
+		ID:         uuid.New(),
+		NodeID:     node.ID,
+		Key:        *node.Key,
+		MimeType:   node.MimeType,
+		UploadedBy: node.OwnerID,
+		CreatedAt:  node.CreatedAt,
+	}
+	if node.SizeBytes != nil {
+		version.SizeBytes = *node.SizeBytes
+	}
+	return tx.Create(&version).Error
+}
+
+// pruneVersions drops the previous versions of a file that exceed the
+// configured count or age. The newest version is the current content and is
+// always kept.
+func (svc *Service) pruneVersions(
+	tx *gorm.DB,
+	node *Node,
+) ([]string, error) {
+	var versions []FileVersion
+	err := tx.Where("node_id = ?", node.ID).
+		Order("created_at DESC").
+		Find(&versions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	maxVersions := svc.Cfg.Storage.MaxFileVersions
+	retention := svc.Cfg.Storage.FileVersionRetention
+	cutoff := time.Now().Add(-retention)
+
+	var ids []uuid.UUID
+	var keys []string
+	var size int64
+	for i := 1; i < len(versions); i++ {
+		version := versions[i]
+		if (maxVersions > 0 && i > maxVersions) || (retention > 0 && version.CreatedAt.Before(cutoff)) {
+			ids = append(ids, version.ID)
+			keys = append(keys, version.Key)
+			size += int64(version.SizeBytes)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if err := svc.chargeUsage(tx, node.OwnerID, -size); err != nil {
+		return nil, err
+	}
+	orphaned, err := releaseBlobs(tx, keys)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Where("id IN ?", ids).Delete(&FileVersion{}).Error; err != nil {
+		return nil, err
+	}
+	return orphaned, nil
+}
+
+// mergeInto makes the content of source the newest version of existing and
+// deletes source along with its own history, for a file moved or renamed over
+// another one. It returns the keys of objects no longer referenced.
+func (svc *Service) mergeInto(
+	tx *gorm.DB,
+	source *Node,
+	existing *Node,
+	UploaderID uint64,
+) ([]string, error) {
+	if err := ensureUsage(tx, source.OwnerID); err != nil {
+		return nil, err
+	}
+	if source.SizeBytes != nil {
+		if err := svc.chargeWorkspaceUsage(tx, &source.ID, -int64(*source.SizeBytes)); err != nil {
+			return nil, err
+		}
+	}
+
+	staleKeys, err := svc.replaceContent(tx, existing, *source.Key, source.SizeBytes, source.MimeType, UploaderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []FileVersion
+	err = tx.Where("node_id = ?", source.ID).
+		Order("created_at DESC").
+		Find(&versions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	if source.SizeBytes != nil {
+		size = int64(*source.SizeBytes)
+	}
+	keys := make([]string, 0, len(versions))
+	for i, version := range versions {
+		keys = append(keys, version.Key)
+		// The newest version is the node's own content, already counted
+		if i > 0 {
+			size += int64(version.SizeBytes)
+		}
+	}
+
+	if err := svc.chargeUsage(tx, source.OwnerID, -size); err != nil {
+		return nil, err
+	}
+	orphaned, err := releaseBlobs(tx, keys)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Where("node_id = ?", source.ID).Delete(&FileVersion{}).Error; err != nil {
+		return nil, err
+	}
+	// Links to the file keep working, it lives on as the existing node
+	err = tx.Model(&ShareLink{}).
+		Where("node_id = ?", source.ID).
+		Update("node_id", existing.ID).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Where("node_id = ?", source.ID).Delete(&NodePermission{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("node_id = ?", source.ID).Delete(&NodeGroupPermission{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Delete(&Node{}, "id = ?", source.ID).Error; err != nil {
+		return nil, err
+	}
+	return append(staleKeys, orphaned...), nil
+}
+
+// canWriteFile allows anyone with write access to the file to change its
+// content.
+func (svc *Service) canWriteFile(
+	ctx context.Context,
+	node *Node,
+	UserID uint64,
+) error {
+	if node.Type != NodeTypeFile {
+		return ErrNodeIsDirectory
+	} else if node.Status != NodeStatusActive {
+		return ErrNodeNotFound
+	}
+	return svc.checkPermission(ctx, node, UserID, authorization.PermissionWrite)
+}
+
+func (svc *Service) ListVersions(
+	ctx context.Context,
+	NodeID uuid.UUID,
+	UserID uint64,
+) ([]FileVersion, error) {
+	node, err := svc.GetNode(ctx, NodeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := svc.checkNodeDeliverability(ctx, node, UserID); err != nil {
+		return nil, err
+	}
+
+	var versions []FileVersion
+	err = svc.DB.WithContext(ctx).
+		Where("node_id = ?", NodeID).
+		Order("created_at DESC").
+		Find(&versions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// A file that was never overwritten only has its current content
+	if len(versions) > 0 {
+		versions[0].Current = true
+	} else {
+		current := FileVersion{
+			ID:         node.ID,
+			NodeID:     node.ID,
+			Key:        *node.Key,
+			MimeType:   node.MimeType,
+			UploadedBy: node.OwnerID,
+			CreatedAt:  node.CreatedAt,
+			Current:    true,
+		}
+		if node.SizeBytes != nil {
+			current.SizeBytes = *node.SizeBytes
+		}
+		versions = append([]FileVersion{current}, versions...)
+	}
+
+	return versions, nil
+}
+
+func (svc *Service) getVersion(
+	ctx context.Context,
+	NodeID uuid.UUID,
+	VersionID uuid.UUID,
+	UserID uint64,
+) (*Node, *FileVersion, error) {
+	versions, err := svc.ListVersions(ctx, NodeID, UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range versions {
+		if versions[i].ID == VersionID {
+			node, err := svc.GetNode(ctx, NodeID)
+			if err != nil {
+				return nil, nil, err
+			}
+			return node, &versions[i], nil
+		}
+	}
+	return nil, nil, ErrVersionNotFound
+}
+
+func (svc *Service) GetVersionData(
+	ctx context.Context,
+	NodeID uuid.UUID,
+	VersionID uuid.UUID,
+	UserID uint64,
+) (io.ReadCloser, *FileVersion, *Node, error) {
+	node, version, err := svc.getVersion(ctx, NodeID, VersionID, UserID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stream, err := svc.Client.Get(ctx, svc.Cfg.Storage.BucketName, version.Key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return stream, version, node, nil
+}
+
+// PutVersion uploads new content for an existing file.
+func (svc *Service) PutVersion(
+	ctx context.Context,
+	NodeID uuid.UUID,
+	UserID uint64,
+	Bytes uint64,
+	data io.ReadCloser,
+	mimeType string,
+) (*Node, error) {
+	defer data.Close()
+
+	node, err := svc.GetNode(ctx, NodeID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNodeNotFound
+		}
+		return nil, err
+	}
+	if err := svc.canWriteFile(ctx, node, UserID); err != nil {
+		return nil, err
+	}
+	if err := svc.checkQuota(ctx, node.OwnerID, Bytes); err != nil {
+		return nil, err
+	}
+	if err := svc.checkWorkspaceGrowth(ctx, node, Bytes); err != nil {
+		return nil, err
+	}
+
+	uploadedKey, hash, err := svc.storeObject(ctx, data, Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var staleKeys []string
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		key, err := acquireBlob(tx, hash, uploadedKey, Bytes)
+		if err != nil {
+			return err
+		}
+		if key != uploadedKey {
+			staleKeys = append(staleKeys, uploadedKey)
+		}
+
+		orphaned, err := svc.replaceContent(tx, node, key, &Bytes, &mimeType, UserID)
+		staleKeys = append(staleKeys, orphaned...)
+		return err
+	})
+	if err != nil {
+		svc.deleteObjects(uploadedKey)
+		return nil, err
+	}
+
+	svc.deleteObjects(staleKeys...)
+	return node, nil
+}
+
+// RestoreVersion makes an older version the file's newest one, so the
+// history leading up to the restore is kept intact.
+func (svc *Service) RestoreVersion(
+	ctx context.Context,
+	NodeID uuid.UUID,
+	VersionID uuid.UUID,
+	UserID uint64,
+) (*Node, error) {
+	node, version, err := svc.getVersion(ctx, NodeID, VersionID, UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := svc.canWriteFile(ctx, node, UserID); err != nil {
+		return nil, err
+	}
+	if version.Current {
+		return node, nil
+	}
+	if err := svc.checkQuota(ctx, node.OwnerID, version.SizeBytes); err != nil {
+		return nil, err
+	}
+	if err := svc.checkWorkspaceGrowth(ctx, node, version.SizeBytes); err != nil {
+		return nil, err
+	}
+
+	size := version.SizeBytes
+	var staleKeys []string
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := retainBlob(tx, version.Key, 1); err != nil {
+			return err
+		}
+		var err error
+		staleKeys, err = svc.replaceContent(tx, node, version.Key, &size, version.MimeType, UserID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	svc.deleteObjects(staleKeys...)
+	return node, nil
+}
+
+// PruneExpiredVersions removes previous versions older than the configured
+// retention. The current content of a file is never pruned.
+func (svc *Service) PruneExpiredVersions(ctx context.Context) error {
+	retention := svc.Cfg.Storage.FileVersionRetention
+	if retention <= 0 {
+		return nil
+	}
+
+	var expired []FileVersion
+	err := svc.DB.WithContext(ctx).
+		Where(`created_at < ? AND created_at < (
+			SELECT max(latest.created_at) FROM file_versions latest
+			WHERE latest.node_id = file_versions.node_id
+		)`, time.Now().Add(-retention)).
+		Find(&expired).Error
+	if err != nil {
+		return err
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(expired))
+	keys := make([]string, 0, len(expired))
+	nodeIDs := make([]uuid.UUID, 0, len(expired))
+	for _, version := range expired {
+		ids = append(ids, version.ID)
+		keys = append(keys, version.Key)
+		nodeIDs = append(nodeIDs, version.NodeID)
+	}
+
+	var nodes []Node
+	if err := svc.DB.WithContext(ctx).Where("id IN ?", nodeIDs).Find(&nodes).Error; err != nil {
+		return err
+	}
+	owners := make(map[uuid.UUID]uint64, len(nodes))
+	for _, node := range nodes {
+		owners[node.ID] = node.OwnerID
+	}
+	freed := make(map[uint64]int64)
+	for _, version := range expired {
+		freed[owners[version.NodeID]] += int64(version.SizeBytes)
+	}
+
+	var orphaned []string
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for owner, size := range freed {
+			if err := svc.chargeUsage(tx, owner, -size); err != nil {
+				return err
+			}
+		}
+		var err error
+		orphaned, err = releaseBlobs(tx, keys)
+		if err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", ids).Delete(&FileVersion{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	svc.deleteObjects(orphaned...)
+
+	log.Printf("Pruned %d expired file versions", len(expired))
+	return nil
+}
+
+func (svc *Service) StartVersionPruner(ctx context.Context) {
+	ticker := time.NewTicker(svc.Cfg.Storage.VersionPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := svc.PruneExpiredVersions(ctx); err != nil {
+				log.Println("Error pruning file versions: ", err)
+			}
+		}
+	}
+}
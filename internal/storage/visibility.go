@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPublicLimit = 50
+	maxPublicLimit     = 200
+)
+
+func (visibility NodeVisibility) valid() bool {
+	switch visibility {
+	case VisibilityPrivate, VisibilityLink, VisibilityPublic:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetVisibility lets anyone, signed in or not, view a node and everything
+// below it, or makes it private again. Roles granted on the node are left
+// as they are.
+func (svc *Service) SetVisibility(
+	ctx context.Context,
+	UserID uint64,
+	NodeID uuid.UUID,
+	Visibility NodeVisibility,
+) (*Node, error) {
+	node, err := svc.manageableNode(ctx, NodeID, UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var touch, remove []authorization.Relationship
+	if Visibility == VisibilityPrivate {
+		remove = append(remove, everyoneViewer(node.ID))
+	} else {
+		touch = append(touch, everyoneViewer(node.ID))
+	}
+
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Node{}).
+			Where("id = ?", node.ID).
+			Update("visibility", Visibility).Error
+		if err != nil {
+			return err
+		}
+
+		token, err := svc.Authz.WriteRelationships(ctx, touch, remove)
+		if err != nil {
+			return err
+		}
+		node.ZedToken = &token
+		return storeZedToken(tx, token, []uuid.UUID{node.ID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	node.Visibility = Visibility
+	return node, nil
+}
+
+// GetReadableNode loads a live node the user may read. The user can be the
+// anonymous principal, who only reads what was made visible to everyone.
+func (svc *Service) GetReadableNode(
+	ctx context.Context,
+	UserID uint64,
+	NodeID uuid.UUID,
+) (*Node, error) {
+	node, err := svc.GetNode(ctx, NodeID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNodeNotFound
+		}
+		return nil, err
+	}
+	if node.Status != NodeStatusActive {
+		return nil, ErrNodeNotFound
+	}
+	if err := svc.checkPermission(ctx, node, UserID, authorization.PermissionRead); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// ListPublicNodes pages through the nodes published to everyone, newest
+// first. Nodes only visible to those with the link are left out.
+func (svc *Service) ListPublicNodes(
+	ctx context.Context,
+	Offset int,
+	Limit int,
+) ([]Node, error) {
+	if Limit <= 0 {
+		Limit = defaultPublicLimit
+	} else if Limit > maxPublicLimit {
+		Limit = maxPublicLimit
+	}
+	if Offset < 0 {
+		Offset = 0
+	}
+
+	nodes := []Node{}
+	err := svc.DB.WithContext(ctx).
+		Where("visibility = ? AND status = ?", VisibilityPublic, NodeStatusActive).
+		Order("created_at DESC").
+		Offset(Offset).
+		Limit(Limit).
+		Find(&nodes).Error
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
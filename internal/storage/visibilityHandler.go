@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+)
+
+func (h *Handler) SetVisibility(c echo.Context) error {
+	var req SetVisibility
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.NodeID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	visibility := NodeVisibility(req.Visibility)
+	if !visibility.valid() {
+		return c.JSON(http.StatusBadRequest, "invalid visibility param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	node, err := h.svc.SetVisibility(ctx, user.ID, id, visibility)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error changing visibility")
+	}
+	return c.JSON(http.StatusOK, node)
+}
+
+// PublicNode describes a node to anyone allowed to read it, anonymous
+// callers included.
+func (h *Handler) PublicNode(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	node, err := h.svc.GetReadableNode(ctx, user.ID, id)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error fetching node")
+	}
+	return c.JSON(http.StatusOK, node)
+}
+
+// PublicChildren lists a directory for anyone allowed to read it, anonymous
+// callers included.
+func (h *Handler) PublicChildren(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil || id == uuid.Nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	nodeList, err := h.svc.ListNodes(ctx, id, user.ID)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error fetching node list")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"list": &nodeList,
+	})
+}
+
+func (h *Handler) ListPublicNodes(c echo.Context) error {
+	var req ListPublicNodes
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid query params")
+	}
+
+	nodes, err := h.svc.ListPublicNodes(ctx, req.Offset, req.Limit)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error listing public nodes")
+	}
+	return c.JSON(http.StatusOK, nodes)
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+)
+
+func (h *Handler) CreateWorkspace(c echo.Context) error {
+	var req CreateWorkspace
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return c.JSON(http.StatusBadRequest, "missing name param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	workspace, err := h.svc.CreateWorkspace(ctx, user.ID, name)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error creating workspace")
+	}
+	return c.JSON(http.StatusCreated, workspace)
+}
+
+func (h *Handler) ListWorkspaces(c echo.Context) error {
+	ctx := c.Request().Context()
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	workspaces, err := h.svc.ListWorkspaces(ctx, user.ID)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error listing workspaces")
+	}
+	return c.JSON(http.StatusOK, workspaces)
+}
+
+func (h *Handler) RenameWorkspace(c echo.Context) error {
+	var req RenameWorkspace
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return c.JSON(http.StatusBadRequest, "missing name param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	workspace, err := h.svc.RenameWorkspace(ctx, user.ID, id, name)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error renaming workspace")
+	}
+	return c.JSON(http.StatusOK, workspace)
+}
+
+func (h *Handler) DeleteWorkspace(c echo.Context) error {
+	var req DeleteWorkspace
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "missing id param in request body")
+	}
+	id, err := uuid.Parse(req.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	if err := h.svc.DeleteWorkspace(ctx, user.ID, id); err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error deleting workspace")
+	}
+	return c.JSON(http.StatusOK, "workspace deleted")
+}
+
+func (h *Handler) AddWorkspaceMember(c echo.Context) error {
+	var req AddWorkspaceMember
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	member := strings.TrimSpace(req.User)
+	if member == "" {
+		return c.JSON(http.StatusBadRequest, "missing user param")
+	}
+	role := WorkspaceRole(req.Role)
+	if !role.assignable() {
+		return c.JSON(http.StatusBadRequest, "invalid role param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	added, err := h.svc.AddWorkspaceMember(ctx, user.ID, id, member, role)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error adding workspace member")
+	}
+	return c.JSON(http.StatusCreated, added)
+}
+
+func (h *Handler) RemoveWorkspaceMember(c echo.Context) error {
+	var req RemoveWorkspaceMember
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	if err := h.svc.RemoveWorkspaceMember(ctx, user.ID, id, req.UserID); err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error removing workspace member")
+	}
+	return c.JSON(http.StatusOK, "workspace member removed")
+}
+
+func (h *Handler) AddWorkspaceGroup(c echo.Context) error {
+	var req AddWorkspaceGroup
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	groupID, err := uuid.Parse(req.GroupID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid group_id param")
+	}
+	role := WorkspaceRole(req.Role)
+	if !role.assignable() {
+		return c.JSON(http.StatusBadRequest, "invalid role param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	added, err := h.svc.AddWorkspaceGroup(ctx, user.ID, id, groupID, role)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error adding group to workspace")
+	}
+	return c.JSON(http.StatusCreated, added)
+}
+
+func (h *Handler) RemoveWorkspaceGroup(c echo.Context) error {
+	var req RemoveWorkspaceGroup
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	groupID, err := uuid.Parse(req.GroupID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid group_id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	if err := h.svc.RemoveWorkspaceGroup(ctx, user.ID, id, groupID); err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error removing group from workspace")
+	}
+	return c.JSON(http.StatusOK, "group removed from workspace")
+}
+
+func (h *Handler) ListWorkspaceMembers(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	members, err := h.svc.ListWorkspaceMembers(ctx, user.ID, id)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error listing workspace members")
+	}
+	return c.JSON(http.StatusOK, members)
+}
+
+func (h *Handler) WorkspaceUsage(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	var user *authentication.CustomClaims = c.Get("user").(*authentication.CustomClaims)
+
+	usage, err := h.svc.GetWorkspaceUsage(ctx, user.ID, id)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error fetching workspace usage")
+	}
+	return c.JSON(http.StatusOK, usage)
+}
+
+func (h *Handler) SetWorkspaceQuota(c echo.Context) error {
+	var req SetWorkspaceQuota
+	ctx := c.Request().Context()
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid request body")
+	}
+	id, err := uuid.Parse(req.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "invalid id param")
+	}
+	if req.QuotaBytes != nil && *req.QuotaBytes < 0 {
+		return c.JSON(http.StatusBadRequest, "quota can't be negative")
+	}
+
+	if err := h.svc.SetWorkspaceQuota(ctx, id, req.QuotaBytes); err != nil {
+		log.Println(err)
+		return c.JSON(errorStatus(err), "error setting workspace quota")
+	}
+	return c.JSON(http.StatusAccepted, "workspace quota updated")
+}
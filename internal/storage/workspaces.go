@@ -0,0 +1,809 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authentication"
+	"github.com/sirkartik/cloud_drive_2.0/internal/authorization"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Roles the owner can hand out, the owner role stays with the creator
+var memberRoles = []WorkspaceRole{WorkspaceRoleAdmin, WorkspaceRoleEditor, WorkspaceRoleMember}
+
+func (role WorkspaceRole) assignable() bool {
+	for _, assignable := range memberRoles {
+		if role == assignable {
+			return true
+		}
+	}
+	return false
+}
+
+func workspaceRelationship(WorkspaceID uuid.UUID, UserID uint64, Role WorkspaceRole) authorization.Relationship {
+	return authorization.Relationship{
+		ResourceType: authorization.ResourceWorkspace,
+		ResourceID:   WorkspaceID.String(),
+		Relation:     string(Role),
+		SubjectType:  authorization.SubjectUser,
+		SubjectID:    userSubject(UserID),
+	}
+}
+
+func (svc *Service) checkWorkspacePermission(
+	ctx context.Context,
+	workspace *Workspace,
+	UserID uint64,
+	Permission string,
+) error {
+	zedToken, err := svc.workspaceZedToken(ctx, workspace.ID, UserID)
+	if err != nil {
+		return err
+	}
+	allowed, err := svc.Authz.CheckPermOnResource(
+		ctx,
+		authorization.SubjectUser, userSubject(UserID),
+		authorization.ResourceWorkspace, workspace.ID.String(),
+		Permission,
+		zedToken != "",
+		zedToken,
+	)
+	if err != nil {
+		return err
+	} else if !allowed {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// getWorkspace loads a workspace the user holds Permission on.
+func (svc *Service) getWorkspace(
+	ctx context.Context,
+	WorkspaceID uuid.UUID,
+	UserID uint64,
+	Permission string,
+) (*Workspace, error) {
+	var workspace Workspace
+	if err := svc.DB.WithContext(ctx).Where("id = ?", WorkspaceID).First(&workspace).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWorkspaceNotFound
+		}
+		return nil, err
+	}
+	if err := svc.checkWorkspacePermission(ctx, &workspace, UserID, Permission); err != nil {
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+// CreateWorkspace sets up a workspace owned by the user along with its root
+// directory.
+func (svc *Service) CreateWorkspace(
+	ctx context.Context,
+	UserID uint64,
+	Name string,
+) (*Workspace, error) {
+	if err := validateNodeName(Name); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var used int64
+	workspace := Workspace{
+		ID:        uuid.New(),
+		Name:      Name,
+		OwnerID:   UserID,
+		UsedBytes: &used,
+		CreatedAt: now,
+	}
+	root := Node{
+		ID:          uuid.New(),
+		OwnerID:     UserID,
+		Name:        Name,
+		Type:        NodeTypeDirectory,
+		CreatedAt:   now,
+		WorkspaceID: &workspace.ID,
+	}
+	workspace.RootID = root.ID
+
+	err := svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&root).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&workspace).Error; err != nil {
+			return err
+		}
+		err := tx.Create(&WorkspaceMembership{
+			WorkspaceID: workspace.ID,
+			UserID:      UserID,
+			Role:        WorkspaceRoleOwner,
+			AddedBy:     UserID,
+			CreatedAt:   now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		token, err := svc.Authz.WriteRelationships(ctx, []authorization.Relationship{
+			workspaceRelationship(workspace.ID, UserID, WorkspaceRoleOwner),
+			{
+				ResourceType: authorization.ResourceNode,
+				ResourceID:   root.ID.String(),
+				Relation:     authorization.RelationWorkspace,
+				SubjectType:  authorization.ResourceWorkspace,
+				SubjectID:    workspace.ID.String(),
+			},
+		}, nil)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&workspace).Updates(zedTokenUpdate(token)).Error; err != nil {
+			return err
+		}
+		workspace.ZedToken = &token
+		return svc.relateNodes(ctx, tx, &root)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+// ListWorkspaces returns the workspaces the user is a member of, directly or
+// through a group, with the strongest role they hold there.
+func (svc *Service) ListWorkspaces(
+	ctx context.Context,
+	UserID uint64,
+) ([]WorkspaceWithRole, error) {
+	var rows []WorkspaceWithRole
+	err := svc.DB.WithContext(ctx).
+		Raw(`
+		WITH RECURSIVE `+userGroupsCTE+`,
+		roles AS (
+		SELECT workspace_id, role FROM workspace_memberships WHERE user_id = ?
+
+		UNION ALL
+
+		SELECT workspace_id, role FROM workspace_group_memberships
+		WHERE group_id IN (SELECT id FROM user_groups)
+		)
+
+		SELECT workspaces.*, roles.role FROM workspaces
+		JOIN roles ON roles.workspace_id = workspaces.id
+		ORDER BY workspaces.name, workspaces.id;
+	`, UserID, UserID).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	workspaces := []WorkspaceWithRole{}
+	for _, row := range rows {
+		last := len(workspaces) - 1
+		if last >= 0 && workspaces[last].ID == row.ID {
+			if row.Role.rank() > workspaces[last].Role.rank() {
+				workspaces[last].Role = row.Role
+			}
+			continue
+		}
+		workspaces = append(workspaces, row)
+	}
+	return workspaces, nil
+}
+
+// rank orders roles from the weakest up.
+func (role WorkspaceRole) rank() int {
+	switch role {
+	case WorkspaceRoleOwner:
+		return 3
+	case WorkspaceRoleAdmin:
+		return 2
+	case WorkspaceRoleEditor:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// RenameWorkspace renames a workspace and its root directory with it.
+func (svc *Service) RenameWorkspace(
+	ctx context.Context,
+	UserID uint64,
+	WorkspaceID uuid.UUID,
+	Name string,
+) (*Workspace, error) {
+	if err := validateNodeName(Name); err != nil {
+		return nil, err
+	}
+	workspace, err := svc.getWorkspace(ctx, WorkspaceID, UserID, authorization.PermissionExecute)
+	if err != nil {
+		return nil, err
+	}
+
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Workspace{}).Where("id = ?", workspace.ID).Update("name", Name).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Node{}).Where("id = ?", workspace.RootID).Update("name", Name).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	workspace.Name = Name
+	return workspace, nil
+}
+
+// DeleteWorkspace permanently deletes a workspace and everything in it. Only
+// the owner can do that.
+func (svc *Service) DeleteWorkspace(
+	ctx context.Context,
+	UserID uint64,
+	WorkspaceID uuid.UUID,
+) error {
+	workspace, err := svc.getWorkspace(ctx, WorkspaceID, UserID, authorization.PermissionRead)
+	if err != nil {
+		return err
+	}
+	if workspace.OwnerID != UserID {
+		return ErrUnauthorized
+	}
+
+	// Files go first, a failure leaves a workspace that can be deleted again
+	if err := svc.purgeSubtree(ctx, workspace.RootID, workspace.OwnerID); err != nil && !errors.Is(err, ErrNodeNotFound) {
+		return err
+	}
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("workspace_id = ?", workspace.ID).Delete(&WorkspaceMembership{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("workspace_id = ?", workspace.ID).Delete(&WorkspaceGroupMembership{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Workspace{}, "id = ?", workspace.ID).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.Authz.DeleteResourceRelationships(ctx, authorization.ResourceWorkspace, []string{workspace.ID.String()})
+	if err != nil {
+		log.Printf("Failed to delete relationships of workspace %s: %v", workspace.ID, err)
+	}
+	return nil
+}
+
+// AddWorkspaceMember adds the user going by Member as username or email to a
+// workspace. Adding an existing member again changes their role.
+func (svc *Service) AddWorkspaceMember(
+	ctx context.Context,
+	UserID uint64,
+	WorkspaceID uuid.UUID,
+	Member string,
+	Role WorkspaceRole,
+) (*WorkspaceMember, error) {
+	if !Role.assignable() {
+		return nil, ErrWorkspaceOwner
+	}
+	workspace, err := svc.getWorkspace(ctx, WorkspaceID, UserID, authorization.PermissionExecute)
+	if err != nil {
+		return nil, err
+	}
+
+	var user authentication.User
+	err = svc.DB.WithContext(ctx).
+		Where("username = ? OR email = ?", Member, Member).
+		First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if user.ID == workspace.OwnerID {
+		return nil, ErrWorkspaceOwner
+	}
+
+	var touch, remove []authorization.Relationship
+	for _, role := range memberRoles {
+		if role == Role {
+			touch = append(touch, workspaceRelationship(workspace.ID, user.ID, role))
+		} else {
+			remove = append(remove, workspaceRelationship(workspace.ID, user.ID, role))
+		}
+	}
+
+	membership := WorkspaceMembership{
+		WorkspaceID: workspace.ID,
+		UserID:      user.ID,
+		Role:        Role,
+		AddedBy:     UserID,
+		CreatedAt:   time.Now(),
+	}
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "workspace_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"role", "added_by"}),
+		}).Create(&membership).Error
+		if err != nil {
+			return err
+		}
+
+		token, err := svc.Authz.WriteRelationships(ctx, touch, remove)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Workspace{}).Where("id = ?", workspace.ID).Updates(zedTokenUpdate(token)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &WorkspaceMember{
+		UserID:   user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Role:     Role,
+		AddedAt:  membership.CreatedAt,
+	}, nil
+}
+
+// RemoveWorkspaceMember takes a member out of a workspace. Members can always
+// leave, removing someone else needs admin rights.
+func (svc *Service) RemoveWorkspaceMember(
+	ctx context.Context,
+	UserID uint64,
+	WorkspaceID uuid.UUID,
+	MemberID uint64,
+) error {
+	permission := authorization.PermissionExecute
+	if MemberID == UserID {
+		permission = authorization.PermissionRead
+	}
+	workspace, err := svc.getWorkspace(ctx, WorkspaceID, UserID, permission)
+	if err != nil {
+		return err
+	}
+	if MemberID == workspace.OwnerID {
+		return ErrWorkspaceOwner
+	}
+
+	remove := make([]authorization.Relationship, 0, len(memberRoles))
+	for _, role := range memberRoles {
+		remove = append(remove, workspaceRelationship(workspace.ID, MemberID, role))
+	}
+
+	return svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("workspace_id = ? AND user_id = ?", workspace.ID, MemberID).
+			Delete(&WorkspaceMembership{})
+		if result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return ErrAccessNotFound
+		}
+
+		token, err := svc.Authz.WriteRelationships(ctx, nil, remove)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Workspace{}).Where("id = ?", workspace.ID).Updates(zedTokenUpdate(token)).Error
+	})
+}
+
+func (svc *Service) ListWorkspaceMembers(
+	ctx context.Context,
+	UserID uint64,
+	WorkspaceID uuid.UUID,
+) ([]WorkspaceMember, error) {
+	workspace, err := svc.getWorkspace(ctx, WorkspaceID, UserID, authorization.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []WorkspaceMember{}
+	err = svc.DB.WithContext(ctx).
+		Table("workspace_memberships").
+		Select("users.id AS user_id, users.username, users.email, workspace_memberships.role, workspace_memberships.created_at AS added_at").
+		Joins("JOIN users ON users.id = workspace_memberships.user_id").
+		Where("workspace_memberships.workspace_id = ?", workspace.ID).
+		Order("workspace_memberships.created_at").
+		Scan(&members).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		ID        uuid.UUID
+		Name      string
+		Role      WorkspaceRole
+		CreatedAt time.Time
+	}
+	err = svc.DB.WithContext(ctx).
+		Table("workspace_group_memberships").
+		Select("groups.id, groups.name, workspace_group_memberships.role, workspace_group_memberships.created_at").
+		Joins("JOIN groups ON groups.id = workspace_group_memberships.group_id").
+		Where("workspace_group_memberships.workspace_id = ?", workspace.ID).
+		Order("workspace_group_memberships.created_at").
+		Scan(&groups).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		members = append(members, WorkspaceMember{
+			GroupID:   &groups[i].ID,
+			GroupName: groups[i].Name,
+			Role:      groups[i].Role,
+			AddedAt:   groups[i].CreatedAt,
+		})
+	}
+	return members, nil
+}
+
+// AddWorkspaceGroup gives every member of a group a role on a workspace.
+// Adding the group again changes its role.
+func (svc *Service) AddWorkspaceGroup(
+	ctx context.Context,
+	UserID uint64,
+	WorkspaceID uuid.UUID,
+	GroupID uuid.UUID,
+	Role WorkspaceRole,
+) (*WorkspaceMember, error) {
+	if !Role.assignable() {
+		return nil, ErrWorkspaceOwner
+	}
+	workspace, err := svc.getWorkspace(ctx, WorkspaceID, UserID, authorization.PermissionExecute)
+	if err != nil {
+		return nil, err
+	}
+	group, err := svc.getGroup(ctx, GroupID, UserID, authorization.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+
+	var touch, remove []authorization.Relationship
+	for _, role := range memberRoles {
+		relationship := groupMembers(authorization.ResourceWorkspace, workspace.ID.String(), string(role), group.ID)
+		if role == Role {
+			touch = append(touch, relationship)
+		} else {
+			remove = append(remove, relationship)
+		}
+	}
+
+	membership := WorkspaceGroupMembership{
+		WorkspaceID: workspace.ID,
+		GroupID:     group.ID,
+		Role:        Role,
+		AddedBy:     UserID,
+		CreatedAt:   time.Now(),
+	}
+	err = svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "workspace_id"}, {Name: "group_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"role", "added_by"}),
+		}).Create(&membership).Error
+		if err != nil {
+			return err
+		}
+
+		token, err := svc.Authz.WriteRelationships(ctx, touch, remove)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Workspace{}).Where("id = ?", workspace.ID).Updates(zedTokenUpdate(token)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &WorkspaceMember{
+		GroupID:   &group.ID,
+		GroupName: group.Name,
+		Role:      Role,
+		AddedAt:   membership.CreatedAt,
+	}, nil
+}
+
+func (svc *Service) RemoveWorkspaceGroup(
+	ctx context.Context,
+	UserID uint64,
+	WorkspaceID uuid.UUID,
+	GroupID uuid.UUID,
+) error {
+	workspace, err := svc.getWorkspace(ctx, WorkspaceID, UserID, authorization.PermissionExecute)
+	if err != nil {
+		return err
+	}
+
+	remove := make([]authorization.Relationship, 0, len(memberRoles))
+	for _, role := range memberRoles {
+		remove = append(remove, groupMembers(authorization.ResourceWorkspace, workspace.ID.String(), string(role), GroupID))
+	}
+
+	return svc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("workspace_id = ? AND group_id = ?", workspace.ID, GroupID).
+			Delete(&WorkspaceGroupMembership{})
+		if result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return ErrAccessNotFound
+		}
+
+		token, err := svc.Authz.WriteRelationships(ctx, nil, remove)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Workspace{}).Where("id = ?", workspace.ID).Updates(zedTokenUpdate(token)).Error
+	})
+}
+
+// A workspace's usage is the size of the files below its root, pending
+// uploads included as their bytes are on the way. Trashed subtrees are
+// detached from the root and stop counting until restored. The counter is
+// charged in the transactions that change it; workspaces without one get it
+// computed from their nodes on first use, so ensureWorkspaceUsage must run
+// before the rows being accounted for change.
+
+// workspaceIDOf returns the workspace a node is in, nil for personal drives
+// and trashed subtrees.
+func workspaceIDOf(tx *gorm.DB, NodeID uuid.UUID) (*uuid.UUID, error) {
+	var workspaceIDs []uuid.UUID
+	err := tx.Raw(`
+		WITH RECURSIVE ancestors AS (
+		SELECT id, parent_id, workspace_id FROM nodes WHERE id = ?
+
+		UNION ALL
+
+		SELECT n.id, n.parent_id, n.workspace_id FROM nodes n JOIN
+		ancestors a ON n.id = a.parent_id
+		)
+
+		SELECT workspace_id FROM ancestors
+		WHERE parent_id IS NULL AND workspace_id IS NOT NULL;
+	`, NodeID).
+		Scan(&workspaceIDs).Error
+	if err != nil || len(workspaceIDs) == 0 {
+		return nil, err
+	}
+	return &workspaceIDs[0], nil
+}
+
+func ensureWorkspaceUsage(tx *gorm.DB, WorkspaceID uuid.UUID) error {
+	return tx.Exec(`
+	WITH RECURSIVE subtree AS (
+	SELECT n.id, n.type, n.size_bytes FROM nodes n
+	JOIN workspaces w ON w.root_id = n.id
+	WHERE w.id = ?
+
+	UNION ALL
+
+	SELECT n.id, n.type, n.size_bytes FROM nodes n JOIN
+	subtree s ON n.parent_id = s.id
+	)
+
+	UPDATE workspaces
+	SET used_bytes = (SELECT COALESCE(SUM(size_bytes), 0) FROM subtree WHERE type = ?)
+	WHERE id = ? AND used_bytes IS NULL;
+	`, WorkspaceID, NodeTypeFile, WorkspaceID).Error
+}
+
+// chargeWorkspaceUsage adds Delta to the usage of the workspace NodeID is in,
+// if any. Growth past the quota is refused by the update itself, so
+// concurrent writes can't take a workspace over it between check and charge.
+func (svc *Service) chargeWorkspaceUsage(
+	tx *gorm.DB,
+	NodeID *uuid.UUID,
+	Delta int64,
+) error {
+	if NodeID == nil {
+		return nil
+	}
+	workspaceID, err := workspaceIDOf(tx, *NodeID)
+	if err != nil || workspaceID == nil {
+		return err
+	}
+	if err := ensureWorkspaceUsage(tx, *workspaceID); err != nil {
+		return err
+	}
+	if Delta == 0 {
+		return nil
+	}
+
+	query := tx.Model(&Workspace{}).Where("id = ?", *workspaceID)
+	if Delta > 0 {
+		quota := svc.Cfg.Storage.DefaultWorkspaceQuotaBytes
+		query = query.Where(
+			"(COALESCE(quota_bytes, ?) <= 0 OR used_bytes + ? <= COALESCE(quota_bytes, ?))",
+			quota, Delta, quota,
+		)
+	}
+	result := query.Update("used_bytes", gorm.Expr("used_bytes + ?", Delta))
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 && Delta > 0 {
+		return ErrWorkspaceQuota
+	}
+	return nil
+}
+
+// subtreeBytes adds up the files in a subtree, pending uploads included.
+func subtreeBytes(tx *gorm.DB, NodeID uuid.UUID) (uint64, error) {
+	var size uint64
+	err := tx.Raw(`
+		WITH RECURSIVE subtree AS (
+		SELECT id, type, size_bytes FROM nodes WHERE id = ?
+
+		UNION ALL
+
+		SELECT n.id, n.type, n.size_bytes FROM nodes n JOIN
+		subtree s ON n.parent_id = s.id
+		)
+
+		SELECT COALESCE(SUM(size_bytes), 0) FROM subtree WHERE type = ?;
+	`, NodeID, NodeTypeFile).
+		Scan(&size).Error
+	return size, err
+}
+
+// workspaceOf returns the workspace a node is in with its usage counted, nil
+// for personal drives.
+func (svc *Service) workspaceOf(
+	ctx context.Context,
+	NodeID uuid.UUID,
+) (*Workspace, error) {
+	db := svc.DB.WithContext(ctx)
+	workspaceID, err := workspaceIDOf(db, NodeID)
+	if err != nil || workspaceID == nil {
+		return nil, err
+	}
+	return svc.countedWorkspace(ctx, *workspaceID)
+}
+
+func (svc *Service) countedWorkspace(
+	ctx context.Context,
+	WorkspaceID uuid.UUID,
+) (*Workspace, error) {
+	db := svc.DB.WithContext(ctx)
+	if err := ensureWorkspaceUsage(db, WorkspaceID); err != nil {
+		return nil, err
+	}
+
+	var workspace Workspace
+	err := db.Where("id = ?", WorkspaceID).First(&workspace).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+func (svc *Service) workspaceQuotaOf(workspace *Workspace) int64 {
+	if workspace.QuotaBytes != nil {
+		return *workspace.QuotaBytes
+	}
+	return svc.Cfg.Storage.DefaultWorkspaceQuotaBytes
+}
+
+func workspaceUsedBytes(workspace *Workspace) int64 {
+	if workspace.UsedBytes == nil {
+		return 0
+	}
+	return *workspace.UsedBytes
+}
+
+// checkWorkspaceQuota rejects writes of Bytes into ParentID early when its
+// workspace is already too full for them, before anything is uploaded. The
+// charge in the write's transaction has the final say.
+func (svc *Service) checkWorkspaceQuota(
+	ctx context.Context,
+	ParentID uuid.UUID,
+	Bytes uint64,
+) error {
+	if ParentID == uuid.Nil {
+		return nil
+	}
+	workspace, err := svc.workspaceOf(ctx, ParentID)
+	if err != nil || workspace == nil {
+		return err
+	}
+
+	quota := svc.workspaceQuotaOf(workspace)
+	if quota > 0 && workspaceUsedBytes(workspace)+int64(Bytes) > quota {
+		return ErrWorkspaceQuota
+	}
+	return nil
+}
+
+// checkWorkspaceGrowth is checkWorkspaceQuota for new content of a file,
+// where only what it grows by is added to the workspace.
+func (svc *Service) checkWorkspaceGrowth(
+	ctx context.Context,
+	node *Node,
+	Bytes uint64,
+) error {
+	var current uint64
+	if node.SizeBytes != nil {
+		current = *node.SizeBytes
+	}
+	if node.ParentID == nil || Bytes <= current {
+		return nil
+	}
+	return svc.checkWorkspaceQuota(ctx, *node.ParentID, Bytes-current)
+}
+
+// moveWorkspaceUsage charges a subtree moved to DestinationID to the
+// workspace it lands in, and credits the one it leaves, unless both are the
+// same.
+func (svc *Service) moveWorkspaceUsage(
+	tx *gorm.DB,
+	NodeID uuid.UUID,
+	DestinationID *uuid.UUID,
+) error {
+	source, err := workspaceIDOf(tx, NodeID)
+	if err != nil {
+		return err
+	}
+	var destination *uuid.UUID
+	if DestinationID != nil {
+		if destination, err = workspaceIDOf(tx, *DestinationID); err != nil {
+			return err
+		}
+	}
+	if source == nil && destination == nil {
+		return nil
+	} else if source != nil && destination != nil && *source == *destination {
+		return nil
+	}
+
+	size, err := subtreeBytes(tx, NodeID)
+	if err != nil {
+		return err
+	}
+	if err := svc.chargeWorkspaceUsage(tx, &NodeID, -int64(size)); err != nil {
+		return err
+	}
+	return svc.chargeWorkspaceUsage(tx, DestinationID, int64(size))
+}
+
+func (svc *Service) GetWorkspaceUsage(
+	ctx context.Context,
+	UserID uint64,
+	WorkspaceID uuid.UUID,
+) (*WorkspaceUsage, error) {
+	if _, err := svc.getWorkspace(ctx, WorkspaceID, UserID, authorization.PermissionRead); err != nil {
+		return nil, err
+	}
+	workspace, err := svc.countedWorkspace(ctx, WorkspaceID)
+	if err != nil {
+		return nil, err
+	} else if workspace == nil {
+		return nil, ErrWorkspaceNotFound
+	}
+	return &WorkspaceUsage{
+		UsedBytes:  workspaceUsedBytes(workspace),
+		QuotaBytes: svc.workspaceQuotaOf(workspace),
+	}, nil
+}
+
+// SetWorkspaceQuota overrides the default quota of a workspace, nil restores
+// the default.
+func (svc *Service) SetWorkspaceQuota(
+	ctx context.Context,
+	WorkspaceID uuid.UUID,
+	QuotaBytes *int64,
+) error {
+	result := svc.DB.WithContext(ctx).
+		Model(&Workspace{}).
+		Where("id = ?", WorkspaceID).
+		Update("quota_bytes", QuotaBytes)
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return ErrWorkspaceNotFound
+	}
+	return nil
+}